@@ -0,0 +1,83 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// AnycastIPPoolSpec defines the address allocated for nodes selected by the pool.
+// Fields left empty fall back to the aia section of the controller config.
+type AnycastIPPoolSpec struct {
+	// NodeSelector selects the nodes that get addresses from this pool, an empty selector selects all nodes.
+	// Nodes must also have the labels in node.labels of the controller config to be processed.
+	// +optional
+	NodeSelector *metav1.LabelSelector `json:"nodeSelector,omitempty"`
+
+	// Priority decides which pool is used when a node is selected by more than one pool, the higher wins.
+	// Pools with the same priority are ordered by name.
+	// +optional
+	Priority int32 `json:"priority,omitempty"`
+
+	// AddressType is the type of the address, one of AnycastEIP, HighQualityEIP or EIP.
+	// +kubebuilder:validation:Enum=AnycastEIP;HighQualityEIP;EIP
+	// +optional
+	AddressType string `json:"addressType,omitempty"`
+
+	// Bandwidth is the max outbound bandwidth of the address in Mbps.
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+
+	// AnycastZone is the publishing zone of AnycastEIP, ANYCAST_ZONE_OVERSEAS or ANYCAST_ZONE_GLOBAL.
+	// +optional
+	AnycastZone string `json:"anycastZone,omitempty"`
+
+	// InternetChargeType is the charge type of the address, such as TRAFFIC_POSTPAID_BY_HOUR,
+	// BANDWIDTH_POSTPAID_BY_HOUR or BANDWIDTH_PACKAGE.
+	// +optional
+	InternetChargeType string `json:"internetChargeType,omitempty"`
+
+	// Tags are added to the address besides the tags in the controller config.
+	// +optional
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// WarmPoolSize is how many standby addresses are kept allocated for the pool, a new node selected by
+	// the pool gets one of them without waiting for the allocation.
+	// +kubebuilder:validation:Minimum=0
+	// +kubebuilder:validation:Maximum=100
+	// +optional
+	WarmPoolSize int32 `json:"warmPoolSize,omitempty"`
+}
+
+// AnycastIPPoolStatus defines the observed state of AnycastIPPool
+type AnycastIPPoolStatus struct {
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=aipool
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.spec.addressType`
+// +kubebuilder:printcolumn:name="Bandwidth",type=integer,JSONPath=`.spec.bandwidth`
+// +kubebuilder:printcolumn:name="Priority",type=integer,JSONPath=`.spec.priority`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// AnycastIPPool describes the addresses to allocate for a group of nodes
+type AnycastIPPool struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   AnycastIPPoolSpec   `json:"spec,omitempty"`
+	Status AnycastIPPoolStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// AnycastIPPoolList contains a list of AnycastIPPool
+type AnycastIPPoolList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []AnycastIPPool `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&AnycastIPPool{}, &AnycastIPPoolList{})
+}
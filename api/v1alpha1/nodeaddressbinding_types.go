@@ -0,0 +1,158 @@
+package v1alpha1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// BindingPhase is the phase of a NodeAddressBinding
+type BindingPhase string
+
+const (
+	// BindingPhaseAllocating means the node needs an address and it has not been allocated yet
+	BindingPhaseAllocating BindingPhase = "Allocating"
+	// BindingPhaseAssociating means the address has been allocated and is being associated with the node
+	BindingPhaseAssociating BindingPhase = "Associating"
+	// BindingPhaseBound means the address is associated with the node
+	BindingPhaseBound BindingPhase = "Bound"
+	// BindingPhaseDetached means the node lost the aia labels and the address was disassociated but kept for it
+	BindingPhaseDetached BindingPhase = "Detached"
+	// BindingPhaseReleasing means the node has been deleted and the address is being released
+	BindingPhaseReleasing BindingPhase = "Releasing"
+	// BindingPhaseFailed means the node can not get an address, e.g. it already has a public address of another type
+	BindingPhaseFailed BindingPhase = "Failed"
+	// BindingPhaseMigrating means the address of the node is being replaced by an address of another type
+	BindingPhaseMigrating BindingPhase = "Migrating"
+)
+
+// MigrationStep is the step an address type migration is at
+type MigrationStep string
+
+const (
+	// MigrationStepDisassociating means the node has been tainted and the old address is being disassociated
+	MigrationStepDisassociating MigrationStep = "Disassociating"
+	// MigrationStepReleasing means the old address has been disassociated and is being released
+	MigrationStepReleasing MigrationStep = "Releasing"
+	// MigrationStepAllocating means the old address is gone and the new one is being allocated and associated
+	MigrationStepAllocating MigrationStep = "Allocating"
+	// MigrationStepCompleted means the new address is bound and the node untainted
+	MigrationStepCompleted MigrationStep = "Completed"
+)
+
+// AddressMigration records the replacement of the address of a node by an address of another type,
+// so that an interrupted migration resumes from the step it was at
+type AddressMigration struct {
+	Step MigrationStep `json:"step"`
+
+	FromAddressID string `json:"fromAddressID"`
+
+	// +optional
+	FromAddressIP string `json:"fromAddressIP,omitempty"`
+
+	// +optional
+	FromAddressType string `json:"fromAddressType,omitempty"`
+
+	ToAddressType string `json:"toAddressType"`
+
+	// ReleaseFromAddress is whether the old address is released after it is disassociated, otherwise it is kept.
+	// +optional
+	ReleaseFromAddress bool `json:"releaseFromAddress,omitempty"`
+
+	// +optional
+	StartTime *metav1.Time `json:"startTime,omitempty"`
+
+	// +optional
+	CompletionTime *metav1.Time `json:"completionTime,omitempty"`
+}
+
+// NodeAddressBindingSpec identifies the node of the binding
+type NodeAddressBindingSpec struct {
+	// NodeName is the name of the node, the same as the name of the binding.
+	NodeName string `json:"nodeName"`
+
+	// InstanceID is the cvm instance id of the node.
+	// +optional
+	InstanceID string `json:"instanceID,omitempty"`
+
+	// StaticAddressID is an existing address assigned to the node instead of allocating one, it must be UNBIND
+	// and of the address type of the node. The node annotation tke.cloud.tencent.com/anycast-ip-static-id
+	// takes precedence.
+	// +optional
+	StaticAddressID string `json:"staticAddressID,omitempty"`
+
+	// StaticReleasePolicy decides whether the static address is detached or released when the node is deleted,
+	// the aia config decides if empty.
+	// +kubebuilder:validation:Enum=Detach;Release
+	// +optional
+	StaticReleasePolicy string `json:"staticReleasePolicy,omitempty"`
+}
+
+// NodeAddressBindingStatus records the address of the node as observed from tencent cloud
+type NodeAddressBindingStatus struct {
+	// +optional
+	Phase BindingPhase `json:"phase,omitempty"`
+
+	// +optional
+	AddressID string `json:"addressID,omitempty"`
+
+	// +optional
+	AddressIP string `json:"addressIP,omitempty"`
+
+	// +optional
+	AddressType string `json:"addressType,omitempty"`
+
+	// Bandwidth is the max outbound bandwidth of the address in Mbps.
+	// +optional
+	Bandwidth int64 `json:"bandwidth,omitempty"`
+
+	// Pool is the name of the AnycastIPPool the address was allocated from, empty for the aia config.
+	// +optional
+	Pool string `json:"pool,omitempty"`
+
+	// LastError is the last error met when processing the binding, cleared once the address is bound.
+	// +optional
+	LastError string `json:"lastError,omitempty"`
+
+	// LastTransitionTime is the last time the phase changed.
+	// +optional
+	LastTransitionTime *metav1.Time `json:"lastTransitionTime,omitempty"`
+
+	// BoundTime is the time the address was found associated with the node.
+	// +optional
+	BoundTime *metav1.Time `json:"boundTime,omitempty"`
+
+	// Migration is the last address type migration of the node.
+	// +optional
+	Migration *AddressMigration `json:"migration,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster,shortName=nab
+// +kubebuilder:subresource:status
+// +kubebuilder:printcolumn:name="Address",type=string,JSONPath=`.status.addressID`
+// +kubebuilder:printcolumn:name="IP",type=string,JSONPath=`.status.addressIP`
+// +kubebuilder:printcolumn:name="Type",type=string,JSONPath=`.status.addressType`
+// +kubebuilder:printcolumn:name="Phase",type=string,JSONPath=`.status.phase`
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// NodeAddressBinding records the address owned by a node, it is created and deleted by aia-ip-controller.
+// It has no owner reference to the node, because the address must be released before the record is deleted.
+type NodeAddressBinding struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   NodeAddressBindingSpec   `json:"spec,omitempty"`
+	Status NodeAddressBindingStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// NodeAddressBindingList contains a list of NodeAddressBinding
+type NodeAddressBindingList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []NodeAddressBinding `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&NodeAddressBinding{}, &NodeAddressBindingList{})
+}
@@ -3,6 +3,11 @@ package config
 import (
 	"fmt"
 	"strings"
+	"time"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
 )
 
 // ControllerConfig contains the controller configuration.
@@ -11,6 +16,31 @@ type ControllerConfig struct {
 	ConfigFileConf                         *YamlValueConfig
 	MaxAiaIpControllerConcurrentReconciles int
 	EnableReverseReconcile                 bool
+	EnableNodeFinalizer                    bool
+	NodeFinalizerTimeout                   time.Duration
+	AsyncPollInterval                      time.Duration
+	AddressSyncPeriod                      time.Duration
+	DryRun                                 bool
+	EnableLeaderElection                   bool
+	LeaseDuration                          time.Duration
+	// EnableAnycastIPPool is set if the AnycastIPPool crd is installed in the cluster
+	EnableAnycastIPPool bool
+	// EnableNodeAddressBinding is set if the NodeAddressBinding crd is installed in the cluster
+	EnableNodeAddressBinding bool
+	// ConfigFileContent is the content of the config file ConfigFileConf is loaded from
+	ConfigFileContent  []byte
+	EnableConfigReload bool
+	// AiaConfigMap is the namespace/name of the ConfigMap the config file is mounted from, if known
+	AiaConfigMap string
+	// EnablePodAnycastIp binds anycast ips to the eni ips of annotated pods on vpc-cni networking
+	EnablePodAnycastIp bool
+	// EnableServiceStatus publishes the anycast ips of aia nodes in the load balancer status of annotated services
+	EnableServiceStatus bool
+	// AddressInventoryPeriod is how often the address inventory is refreshed, 0 disables the inventory
+	AddressInventoryPeriod time.Duration
+	// AddressBatchWindow is how long allocations and releases wait to be batched with others while one is in flight,
+	// 0 disables batching
+	AddressBatchWindow time.Duration
 }
 
 type InternalControllerConfig struct {
@@ -35,10 +65,61 @@ type AiaConfig struct {
 	Bandwidth   int64             `yaml:"bandwidth"`
 	AnycastZone string            `yaml:"anycastZone"`
 	AddressType string            `yaml:"addressType"`
+	// TypeRules choose the address type of nodes by labels, the first matching rule wins,
+	// nodes matching no rule get AddressType
+	TypeRules []AddressTypeRule `yaml:"typeRules"`
+	// TypeConflictPolicy decides what to do with a node that already has an address of another type
+	TypeConflictPolicy string `yaml:"typeConflictPolicy"`
+	// ReleaseMigratedAddress releases the old address of a migrated node if it was not allocated by
+	// aia-ip-controller, otherwise it is only disassociated
+	ReleaseMigratedAddress bool `yaml:"releaseMigratedAddress"`
+	// WarmPoolSize is how many standby addresses are kept allocated for nodes not selected by any pool,
+	// so that a new node gets one without waiting for the allocation
+	WarmPoolSize int `yaml:"warmPoolSize"`
+	// StickyIdentityLabel enables sticky mode, the address of a node is keyed by the value of this node label
+	// instead of the node name, retained after the node is deleted, and reused by the next node with the value
+	StickyIdentityLabel string `yaml:"stickyIdentityLabel"`
+	// StickyRetention is how long the address of a deleted node is retained in sticky mode, default is 24h
+	StickyRetention metav1.Duration `yaml:"stickyRetention"`
+	// StaticReleasePolicy decides what to do with a static address assigned by the user when its node is
+	// deleted, default is Detach
+	StaticReleasePolicy string `yaml:"staticReleasePolicy"`
+	// AdoptionPolicy decides what to do with an address of the node that was not allocated by aia-ip-controller,
+	// default is Ignore
+	AdoptionPolicy string `yaml:"adoptionPolicy"`
+}
+
+// AddressTypeRule gives the nodes selected by NodeSelector addresses of AddressType
+type AddressTypeRule struct {
+	NodeSelector *metav1.LabelSelector `yaml:"nodeSelector"`
+	AddressType  string                `yaml:"addressType"`
 }
 
 type NodeConfig struct {
 	Labels map[string]string `yaml:"labels"`
+	// LabelRemovalPolicy decides what to do with the anycast ip of a node that no longer has the labels
+	LabelRemovalPolicy string `yaml:"labelRemovalPolicy"`
+}
+
+// CloudAPIConfig configures the client side rate limits and retries of tencent cloud api calls, they are shared by
+// all reconcile workers and the reverse reconcile loop
+type CloudAPIConfig struct {
+	// RateLimits are token buckets keyed by service (vpc, tag or cvm) or by action, such as AllocateAddresses,
+	// services without limit get 20 qps with burst 20
+	RateLimits map[string]RateLimitConfig `yaml:"rateLimits"`
+	// MaxRetries is how many times a throttled call, or a describe call failed with a transient error, is retried,
+	// default is 3, a negative value disables retries
+	MaxRetries int `yaml:"maxRetries"`
+	// RetryBaseDelay is the delay before the first retry, it doubles for each retry up to RetryMaxDelay,
+	// default is 500ms and 5s
+	RetryBaseDelay metav1.Duration `yaml:"retryBaseDelay"`
+	RetryMaxDelay  metav1.Duration `yaml:"retryMaxDelay"`
+}
+
+// RateLimitConfig is a token bucket of QPS tokens per second holding at most Burst tokens, a zero QPS does not limit
+type RateLimitConfig struct {
+	QPS   float64 `yaml:"qps"`
+	Burst int     `yaml:"burst"`
 }
 
 type YamlValueConfig struct {
@@ -47,12 +128,50 @@ type YamlValueConfig struct {
 	Credential CredentialConfig         `yaml:"credential"`
 	Aia        AiaConfig                `yaml:"aia"`
 	Node       NodeConfig               `yaml:"node"`
+	CloudAPI   CloudAPIConfig           `yaml:"cloudAPI"`
 }
 
 const (
 	ClsPrefix = "cls-"
+
+	// MaxWarmPoolSize is the max number of standby addresses of a pool
+	MaxWarmPoolSize = 100
+	// DefaultStickyRetention is how long the address of a deleted node is retained in sticky mode by default
+	DefaultStickyRetention = 24 * time.Hour
+
+	// LabelRemovalPolicyRelease disassociates and releases the anycast ip
+	LabelRemovalPolicyRelease = "Release"
+	// LabelRemovalPolicyDisassociate disassociates the anycast ip and keeps it for the node, it is associated
+	// again if the labels come back, and released when the node is deleted
+	LabelRemovalPolicyDisassociate = "Disassociate"
+	// LabelRemovalPolicyIgnore leaves the anycast ip bound, it is the default
+	LabelRemovalPolicyIgnore = "Ignore"
+
+	// TypeConflictPolicyWarn records a warning event and leaves the node as is, nodes with a plain EIP or
+	// a WanIP are kept tainted. It is the default
+	TypeConflictPolicyWarn = "Warn"
+	// TypeConflictPolicyTaint records a warning event and keeps the node tainted
+	TypeConflictPolicyTaint = "Taint"
+	// TypeConflictPolicyAccept takes the address of another type as the address of the node
+	TypeConflictPolicyAccept = "Accept"
+	// TypeConflictPolicyMigrate replaces the address of another type with a new address
+	TypeConflictPolicyMigrate = "Migrate"
+
+	// StaticReleasePolicyDetach disassociates the static address and removes the ownership tags, it is the default
+	StaticReleasePolicyDetach = "Detach"
+	// StaticReleasePolicyRelease releases the static address like an allocated one
+	StaticReleasePolicyRelease = "Release"
+
+	// AdoptionPolicyIgnore leaves an address not allocated by aia-ip-controller as is, it is the default
+	AdoptionPolicyIgnore = "Ignore"
+	// AdoptionPolicyAdopt tags an address not allocated by aia-ip-controller with the ownership tags, so that it is
+	// converged and released like an allocated one
+	AdoptionPolicyAdopt = "Adopt"
 )
 
+// addressTypes are the address types aia-ip-controller can allocate
+var addressTypes = []string{"AnycastEIP", "HighQualityEIP", "EIP"}
+
 func (y *YamlValueConfig) Validate() error {
 	if !strings.HasPrefix(y.Credential.ClusterID, ClsPrefix) {
 		return fmt.Errorf("invalid cluster id %s", y.Credential.ClusterID)
@@ -60,5 +179,85 @@ func (y *YamlValueConfig) Validate() error {
 	if y.Credential.SecretID == "" || y.Credential.SecretKey == "" {
 		return fmt.Errorf("invalid secret id or secret key")
 	}
+	switch y.Node.LabelRemovalPolicy {
+	case "", LabelRemovalPolicyRelease, LabelRemovalPolicyDisassociate, LabelRemovalPolicyIgnore:
+	default:
+		return fmt.Errorf("invalid node label removal policy %s", y.Node.LabelRemovalPolicy)
+	}
+	if y.Aia.AddressType != "" && !isAddressType(y.Aia.AddressType) {
+		return fmt.Errorf("invalid address type %s", y.Aia.AddressType)
+	}
+	for i, rule := range y.Aia.TypeRules {
+		if !isAddressType(rule.AddressType) {
+			return fmt.Errorf("invalid address type %s of type rule %d", rule.AddressType, i)
+		}
+		if _, err := metav1.LabelSelectorAsSelector(rule.NodeSelector); err != nil {
+			return fmt.Errorf("invalid node selector of type rule %d: %v", i, err)
+		}
+	}
+	switch y.Aia.TypeConflictPolicy {
+	case "", TypeConflictPolicyWarn, TypeConflictPolicyTaint, TypeConflictPolicyAccept, TypeConflictPolicyMigrate:
+	default:
+		return fmt.Errorf("invalid type conflict policy %s", y.Aia.TypeConflictPolicy)
+	}
+	switch y.Aia.AdoptionPolicy {
+	case "", AdoptionPolicyIgnore, AdoptionPolicyAdopt:
+	default:
+		return fmt.Errorf("invalid adoption policy %s", y.Aia.AdoptionPolicy)
+	}
+	if y.Aia.StaticReleasePolicy != "" && !IsStaticReleasePolicy(y.Aia.StaticReleasePolicy) {
+		return fmt.Errorf("invalid static release policy %s", y.Aia.StaticReleasePolicy)
+	}
+	if y.Aia.StickyIdentityLabel != "" {
+		if errs := validation.IsQualifiedName(y.Aia.StickyIdentityLabel); len(errs) > 0 {
+			return fmt.Errorf("invalid sticky identity label %s: %s", y.Aia.StickyIdentityLabel, strings.Join(errs, ", "))
+		}
+	}
+	if y.Aia.StickyRetention.Duration < 0 {
+		return fmt.Errorf("invalid sticky retention %s", y.Aia.StickyRetention.Duration)
+	}
+	if y.Aia.WarmPoolSize < 0 || y.Aia.WarmPoolSize > MaxWarmPoolSize {
+		return fmt.Errorf("invalid warm pool size %d, it should be between 0 and %d", y.Aia.WarmPoolSize, MaxWarmPoolSize)
+	}
+	for key, limit := range y.CloudAPI.RateLimits {
+		if !cloud.IsRateLimitKey(key) {
+			return fmt.Errorf("invalid rate limit of %s, it should be vpc, tag, cvm or an action of them", key)
+		}
+		if limit.QPS < 0 || limit.Burst < 0 {
+			return fmt.Errorf("invalid rate limit of %s, qps %v and burst %d should not be negative", key, limit.QPS, limit.Burst)
+		}
+	}
+	if y.CloudAPI.RetryBaseDelay.Duration < 0 || y.CloudAPI.RetryMaxDelay.Duration < 0 {
+		return fmt.Errorf("invalid cloud api retry delay %s and %s", y.CloudAPI.RetryBaseDelay.Duration,
+			y.CloudAPI.RetryMaxDelay.Duration)
+	}
 	return nil
 }
+
+func isAddressType(addressType string) bool {
+	for _, t := range addressTypes {
+		if t == addressType {
+			return true
+		}
+	}
+	return false
+}
+
+// IsStaticReleasePolicy returns whether policy is a valid release policy of static addresses
+func IsStaticReleasePolicy(policy string) bool {
+	return policy == StaticReleasePolicyDetach || policy == StaticReleasePolicyRelease
+}
+
+// RateLimitOptions returns the options of the rate limited cloud clients
+func (c *CloudAPIConfig) RateLimitOptions() cloud.RateLimitOptions {
+	opts := cloud.RateLimitOptions{
+		Limits:         map[string]cloud.RateLimit{},
+		MaxRetries:     c.MaxRetries,
+		RetryBaseDelay: c.RetryBaseDelay.Duration,
+		RetryMaxDelay:  c.RetryMaxDelay.Duration,
+	}
+	for key, limit := range c.RateLimits {
+		opts.Limits[key] = cloud.RateLimit{QPS: limit.QPS, Burst: limit.Burst}
+	}
+	return opts
+}
@@ -0,0 +1,64 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"reflect"
+
+	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// ParseYamlValueConfig parses the content of values.yaml, overrides credential para by env if set, and validates it
+func ParseYamlValueConfig(yamlFile []byte) (*YamlValueConfig, error) {
+	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(yamlFile), 100)
+	var confVal YamlValueConfig
+	if err := decoder.Decode(&confVal); err != nil {
+		return nil, err
+	}
+	confValB, err := json.Marshal(&confVal)
+	if err != nil {
+		return nil, err
+	}
+	// override credential para if env set
+	if os.Getenv(constants.ClusterIdEnvKey) != "" {
+		confVal.Credential.ClusterID = os.Getenv(constants.ClusterIdEnvKey)
+	}
+	if os.Getenv(constants.AppIdEnvKey) != "" {
+		confVal.Credential.AppID = os.Getenv(constants.AppIdEnvKey)
+	}
+	if os.Getenv(constants.SecretIdEnvKey) != "" {
+		confVal.Credential.SecretID = os.Getenv(constants.SecretIdEnvKey)
+	}
+	if os.Getenv(constants.SecretKeyEnvKey) != "" {
+		confVal.Credential.SecretKey = os.Getenv(constants.SecretKeyEnvKey)
+	}
+
+	klog.V(4).Infof("conf parsed(with env override): %s", string(confValB))
+	if err := confVal.Validate(); err != nil {
+		klog.Errorf("generate conf for aia-ip-controller failed, err: %v", err)
+		return nil, err
+	}
+	return &confVal, nil
+}
+
+// ValidateReload checks that y only changes the parts of old that can be reloaded without restart,
+// the cloud clients and leader election are set up with region, credential, cloudAPI and controller at startup.
+func (y *YamlValueConfig) ValidateReload(old *YamlValueConfig) error {
+	if !reflect.DeepEqual(y.Region, old.Region) {
+		return fmt.Errorf("region can not be changed without restart")
+	}
+	if !reflect.DeepEqual(y.Credential, old.Credential) {
+		return fmt.Errorf("credential can not be changed without restart")
+	}
+	if !reflect.DeepEqual(y.Controller, old.Controller) {
+		return fmt.Errorf("controller can not be changed without restart")
+	}
+	if !reflect.DeepEqual(y.CloudAPI, old.CloudAPI) {
+		return fmt.Errorf("cloudAPI can not be changed without restart")
+	}
+	return nil
+}
@@ -0,0 +1,126 @@
+package config
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// reloadDelay merges the burst of fs events caused by one update of the config file
+const reloadDelay = time.Second
+
+// Watcher reloads values.yaml when it changes. ConfigMap volumes are updated by swapping a symlink in the
+// directory of the file, so the directory is watched rather than the file itself.
+type Watcher struct {
+	path          string
+	content       []byte
+	reload        func(conf *YamlValueConfig) error
+	eventRecorder record.EventRecorder
+	// configMapRef is the object that events about reloading are recorded on, nil if unknown
+	configMapRef *corev1.ObjectReference
+}
+
+// NewWatcher creates a Watcher of the config file at path mounted from configMap, which is namespace/name
+// or empty if unknown. content is what has been loaded at startup. reload applies a valid new config,
+// an error returned by it rejects the config and the old one is kept.
+func NewWatcher(path, configMap string, content []byte, reload func(conf *YamlValueConfig) error,
+	eventRecorder record.EventRecorder) *Watcher {
+	w := &Watcher{
+		path:          path,
+		content:       content,
+		reload:        reload,
+		eventRecorder: eventRecorder,
+	}
+	if parts := strings.SplitN(configMap, "/", 2); len(parts) == 2 {
+		w.configMapRef = &corev1.ObjectReference{
+			Kind:       "ConfigMap",
+			APIVersion: "v1",
+			Namespace:  parts[0],
+			Name:       parts[1],
+		}
+	}
+	return w
+}
+
+// Start watches the config file until ctx is done
+func (w *Watcher) Start(ctx context.Context) error {
+	fsWatcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer fsWatcher.Close()
+	if err := fsWatcher.Add(filepath.Dir(w.path)); err != nil {
+		return fmt.Errorf("watch config dir of %s failed: %v", w.path, err)
+	}
+	klog.Infof("watching config file %s for changes", w.path)
+
+	var timer <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case e, ok := <-fsWatcher.Events:
+			if !ok {
+				return nil
+			}
+			klog.V(4).Infof("config dir event: %s", e.String())
+			if timer == nil {
+				timer = time.After(reloadDelay)
+			}
+		case err, ok := <-fsWatcher.Errors:
+			if !ok {
+				return nil
+			}
+			klog.Warningf("watch config file %s failed, err: %v", w.path, err)
+		case <-timer:
+			timer = nil
+			w.Reload()
+		}
+	}
+}
+
+// NeedLeaderElection returns false, standby instances should also use the latest config
+func (w *Watcher) NeedLeaderElection() bool {
+	return false
+}
+
+// Reload reads the config file and applies it if its content changed
+func (w *Watcher) Reload() {
+	content, err := ioutil.ReadFile(w.path)
+	if err != nil {
+		klog.Warningf("read config file %s failed, err: %v", w.path, err)
+		return
+	}
+	if bytes.Equal(content, w.content) {
+		return
+	}
+	// remember the content even if it is rejected, so that it is not reported again until changed
+	w.content = content
+
+	conf, err := ParseYamlValueConfig(content)
+	if err == nil {
+		err = w.reload(conf)
+	}
+	if err != nil {
+		klog.Errorf("reject config file %s, keep using the old config, err: %v", w.path, err)
+		if w.configMapRef != nil {
+			w.eventRecorder.Eventf(w.configMapRef, corev1.EventTypeWarning, constants.InvalidConfig,
+				"Config rejected, keep using the old config: %v", err)
+		}
+		return
+	}
+	klog.Infof("config file %s reloaded", w.path)
+	if w.configMapRef != nil {
+		w.eventRecorder.Eventf(w.configMapRef, corev1.EventTypeNormal, constants.ConfigReloaded, "Config reloaded")
+	}
+}
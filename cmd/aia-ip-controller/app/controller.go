@@ -1,24 +1,80 @@
 package app
 
 import (
+	"context"
 	"time"
 
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/meta"
 	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/builder"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
 	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/source"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
 	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
 	"tkestack.io/aia-ip-controller/pkg/controller/aia"
 )
 
 func setupControllers(mgr ctrl.Manager, cfg *config.ControllerConfig) error {
 
+	kubeClient, err := clientset.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+
+	cloudClients, err := cloud.NewClients(cfg.ConfigFileConf.Credential.SecretID,
+		cfg.ConfigFileConf.Credential.SecretKey, cfg.ConfigFileConf.Region.LongName)
+	if err != nil {
+		return err
+	}
+	// every attempt of a call is instrumented, the rate limits are shared by all users of the clients
+	cloudClients, err = cloud.NewRateLimitedClients(cloud.NewInstrumentedClients(cloudClients),
+		cfg.ConfigFileConf.CloudAPI.RateLimitOptions())
+	if err != nil {
+		return err
+	}
+
+	if err := setupHealthChecks(mgr, cfg, kubeClient, cloudClients); err != nil {
+		return err
+	}
+
+	// anycast ip pools are optional, the controller falls back to the aia config if the crd is not installed
+	_, err = mgr.GetRESTMapper().RESTMapping(aiav1alpha1.GroupVersion.WithKind("AnycastIPPool").GroupKind(),
+		aiav1alpha1.GroupVersion.Version)
+	switch {
+	case err == nil:
+		cfg.EnableAnycastIPPool = true
+	case meta.IsNoMatchError(err):
+		klog.Warningf("AnycastIPPool crd is not installed, all nodes use the aia config")
+	default:
+		return err
+	}
+	// so are node address bindings, addresses are then found by their tags only as before bindings were introduced
+	_, err = mgr.GetRESTMapper().RESTMapping(aiav1alpha1.GroupVersion.WithKind("NodeAddressBinding").GroupKind(),
+		aiav1alpha1.GroupVersion.Version)
+	switch {
+	case err == nil:
+		cfg.EnableNodeAddressBinding = true
+	case meta.IsNoMatchError(err):
+		klog.Warningf("NodeAddressBinding crd is not installed, node addresses are not recorded in bindings")
+	default:
+		return err
+	}
+
 	reconciler, err := aia.NewReconcile(
 		mgr.GetClient(),
+		kubeClient,
 		mgr.GetEventRecorderFor(componentAiaIpController),
 		cfg,
+		cloudClients,
 		ctrl.Log.WithName(componentAiaIpController),
 	)
 	if err != nil {
@@ -30,6 +86,55 @@ func setupControllers(mgr ctrl.Manager, cfg *config.ControllerConfig) error {
 		go wait.Until(reconciler.ReverseReconcile, time.Minute*1, wait.NeverStop)
 	}
 
+	// refresh tainted nodes metric, only leader runs it
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		wait.UntilWithContext(ctx, reconciler.UpdateTaintedNodesMetric, time.Second*30)
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	// refresh the address inventory consulted before describing addresses, only leader runs it
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		reconciler.AiaManger.RunAddressInventory(ctx, cfg.AddressInventoryPeriod)
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	// release sticky addresses whose retention expired, only leader runs it
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		reconciler.RunStickyRetention(ctx)
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	// release addresses left over by batched allocations, only leader runs it
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		reconciler.RunBatchAddressSweep(ctx)
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	// keep standby addresses of warm pools allocated, only leader runs it
+	if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+		reconciler.RunWarmPools(ctx)
+		return nil
+	})); err != nil {
+		return err
+	}
+
+	// reload values.yaml when the ConfigMap changes
+	if cfg.EnableConfigReload {
+		watcher := config.NewWatcher(cfg.AiaConfigFilePath, cfg.AiaConfigMap, cfg.ConfigFileContent, reconciler.ReloadConfig,
+			mgr.GetEventRecorderFor(componentAiaIpController))
+		if err := mgr.Add(watcher); err != nil {
+			return err
+		}
+	}
+
 	// aia-ip-controller only interested in Create, Update and Delete events
 	nodePredicate := predicate.Funcs{
 		// ignore update and generic event
@@ -42,9 +147,46 @@ func setupControllers(mgr ctrl.Manager, cfg *config.ControllerConfig) error {
 		DeleteFunc: reconciler.ProcessNodeDelete,
 	}
 
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&corev1.Node{}).
-		WithEventFilter(nodePredicate).
+	b := ctrl.NewControllerManagedBy(mgr).
+		For(&corev1.Node{}, builder.WithPredicates(nodePredicate)).
 		WithOptions(controller.Options{MaxConcurrentReconciles: cfg.MaxAiaIpControllerConcurrentReconciles}).
-		Complete(reconciler)
+		// nodes requeued after the node labels in config changed
+		Watches(&source.Channel{Source: reconciler.ConfigEvents}, &handler.EnqueueRequestForObject{})
+	if cfg.EnableAnycastIPPool {
+		b = b.Watches(&source.Kind{Type: &aiav1alpha1.AnycastIPPool{}}, handler.EnqueueRequestsFromMapFunc(reconciler.MapPoolToNodes))
+	}
+	if err := b.Complete(reconciler); err != nil {
+		return err
+	}
+
+	// pods on vpc-cni networking annotated for their own anycast ip, default is disable
+	if cfg.EnablePodAnycastIp {
+		if err := ctrl.NewControllerManagedBy(mgr).
+			For(&corev1.Pod{}, builder.WithPredicates(reconciler.PodPredicate())).
+			WithOptions(controller.Options{MaxConcurrentReconciles: cfg.MaxAiaIpControllerConcurrentReconciles}).
+			Complete(reconciler.PodReconciler()); err != nil {
+			return err
+		}
+		// release addresses of pods whose deletion was missed, only leader runs it
+		if err := mgr.Add(manager.RunnableFunc(func(ctx context.Context) error {
+			reconciler.RunPodAddressSweep(ctx)
+			return nil
+		})); err != nil {
+			return err
+		}
+	}
+
+	// load balancer status of annotated services, requeued when aia nodes or the endpoints of the services change,
+	// default is disable
+	if cfg.EnableServiceStatus {
+		if err := ctrl.NewControllerManagedBy(mgr).
+			For(&corev1.Service{}, builder.WithPredicates(reconciler.ServicePredicate())).
+			Watches(&source.Kind{Type: &corev1.Node{}}, handler.EnqueueRequestsFromMapFunc(reconciler.MapNodeToServices),
+				builder.WithPredicates(reconciler.ServiceNodePredicate())).
+			Watches(&source.Kind{Type: &corev1.Endpoints{}}, handler.EnqueueRequestsFromMapFunc(reconciler.MapEndpointsToService)).
+			Complete(reconciler.ServiceReconciler()); err != nil {
+			return err
+		}
+	}
+	return nil
 }
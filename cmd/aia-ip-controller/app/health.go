@@ -0,0 +1,36 @@
+package app
+
+import (
+	"time"
+
+	clientset "k8s.io/client-go/kubernetes"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
+	"tkestack.io/aia-ip-controller/pkg/health"
+)
+
+const (
+	cloudCredentialCheckPeriod = time.Minute
+)
+
+// setupHealthChecks registers /healthz and /readyz checks, they are served on the health port
+func setupHealthChecks(mgr ctrl.Manager, cfg *config.ControllerConfig, kubeClient clientset.Interface, cloudClients *cloud.Clients) error {
+	if err := mgr.AddHealthzCheck("ping", healthz.Ping); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("cache-sync", health.NewCacheSyncCheck(mgr.GetCache())); err != nil {
+		return err
+	}
+	if err := mgr.AddReadyzCheck("leader-election", health.NewLeaderElectionCheck(kubeClient, mgr.Elected(),
+		cfg.EnableLeaderElection, cfg.ConfigFileConf.Controller.ResourceLockName, cfg.LeaseDuration)); err != nil {
+		return err
+	}
+
+	credentialCheck := health.NewCloudCredentialCheck(cloudClients.Vpc, cloudCredentialCheckPeriod)
+	if err := mgr.Add(credentialCheck); err != nil {
+		return err
+	}
+	return mgr.AddReadyzCheck("cloud-credential", credentialCheck.Check)
+}
@@ -1,22 +1,19 @@
 package options
 
 import (
-	"bytes"
-	"encoding/json"
+	"fmt"
 	"io/ioutil"
-	"os"
 
 	corev1 "k8s.io/api/core/v1"
 	rbacv1 "k8s.io/api/rbac/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilerrors "k8s.io/apimachinery/pkg/util/errors"
-	yamlutil "k8s.io/apimachinery/pkg/util/yaml"
 	cliflag "k8s.io/component-base/cli/flag"
 	"k8s.io/klog/v2"
 	"k8s.io/klog/v2/klogr"
 	ctrl "sigs.k8s.io/controller-runtime"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
 	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
-	"tkestack.io/aia-ip-controller/pkg/constants"
 )
 
 // ControllerOptions is the main context object for the aia-ip-controller.
@@ -40,6 +37,7 @@ func (o *ControllerOptions) Validate() error {
 	var errs []error
 
 	errs = append(errs, o.Generic.Validate()...)
+	errs = append(errs, o.Serving.Validate()...)
 
 	return utilerrors.NewAggregate(errs)
 }
@@ -72,6 +70,9 @@ func (o ControllerOptions) Config() (*config.Config, error) {
 	if err := rbacv1.AddToScheme(scheme); err != nil {
 		return nil, err
 	}
+	if err := aiav1alpha1.AddToScheme(scheme); err != nil {
+		return nil, err
+	}
 
 	// read configFile
 	yamlFile, err := ioutil.ReadFile(o.Serving.AiaConfigFilePath)
@@ -80,34 +81,10 @@ func (o ControllerOptions) Config() (*config.Config, error) {
 	}
 	klog.V(2).Infof("read config file from path %s, content: %s", o.Serving.AiaConfigFilePath, string(yamlFile))
 
-	decoder := yamlutil.NewYAMLOrJSONDecoder(bytes.NewReader(yamlFile), 100)
-	var confVal config.YamlValueConfig
-	if err = decoder.Decode(&confVal); err != nil {
-		return nil, err
-	}
-	confValB, err := json.Marshal(&confVal)
+	confVal, err := config.ParseYamlValueConfig(yamlFile)
 	if err != nil {
 		return nil, err
 	}
-	// override credential para if env set
-	if os.Getenv(constants.ClusterIdEnvKey) != "" {
-		confVal.Credential.ClusterID = os.Getenv(constants.ClusterIdEnvKey)
-	}
-	if os.Getenv(constants.AppIdEnvKey) != "" {
-		confVal.Credential.AppID = os.Getenv(constants.AppIdEnvKey)
-	}
-	if os.Getenv(constants.SecretIdEnvKey) != "" {
-		confVal.Credential.SecretID = os.Getenv(constants.SecretIdEnvKey)
-	}
-	if os.Getenv(constants.SecretKeyEnvKey) != "" {
-		confVal.Credential.SecretKey = os.Getenv(constants.SecretKeyEnvKey)
-	}
-
-	klog.V(4).Infof("conf parsed(with env override): %s", string(confValB))
-	if err := confVal.Validate(); err != nil {
-		klog.Errorf("generate conf for aia-ip-controller failed, err: %v")
-		return nil, err
-	}
 
 	restConfig := ctrl.GetConfigOrDie()
 	// customize qps and burst
@@ -115,14 +92,19 @@ func (o ControllerOptions) Config() (*config.Config, error) {
 	restConfig.Burst = o.Generic.Burst
 	restConfig.ContentType = o.Generic.ContentType
 	klog.V(4).Infof("controller kube client use qps %v, burst %v", o.Generic.QPS, o.Generic.Burst)
+	healthProbeBindAddress := "0"
+	if o.Serving.HealthPort > 0 {
+		healthProbeBindAddress = fmt.Sprintf(":%d", o.Serving.HealthPort)
+	}
 	mgr, err := ctrl.NewManager(restConfig, ctrl.Options{
-		Scheme:             scheme,
-		LeaderElection:     true,
-		LeaderElectionID:   confVal.Controller.ResourceLockName,
-		LeaseDuration:      &o.LeaderElection.LeaseDuration,
-		RenewDeadline:      &o.LeaderElection.RenewDeadline,
-		RetryPeriod:        &o.LeaderElection.RetryPeriod,
-		MetricsBindAddress: "0",
+		Scheme:                 scheme,
+		LeaderElection:         o.LeaderElection.Enable,
+		LeaderElectionID:       confVal.Controller.ResourceLockName,
+		LeaseDuration:          &o.LeaderElection.LeaseDuration,
+		RenewDeadline:          &o.LeaderElection.RenewDeadline,
+		RetryPeriod:            &o.LeaderElection.RetryPeriod,
+		MetricsBindAddress:     o.Serving.MetricsBindAddress,
+		HealthProbeBindAddress: healthProbeBindAddress,
 	})
 	if err != nil {
 		return nil, err
@@ -134,6 +116,20 @@ func (o ControllerOptions) Config() (*config.Config, error) {
 	c.ControllerConfig.AiaConfigFilePath = o.Serving.AiaConfigFilePath
 	c.ControllerConfig.MaxAiaIpControllerConcurrentReconciles = o.Serving.MaxConcurrentReconciles
 	c.ControllerConfig.EnableReverseReconcile = o.Serving.EnableReverseReconcile
-	c.ControllerConfig.ConfigFileConf = &confVal
+	c.ControllerConfig.EnableNodeFinalizer = o.Serving.EnableNodeFinalizer
+	c.ControllerConfig.NodeFinalizerTimeout = o.Serving.NodeFinalizerTimeout
+	c.ControllerConfig.DryRun = o.Serving.DryRun
+	c.ControllerConfig.AddressSyncPeriod = o.Serving.AddressSyncPeriod
+	c.ControllerConfig.AddressInventoryPeriod = o.Serving.AddressInventoryPeriod
+	c.ControllerConfig.AddressBatchWindow = o.Serving.AddressBatchWindow
+	c.ControllerConfig.EnablePodAnycastIp = o.Serving.EnablePodAnycastIp
+	c.ControllerConfig.EnableServiceStatus = o.Serving.EnableServiceStatus
+	c.ControllerConfig.AsyncPollInterval = o.Serving.AsyncPollInterval
+	c.ControllerConfig.EnableConfigReload = o.Serving.EnableConfigReload
+	c.ControllerConfig.AiaConfigMap = o.Serving.AiaConfigMap
+	c.ControllerConfig.ConfigFileContent = yamlFile
+	c.ControllerConfig.EnableLeaderElection = o.LeaderElection.Enable
+	c.ControllerConfig.LeaseDuration = o.LeaderElection.LeaseDuration
+	c.ControllerConfig.ConfigFileConf = confVal
 	return c, nil
 }
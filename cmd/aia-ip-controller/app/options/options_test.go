@@ -0,0 +1,27 @@
+package options
+
+import "testing"
+
+func TestValidateAiaConfigMap(t *testing.T) {
+	cases := []struct {
+		configMap string
+		valid     bool
+	}{
+		{"", true},
+		{"kube-system/aia-ip-controller", true},
+		{"aia-ip-controller", false},
+		{"kube-system/", false},
+		{"/aia-ip-controller", false},
+		{"kube-system/aia/ip", false},
+	}
+	for _, c := range cases {
+		o := NewControllerOptions()
+		o.Serving.AiaConfigMap = c.configMap
+		if err := o.Validate(); (err == nil) != c.valid {
+			t.Errorf("validate aia-conf-configmap %q: expect valid %v, got err %v", c.configMap, c.valid, err)
+		}
+		if _, err := o.Config(); !c.valid && err == nil {
+			t.Errorf("expect no config with an invalid aia-conf-configmap %q", c.configMap)
+		}
+	}
+}
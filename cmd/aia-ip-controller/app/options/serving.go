@@ -3,6 +3,7 @@ package options
 import (
 	"fmt"
 	"strings"
+	"time"
 
 	"github.com/spf13/pflag"
 )
@@ -11,6 +12,18 @@ const (
 	DefaultAiaIpControllerConfigYaml = "/app/conf/values.yaml"
 	DefaultMaxConcurrentReconciles   = 1
 	DefaultEnableReverseReconcile    = false
+	DefaultMetricsBindAddress        = "0"
+	DefaultHealthPort                = 0
+	DefaultEnableNodeFinalizer       = false
+	DefaultNodeFinalizerTimeout      = 10 * time.Minute
+	DefaultDryRun                    = false
+	DefaultEnableConfigReload        = false
+	DefaultAddressSyncPeriod         = 10 * time.Minute
+	DefaultEnablePodAnycastIp        = false
+	DefaultEnableServiceStatus       = false
+	DefaultAsyncPollInterval         = 2 * time.Second
+	DefaultAddressInventoryPeriod    = time.Minute
+	DefaultAddressBatchWindow        = 100 * time.Millisecond
 )
 
 type ServingOptions struct {
@@ -19,6 +32,18 @@ type ServingOptions struct {
 	HealthPort              int
 	MaxConcurrentReconciles int
 	EnableReverseReconcile  bool
+	MetricsBindAddress      string
+	EnableNodeFinalizer     bool
+	NodeFinalizerTimeout    time.Duration
+	DryRun                  bool
+	EnableConfigReload      bool
+	AiaConfigMap            string
+	AddressSyncPeriod       time.Duration
+	EnablePodAnycastIp      bool
+	EnableServiceStatus     bool
+	AsyncPollInterval       time.Duration
+	AddressInventoryPeriod  time.Duration
+	AddressBatchWindow      time.Duration
 }
 
 // NewServingOptions returns serving configuration default values for aia-controller.
@@ -27,6 +52,18 @@ func NewServingOptions() *ServingOptions {
 		AiaConfigFilePath:       DefaultAiaIpControllerConfigYaml,
 		MaxConcurrentReconciles: DefaultMaxConcurrentReconciles,
 		EnableReverseReconcile:  DefaultEnableReverseReconcile,
+		MetricsBindAddress:      DefaultMetricsBindAddress,
+		HealthPort:              DefaultHealthPort,
+		EnableNodeFinalizer:     DefaultEnableNodeFinalizer,
+		NodeFinalizerTimeout:    DefaultNodeFinalizerTimeout,
+		DryRun:                  DefaultDryRun,
+		EnableConfigReload:      DefaultEnableConfigReload,
+		AddressSyncPeriod:       DefaultAddressSyncPeriod,
+		EnablePodAnycastIp:      DefaultEnablePodAnycastIp,
+		EnableServiceStatus:     DefaultEnableServiceStatus,
+		AsyncPollInterval:       DefaultAsyncPollInterval,
+		AddressInventoryPeriod:  DefaultAddressInventoryPeriod,
+		AddressBatchWindow:      DefaultAddressBatchWindow,
 	}
 }
 
@@ -42,6 +79,32 @@ func (o *ServingOptions) AddFlags(fs *pflag.FlagSet) {
 		"The cluster id of tke cluster")
 	fs.IntVar(&o.MaxConcurrentReconciles, "max-concurrent-reconcile", o.MaxConcurrentReconciles, "Max concurrent reconciles for aia controller")
 	fs.BoolVar(&o.EnableReverseReconcile, "enable-reverse-reconcile", o.EnableReverseReconcile, "Enable reverse reconcile or not, default is false, means disable reverse reconcile")
+	fs.IntVar(&o.HealthPort, "health-port", o.HealthPort,
+		"The port that serves /healthz and /readyz probes, default is 0, means disable health probe serving")
+	fs.StringVar(&o.MetricsBindAddress, "metrics-bind-address", o.MetricsBindAddress,
+		"The address the prometheus metrics endpoint binds to, such as :18080, default is 0, means disable metrics serving")
+	fs.BoolVar(&o.EnableNodeFinalizer, "enable-node-finalizer", o.EnableNodeFinalizer,
+		"Add a finalizer to aia nodes so that their anycast ip is released before the node is removed, default is false")
+	fs.DurationVar(&o.NodeFinalizerTimeout, "node-finalizer-timeout", o.NodeFinalizerTimeout,
+		"How long a deleting node waits for its anycast ip to be released before the finalizer is removed anyway")
+	fs.BoolVar(&o.DryRun, "dry-run", o.DryRun,
+		"Log, record events and metrics for cloud api and node mutations instead of doing them, default is false")
+	fs.BoolVar(&o.EnableConfigReload, "enable-config-reload", o.EnableConfigReload,
+		"Reload the config file when it changes without restart, default is false")
+	fs.StringVar(&o.AiaConfigMap, "aia-conf-configmap", o.AiaConfigMap,
+		"The namespace/name of the ConfigMap the config file is mounted from, config reload events are recorded on it, default is empty, means no event")
+	fs.DurationVar(&o.AddressSyncPeriod, "address-sync-period", o.AddressSyncPeriod,
+		"How often the bandwidth, name and tags of bound anycast ips are checked and converged to the config, 0 means only when the node changes")
+	fs.BoolVar(&o.EnablePodAnycastIp, "enable-pod-anycast-ip", o.EnablePodAnycastIp,
+		"Bind anycast ips to the eni ips of pods annotated with tke.cloud.tencent.com/need-aia-ip, requires vpc-cni networking, default is false")
+	fs.BoolVar(&o.EnableServiceStatus, "enable-service-status", o.EnableServiceStatus,
+		"Publish the anycast ips of the ready aia nodes in the load balancer status of LoadBalancer services annotated with tke.cloud.tencent.com/aia-loadbalancer, default is false")
+	fs.DurationVar(&o.AsyncPollInterval, "async-poll-interval", o.AsyncPollInterval,
+		"How often an anycast ip is checked while a cloud operation on it, such as associate, is in progress")
+	fs.DurationVar(&o.AddressInventoryPeriod, "address-inventory-period", o.AddressInventoryPeriod,
+		"How often the in-memory inventory of the addresses in the region is refreshed, 0 disables the inventory and addresses are always described")
+	fs.DurationVar(&o.AddressBatchWindow, "address-batch-window", o.AddressBatchWindow,
+		"How long an anycast ip allocation or release waits for those of other nodes to be done by one cloud api call while another one is in flight, 0 disables batching")
 }
 
 const (
@@ -55,8 +118,13 @@ func (o *ServingOptions) Validate() []error {
 	}
 
 	var errs []error
-	if !strings.HasPrefix(o.ClusterId, ClsPrefix) {
+	if o.ClusterId != "" && !strings.HasPrefix(o.ClusterId, ClsPrefix) {
 		errs = append(errs, fmt.Errorf("invalid clusterId %s, no (%s) prefix", o.ClusterId, ClsPrefix))
 	}
+	if o.AiaConfigMap != "" {
+		if parts := strings.Split(o.AiaConfigMap, "/"); len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			errs = append(errs, fmt.Errorf("invalid aia-conf-configmap %s, not namespace/name", o.AiaConfigMap))
+		}
+	}
 	return errs
 }
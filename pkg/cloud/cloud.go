@@ -0,0 +1,68 @@
+package cloud
+
+import (
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// VpcAPI is the subset of tencent cloud vpc api that aia-ip-controller uses to manage addresses
+type VpcAPI interface {
+	DescribeAddresses(request *vpc.DescribeAddressesRequest) (*vpc.DescribeAddressesResponse, error)
+	AllocateAddresses(request *vpc.AllocateAddressesRequest) (*vpc.AllocateAddressesResponse, error)
+	AssociateAddress(request *vpc.AssociateAddressRequest) (*vpc.AssociateAddressResponse, error)
+	DisassociateAddress(request *vpc.DisassociateAddressRequest) (*vpc.DisassociateAddressResponse, error)
+	ReleaseAddresses(request *vpc.ReleaseAddressesRequest) (*vpc.ReleaseAddressesResponse, error)
+	ModifyAddressesBandwidth(request *vpc.ModifyAddressesBandwidthRequest) (*vpc.ModifyAddressesBandwidthResponse, error)
+	ModifyAddressAttribute(request *vpc.ModifyAddressAttributeRequest) (*vpc.ModifyAddressAttributeResponse, error)
+	DescribeNetworkInterfaces(request *vpc.DescribeNetworkInterfacesRequest) (*vpc.DescribeNetworkInterfacesResponse, error)
+	DescribeTaskResult(request *vpc.DescribeTaskResultRequest) (*vpc.DescribeTaskResultResponse, error)
+}
+
+// TagAPI is the subset of tencent cloud tag api that aia-ip-controller uses to track address ownership
+type TagAPI interface {
+	CreateTag(request *tag.CreateTagRequest) (*tag.CreateTagResponse, error)
+	DescribeResourcesByTags(request *tag.DescribeResourcesByTagsRequest) (*tag.DescribeResourcesByTagsResponse, error)
+	DescribeResourceTagsByTagKeys(request *tag.DescribeResourceTagsByTagKeysRequest) (*tag.DescribeResourceTagsByTagKeysResponse, error)
+	AttachResourcesTag(request *tag.AttachResourcesTagRequest) (*tag.AttachResourcesTagResponse, error)
+	DetachResourcesTag(request *tag.DetachResourcesTagRequest) (*tag.DetachResourcesTagResponse, error)
+}
+
+// CvmAPI is the subset of tencent cloud cvm api that aia-ip-controller uses to look up instances
+type CvmAPI interface {
+	DescribeInstances(request *cvm.DescribeInstancesRequest) (*cvm.DescribeInstancesResponse, error)
+}
+
+// Clients groups the cloud api clients used by aia-ip-controller
+type Clients struct {
+	Vpc VpcAPI
+	Tag TagAPI
+	Cvm CvmAPI
+}
+
+// NewClients creates tencent cloud sdk clients of vpc, tag and cvm in the given region
+func NewClients(secretId, secretKey, region string) (*Clients, error) {
+	credential := common.NewCredential(secretId, secretKey)
+	vpcClient, err := vpc.NewClient(credential, region, profile.NewClientProfile())
+	if err != nil {
+		return nil, err
+	}
+
+	cvmClient, err := cvm.NewClient(credential, region, profile.NewClientProfile())
+	if err != nil {
+		return nil, err
+	}
+
+	tagClient, err := tag.NewClient(credential, region, profile.NewClientProfile())
+	if err != nil {
+		return nil, err
+	}
+
+	return &Clients{
+		Vpc: vpcClient,
+		Tag: tagClient,
+		Cvm: cvmClient,
+	}, nil
+}
@@ -0,0 +1,125 @@
+// Package errors classifies the errors of tencent cloud api calls, so that retry policy, events and metrics
+// are decided by error code rather than by matching error strings.
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	sdkerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+// Class is the kind of a cloud api error
+type Class string
+
+const (
+	// ClassRetryable errors are transient, such as internal and network errors, the call can be retried soon
+	ClassRetryable Class = "Retryable"
+	// ClassThrottled errors are returned when the request rate limit is exceeded
+	ClassThrottled Class = "Throttled"
+	// ClassQuotaExceeded errors are returned when the quota of a resource is used up
+	ClassQuotaExceeded Class = "QuotaExceeded"
+	// ClassAuth errors are caused by invalid or revoked credentials, or missing permissions
+	ClassAuth Class = "Auth"
+	// ClassNotFound errors are returned when a resource, such as an address or a tag, does not exist
+	ClassNotFound Class = "NotFound"
+	// ClassPermanent errors will not go away by retrying, such as invalid parameters
+	ClassPermanent Class = "Permanent"
+	// ClassUnknown errors have a code not known to be of another class, they are retried like retryable ones
+	ClassUnknown Class = "Unknown"
+)
+
+// Error is an error returned by tencent cloud api
+type Error struct {
+	Code      string
+	Message   string
+	RequestId string
+	Class     Class
+}
+
+func (e *Error) Error() string {
+	if e.RequestId == "" {
+		return fmt.Sprintf("%s: %s", e.Code, e.Message)
+	}
+	return fmt.Sprintf("%s: %s (RequestId: %s)", e.Code, e.Message, e.RequestId)
+}
+
+// Parse returns the cloud api error wrapped in err, or nil if err is nil or does not come from tencent cloud
+func Parse(err error) *Error {
+	var sdkErr *sdkerrors.TencentCloudSDKError
+	if err == nil || !errors.As(err, &sdkErr) {
+		return nil
+	}
+	return &Error{
+		Code:      sdkErr.Code,
+		Message:   sdkErr.Message,
+		RequestId: sdkErr.RequestId,
+		Class:     classify(sdkErr.Code),
+	}
+}
+
+// Code returns the tencent cloud error code of err, empty if it does not come from tencent cloud
+func Code(err error) string {
+	if e := Parse(err); e != nil {
+		return e.Code
+	}
+	return ""
+}
+
+// IsCode returns whether err is a cloud api error with one of the codes
+func IsCode(err error, codes ...string) bool {
+	code := Code(err)
+	if code == "" {
+		return false
+	}
+	for _, c := range codes {
+		if code == c {
+			return true
+		}
+	}
+	return false
+}
+
+// ClassOf returns the class of err. Errors that do not come from tencent cloud, such as network errors,
+// are retryable.
+func ClassOf(err error) Class {
+	if e := Parse(err); e != nil {
+		return e.Class
+	}
+	return ClassRetryable
+}
+
+// Describe returns the text of err for events, with the code, message and request id of a cloud api error
+func Describe(err error) string {
+	if e := Parse(err); e != nil {
+		return e.Error()
+	}
+	return err.Error()
+}
+
+// classify returns the class of a tencent cloud error code by its common prefixes and suffixes, codes not listed
+// are unknown rather than permanent so that a new transient code is not retried too late
+func classify(code string) Class {
+	switch {
+	case strings.HasPrefix(code, "RequestLimitExceeded"):
+		return ClassThrottled
+	case strings.HasPrefix(code, "AuthFailure"), strings.HasPrefix(code, "UnauthorizedOperation"):
+		return ClassAuth
+	case strings.Contains(code, "QuotaLimitExceeded"), strings.Contains(code, "QuotaExceeded"),
+		strings.HasPrefix(code, "LimitExceeded"):
+		return ClassQuotaExceeded
+	case strings.HasSuffix(code, "NotFound"), strings.HasSuffix(code, "NotExisted"),
+		strings.HasPrefix(code, "ResourceNotFound"):
+		return ClassNotFound
+	case strings.HasPrefix(code, "InternalError"), strings.HasPrefix(code, "ClientError"),
+		strings.HasPrefix(code, "ResourceUnavailable"), strings.HasSuffix(code, "NotPermit"),
+		strings.HasPrefix(code, "ResourceInUse"), strings.HasPrefix(code, "FailedOperation"):
+		return ClassRetryable
+	case strings.HasPrefix(code, "InvalidParameter"), strings.HasPrefix(code, "MissingParameter"),
+		strings.HasPrefix(code, "UnknownParameter"), strings.HasPrefix(code, "InvalidAction"),
+		strings.HasPrefix(code, "UnsupportedOperation"):
+		return ClassPermanent
+	}
+	return ClassUnknown
+}
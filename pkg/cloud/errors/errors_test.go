@@ -0,0 +1,86 @@
+package errors
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	sdkerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+)
+
+func TestClassify(t *testing.T) {
+	cases := []struct {
+		code string
+		want Class
+	}{
+		{"RequestLimitExceeded", ClassThrottled},
+		{"RequestLimitExceeded.UinLimitExceeded", ClassThrottled},
+		{"AuthFailure.SecretIdNotFound", ClassAuth},
+		{"UnauthorizedOperation", ClassAuth},
+		{"AddressQuotaLimitExceeded", ClassQuotaExceeded},
+		{"LimitExceeded.AddressQuotaLimitExceeded", ClassQuotaExceeded},
+		{"InvalidAddressId.NotFound", ClassNotFound},
+		{"InvalidTag.NotExisted", ClassNotFound},
+		{"ResourceNotFound", ClassNotFound},
+		{"InternalError", ClassRetryable},
+		{"ClientError.NetworkError", ClassRetryable},
+		{"InvalidAddressIdStatus.NotPermit", ClassRetryable},
+		{"ResourceInUse.TagDuplicate", ClassRetryable},
+		{"InvalidParameterValue", ClassPermanent},
+		{"InvalidParameter.Coexist", ClassPermanent},
+		{"MissingParameter", ClassPermanent},
+		{"UnsupportedOperation", ClassPermanent},
+		{"UnsupportedOperation.AddressStatusNotPermit", ClassRetryable},
+		{"InvalidInstanceId.AlreadyBindEip", ClassUnknown},
+		{"SomeNewCode.NeverSeen", ClassUnknown},
+	}
+	for _, c := range cases {
+		if got := classify(c.code); got != c.want {
+			t.Errorf("classify(%q) = %s, want %s", c.code, got, c.want)
+		}
+	}
+}
+
+func TestParseWrappedError(t *testing.T) {
+	sdkErr := sdkerrors.NewTencentCloudSDKError("AuthFailure.SignatureExpire", "signature expired", "req-1")
+	cases := []struct {
+		name string
+		err  error
+		want *Error
+	}{
+		{"nil", nil, nil},
+		{"plain", errors.New("connection reset"), nil},
+		{"sdk", sdkErr, &Error{Code: "AuthFailure.SignatureExpire", Message: "signature expired", RequestId: "req-1", Class: ClassAuth}},
+		{"wrapped", fmt.Errorf("allocate failed: %w", sdkErr), &Error{Code: "AuthFailure.SignatureExpire", Message: "signature expired", RequestId: "req-1", Class: ClassAuth}},
+		{"wrapped twice", fmt.Errorf("reconcile: %w", fmt.Errorf("allocate failed: %w", sdkErr)), &Error{Code: "AuthFailure.SignatureExpire", Message: "signature expired", RequestId: "req-1", Class: ClassAuth}},
+	}
+	for _, c := range cases {
+		got := Parse(c.err)
+		switch {
+		case c.want == nil && got != nil:
+			t.Errorf("%s: expect no cloud error, got %+v", c.name, got)
+		case c.want != nil && (got == nil || *got != *c.want):
+			t.Errorf("%s: expect %+v, got %+v", c.name, c.want, got)
+		}
+	}
+}
+
+func TestClassOfNonCloudError(t *testing.T) {
+	cases := []struct {
+		name string
+		err  error
+		want Class
+	}{
+		{"plain", errors.New("connection reset"), ClassRetryable},
+		{"wrapped plain", fmt.Errorf("describe failed: %w", errors.New("timeout")), ClassRetryable},
+		{"cloud", fmt.Errorf("release failed: %w", sdkerrors.NewTencentCloudSDKError("RequestLimitExceeded", "slow down", "")), ClassThrottled},
+	}
+	for _, c := range cases {
+		if got := ClassOf(c.err); got != c.want {
+			t.Errorf("%s: ClassOf = %s, want %s", c.name, got, c.want)
+		}
+	}
+	if IsCode(errors.New("InvalidTag.NotExisted"), "InvalidTag.NotExisted") {
+		t.Errorf("expect a plain error never to have a cloud error code")
+	}
+}
@@ -0,0 +1,366 @@
+// Package fake provides a stateful in-memory implementation of the tencent cloud vpc, tag and cvm api
+// used by aia-ip-controller, so that the allocate/associate/release flows can be tested without credentials.
+package fake
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	sdkerrors "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/errors"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
+)
+
+// error codes returned by the fake cloud, they follow the codes of the real api
+const (
+	ErrCodeTagNotExisted             = "InvalidTag.NotExisted"
+	ErrCodeTagDuplicate              = "ResourceInUse.TagDuplicate"
+	ErrCodeAddressNotFound           = "InvalidAddressId.NotFound"
+	ErrCodeAddressStatusNotPermit    = "InvalidAddressIdStatus.NotPermit"
+	ErrCodeInstanceNotFound          = "InvalidInstanceId.NotFound"
+	ErrCodeInstanceAlreadyBindEip    = "InvalidInstanceId.AlreadyBindEip"
+	ErrCodeAddressQuotaLimitExceeded = "AddressQuotaLimitExceeded"
+	ErrCodeInvalidParameterValue     = "InvalidParameterValue"
+	ErrCodeNetworkInterfaceNotFound  = "ResourceNotFound"
+	ErrCodeTaskNotFound              = "ResourceNotFound.TaskNotFound"
+)
+
+// address status, BIND and UNBIND are the settled ones
+const (
+	AddressStatusCreating  = "CREATING"
+	AddressStatusBinding   = "BINDING"
+	AddressStatusBind      = "BIND"
+	AddressStatusUnbinding = "UNBINDING"
+	AddressStatusUnbind    = "UNBIND"
+)
+
+// actions that can be counted or have errors injected
+const (
+	ActionDescribeAddresses             = "DescribeAddresses"
+	ActionAllocateAddresses             = "AllocateAddresses"
+	ActionAssociateAddress              = "AssociateAddress"
+	ActionDisassociateAddress           = "DisassociateAddress"
+	ActionReleaseAddresses              = "ReleaseAddresses"
+	ActionModifyAddressesBandwidth      = "ModifyAddressesBandwidth"
+	ActionModifyAddressAttribute        = "ModifyAddressAttribute"
+	ActionCreateTag                     = "CreateTag"
+	ActionDescribeResourcesByTags       = "DescribeResourcesByTags"
+	ActionDescribeResourceTagsByTagKeys = "DescribeResourceTagsByTagKeys"
+	ActionAttachResourcesTag            = "AttachResourcesTag"
+	ActionDetachResourcesTag            = "DetachResourcesTag"
+	ActionDescribeInstances             = "DescribeInstances"
+	ActionDescribeNetworkInterfaces     = "DescribeNetworkInterfaces"
+	ActionDescribeTaskResult            = "DescribeTaskResult"
+)
+
+const (
+	defaultRegion = "ap-guangzhou"
+)
+
+var (
+	_ cloud.VpcAPI = &Cloud{}
+	_ cloud.TagAPI = &Cloud{}
+	_ cloud.CvmAPI = &Cloud{}
+)
+
+type address struct {
+	vpc.Address
+	// pendingStatus is the status the address will settle to after remaining observations
+	pendingStatus string
+	remaining     int
+	// previousStatus is the status before the pending transition, taskId is the task of the transition
+	previousStatus string
+	taskId         string
+}
+
+// Cloud is an in-memory tencent cloud, it implements cloud.VpcAPI, cloud.TagAPI and cloud.CvmAPI.
+// Addresses go through the same transitional states as the real api (CREATING, BINDING, UNBINDING),
+// each transition is observed by TransitionDescribes DescribeAddresses calls before it settles.
+type Cloud struct {
+	// Region is the region of resources reported by tag api, default is ap-guangzhou
+	Region string
+	// TransitionDescribes is how many DescribeAddresses calls observe an address in transitional state
+	TransitionDescribes int
+	// AddressQuota limits how many addresses can exist at the same time, 0 means no limit
+	AddressQuota int
+
+	mu           sync.Mutex
+	seq          int
+	addresses    map[string]*address
+	instances    map[string]*cvm.Instance
+	enis         map[string]*vpc.NetworkInterface
+	tasks        map[string]*task
+	tagValues    map[string]map[string]struct{}
+	resourceTags map[string]map[string]string
+	injectedErrs map[string][]error
+	calls        map[string]int
+}
+
+// NewCloud returns an empty fake cloud, each transition is observed once before it settles
+func NewCloud() *Cloud {
+	return &Cloud{
+		Region:              defaultRegion,
+		TransitionDescribes: 1,
+		addresses:           map[string]*address{},
+		instances:           map[string]*cvm.Instance{},
+		enis:                map[string]*vpc.NetworkInterface{},
+		tasks:               map[string]*task{},
+		tagValues:           map[string]map[string]struct{}{},
+		resourceTags:        map[string]map[string]string{},
+		injectedErrs:        map[string][]error{},
+		calls:               map[string]int{},
+	}
+}
+
+// Clients returns cloud.Clients backed by this fake cloud
+func (c *Cloud) Clients() *cloud.Clients {
+	return &cloud.Clients{
+		Vpc: c,
+		Tag: c,
+		Cvm: c,
+	}
+}
+
+// NewError returns an error of the same type the sdk returns
+func NewError(code, message string) error {
+	return sdkerrors.NewTencentCloudSDKError(code, message, "")
+}
+
+// InjectError makes the next call of action fail with err, errors are consumed in order
+func (c *Cloud) InjectError(action string, err error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.injectedErrs[action] = append(c.injectedErrs[action], err)
+}
+
+// Calls returns how many times action has been called
+func (c *Cloud) Calls(action string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.calls[action]
+}
+
+// AddInstance registers a cvm instance, addresses can only be associated with registered instances
+func (c *Cloud) AddInstance(instanceId string, privateIps ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.instances[instanceId] = &cvm.Instance{
+		InstanceId:         common.StringPtr(instanceId),
+		InstanceName:       common.StringPtr(instanceId),
+		PrivateIpAddresses: common.StringPtrs(privateIps),
+	}
+}
+
+// AddAddress adds a settled address which was not allocated through the api, as if it was bought
+// in the console. Unset fields get defaults, the id of the address is returned.
+func (c *Cloud) AddAddress(addr vpc.Address, tags map[string]string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a := c.newAddressLocked()
+	if addr.AddressId != nil {
+		a.AddressId = addr.AddressId
+	}
+	if addr.AddressType != nil {
+		a.AddressType = addr.AddressType
+	}
+	if addr.AddressName != nil {
+		a.AddressName = addr.AddressName
+	}
+	if addr.AddressIp != nil {
+		a.AddressIp = addr.AddressIp
+	}
+	if addr.Bandwidth != nil {
+		a.Bandwidth = addr.Bandwidth
+	}
+	if addr.InternetChargeType != nil {
+		a.InternetChargeType = addr.InternetChargeType
+	}
+	a.AddressStatus = common.StringPtr(AddressStatusUnbind)
+	if addr.InstanceId != nil {
+		a.InstanceId = addr.InstanceId
+		a.AddressStatus = common.StringPtr(AddressStatusBind)
+	}
+	c.addresses[*a.AddressId] = a
+	c.resourceTags[*a.AddressId] = copyTags(tags)
+	return *a.AddressId
+}
+
+// GetAddress returns a snapshot of an address without observing its transition
+func (c *Cloud) GetAddress(addressId string) (*vpc.Address, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.addresses[addressId]
+	if !ok {
+		return nil, false
+	}
+	return c.snapshotLocked(a), true
+}
+
+// ListAddresses returns snapshots of all addresses ordered by id
+func (c *Cloud) ListAddresses() []*vpc.Address {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	res := make([]*vpc.Address, 0, len(c.addresses))
+	for _, id := range c.sortedAddressIdsLocked() {
+		res = append(res, c.snapshotLocked(c.addresses[id]))
+	}
+	return res
+}
+
+// ResourceTags returns the tags bound to a resource
+func (c *Cloud) ResourceTags(resourceId string) map[string]string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return copyTags(c.resourceTags[resourceId])
+}
+
+// Settle finishes all pending transitions immediately
+func (c *Cloud) Settle() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for _, a := range c.addresses {
+		c.settleLocked(a)
+	}
+}
+
+// begin records a call of action and returns the injected error if any, a cloud api error gets a request id
+// like the real api returns
+func (c *Cloud) begin(action string) error {
+	c.calls[action]++
+	errs := c.injectedErrs[action]
+	if len(errs) == 0 {
+		return nil
+	}
+	c.injectedErrs[action] = errs[1:]
+	if sdkErr, ok := errs[0].(*sdkerrors.TencentCloudSDKError); ok && sdkErr.RequestId == "" {
+		return sdkerrors.NewTencentCloudSDKError(sdkErr.Code, sdkErr.Message, c.nextRequestId())
+	}
+	return errs[0]
+}
+
+func (c *Cloud) nextRequestId() string {
+	c.seq++
+	return fmt.Sprintf("fake-request-%d", c.seq)
+}
+
+func (c *Cloud) errorf(code, format string, args ...interface{}) error {
+	return sdkerrors.NewTencentCloudSDKError(code, fmt.Sprintf(format, args...), c.nextRequestId())
+}
+
+func (c *Cloud) newAddressLocked() *address {
+	c.seq++
+	return &address{
+		Address: vpc.Address{
+			AddressId:          common.StringPtr(fmt.Sprintf("eip-%08x", c.seq)),
+			AddressName:        common.StringPtr(""),
+			AddressIp:          common.StringPtr(fmt.Sprintf("198.51.%d.%d", c.seq/254%256, c.seq%254+1)),
+			AddressType:        common.StringPtr("EIP"),
+			Bandwidth:          common.Uint64Ptr(1),
+			InternetChargeType: common.StringPtr("TRAFFIC_POSTPAID_BY_HOUR"),
+			CreatedTime:        common.StringPtr(time.Now().UTC().Format(time.RFC3339)),
+		},
+	}
+}
+
+// transitLocked moves the address to a transitional status which settles to target later
+func (c *Cloud) transitLocked(a *address, transitional, target string) {
+	a.previousStatus = ""
+	if a.AddressStatus != nil {
+		a.previousStatus = *a.AddressStatus
+	}
+	a.AddressStatus = common.StringPtr(transitional)
+	a.pendingStatus = target
+	a.remaining = c.TransitionDescribes
+	if a.remaining <= 0 {
+		c.settleLocked(a)
+	}
+}
+
+// observeLocked is called when an address is returned by DescribeAddresses
+func (c *Cloud) observeLocked(a *address) {
+	if a.pendingStatus == "" {
+		return
+	}
+	if a.remaining > 0 {
+		a.remaining--
+		return
+	}
+	c.settleLocked(a)
+}
+
+func (c *Cloud) settleLocked(a *address) {
+	if a.pendingStatus == "" {
+		return
+	}
+	a.AddressStatus = common.StringPtr(a.pendingStatus)
+	if a.pendingStatus == AddressStatusUnbind {
+		a.InstanceId = nil
+		a.NetworkInterfaceId = nil
+		a.PrivateAddressIp = nil
+	}
+	a.pendingStatus = ""
+	a.remaining = 0
+}
+
+func (c *Cloud) snapshotLocked(a *address) *vpc.Address {
+	res := a.Address
+	res.TagSet = nil
+	for _, k := range sortedKeys(c.resourceTags[*a.AddressId]) {
+		res.TagSet = append(res.TagSet, &vpc.Tag{
+			Key:   common.StringPtr(k),
+			Value: common.StringPtr(c.resourceTags[*a.AddressId][k]),
+		})
+	}
+	return &res
+}
+
+func (c *Cloud) sortedAddressIdsLocked() []string {
+	ids := make([]string, 0, len(c.addresses))
+	for id := range c.addresses {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+// initResponse allocates the anonymous Response struct of a sdk response
+func initResponse(resp interface{}) {
+	_ = json.Unmarshal([]byte(`{"Response":{}}`), resp)
+}
+
+// page returns the start and end index of a page, limit 0 means default limit
+func page(total int, offset, limit, defaultLimit int) (int, int) {
+	if limit <= 0 {
+		limit = defaultLimit
+	}
+	if offset > total {
+		offset = total
+	}
+	end := offset + limit
+	if end > total {
+		end = total
+	}
+	return offset, end
+}
+
+func copyTags(tags map[string]string) map[string]string {
+	res := make(map[string]string, len(tags))
+	for k, v := range tags {
+		res[k] = v
+	}
+	return res
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
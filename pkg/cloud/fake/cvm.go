@@ -0,0 +1,62 @@
+package fake
+
+import (
+	"sort"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+)
+
+const (
+	describeInstancesDefaultLimit = 20
+	describeInstancesMaxLimit     = 100
+)
+
+// DescribeInstances returns registered instances, optionally restricted to InstanceIds
+func (c *Cloud) DescribeInstances(request *cvm.DescribeInstancesRequest) (*cvm.DescribeInstancesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDescribeInstances); err != nil {
+		return nil, err
+	}
+
+	limit, offset := 0, 0
+	if request.Limit != nil {
+		limit = int(*request.Limit)
+	}
+	if request.Offset != nil {
+		offset = int(*request.Offset)
+	}
+	if limit > describeInstancesMaxLimit {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "limit %d exceeds %d", limit, describeInstancesMaxLimit)
+	}
+
+	ids := make([]string, 0)
+	if len(request.InstanceIds) > 0 {
+		for _, id := range request.InstanceIds {
+			if id == nil {
+				continue
+			}
+			if _, ok := c.instances[*id]; ok {
+				ids = append(ids, *id)
+			}
+		}
+	} else {
+		for id := range c.instances {
+			ids = append(ids, id)
+		}
+		sort.Strings(ids)
+	}
+
+	start, end := page(len(ids), offset, limit, describeInstancesDefaultLimit)
+	resp := cvm.NewDescribeInstancesResponse()
+	initResponse(resp)
+	resp.Response.InstanceSet = make([]*cvm.Instance, 0)
+	for _, id := range ids[start:end] {
+		ins := *c.instances[id]
+		resp.Response.InstanceSet = append(resp.Response.InstanceSet, &ins)
+	}
+	resp.Response.TotalCount = common.Int64Ptr(int64(len(ids)))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
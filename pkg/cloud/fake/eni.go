@@ -0,0 +1,136 @@
+package fake
+
+import (
+	"sort"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"tkestack.io/aia-ip-controller/pkg/controller/util"
+)
+
+const (
+	describeNetworkInterfacesDefaultLimit = 20
+	describeNetworkInterfacesMaxLimit     = 100
+)
+
+// AddNetworkInterface registers an eni with private ips, the first one is the primary ip. Addresses can be
+// associated with the private ips of registered enis.
+func (c *Cloud) AddNetworkInterface(eniId string, privateIps ...string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	eni := &vpc.NetworkInterface{
+		NetworkInterfaceId: common.StringPtr(eniId),
+		State:              common.StringPtr("AVAILABLE"),
+	}
+	for i, ip := range privateIps {
+		eni.PrivateIpAddressSet = append(eni.PrivateIpAddressSet, &vpc.PrivateIpAddressSpecification{
+			PrivateIpAddress: common.StringPtr(ip),
+			Primary:          common.BoolPtr(i == 0),
+			State:            common.StringPtr("AVAILABLE"),
+		})
+	}
+	c.enis[eniId] = eni
+}
+
+// DescribeNetworkInterfaces returns registered enis, restricted to NetworkInterfaceIds or by the
+// network-interface-id and address-ip filters. Address ips are matched exactly.
+func (c *Cloud) DescribeNetworkInterfaces(request *vpc.DescribeNetworkInterfacesRequest) (*vpc.DescribeNetworkInterfacesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDescribeNetworkInterfaces); err != nil {
+		return nil, err
+	}
+
+	limit, offset := 0, 0
+	if request.Limit != nil {
+		limit = int(*request.Limit)
+	}
+	if request.Offset != nil {
+		offset = int(*request.Offset)
+	}
+	if limit > describeNetworkInterfacesMaxLimit {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "limit %d exceeds %d", limit, describeNetworkInterfacesMaxLimit)
+	}
+	if len(request.NetworkInterfaceIds) > 0 && len(request.Filters) > 0 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "NetworkInterfaceIds and Filters cannot be both set")
+	}
+
+	ids := make([]string, 0)
+	for _, id := range c.sortedEniIdsLocked() {
+		if len(request.NetworkInterfaceIds) > 0 && !util.ContainString(common.StringValues(request.NetworkInterfaceIds), id) {
+			continue
+		}
+		ok, err := c.matchEniFiltersLocked(c.enis[id], request.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			ids = append(ids, id)
+		}
+	}
+
+	start, end := page(len(ids), offset, limit, describeNetworkInterfacesDefaultLimit)
+	resp := vpc.NewDescribeNetworkInterfacesResponse()
+	initResponse(resp)
+	resp.Response.NetworkInterfaceSet = make([]*vpc.NetworkInterface, 0)
+	for _, id := range ids[start:end] {
+		eni := *c.enis[id]
+		resp.Response.NetworkInterfaceSet = append(resp.Response.NetworkInterfaceSet, &eni)
+	}
+	resp.Response.TotalCount = common.Uint64Ptr(uint64(len(ids)))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+func (c *Cloud) sortedEniIdsLocked() []string {
+	ids := make([]string, 0, len(c.enis))
+	for id := range c.enis {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return ids
+}
+
+func (c *Cloud) matchEniFiltersLocked(eni *vpc.NetworkInterface, filters []*vpc.Filter) (bool, error) {
+	for _, f := range filters {
+		if f == nil || f.Name == nil {
+			continue
+		}
+		values := common.StringValues(f.Values)
+		switch *f.Name {
+		case "network-interface-id":
+			if !util.ContainString(values, *eni.NetworkInterfaceId) {
+				return false, nil
+			}
+		case "ip-exact-match":
+			// address ips are always matched exactly
+		case "address-ip":
+			matched := false
+			for _, ip := range eni.PrivateIpAddressSet {
+				if util.ContainString(values, *ip.PrivateIpAddress) {
+					matched = true
+				}
+			}
+			if !matched {
+				return false, nil
+			}
+		default:
+			return false, c.errorf(ErrCodeInvalidParameterValue, "unsupported filter %s", *f.Name)
+		}
+	}
+	return true, nil
+}
+
+// hasPrivateIpLocked returns whether the private ip belongs to the registered eni
+func (c *Cloud) hasPrivateIpLocked(eniId, privateIp string) bool {
+	eni, ok := c.enis[eniId]
+	if !ok {
+		return false
+	}
+	for _, ip := range eni.PrivateIpAddressSet {
+		if *ip.PrivateIpAddress == privateIp {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,299 @@
+package fake
+
+import (
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
+	"tkestack.io/aia-ip-controller/pkg/controller/util"
+)
+
+const (
+	describeResourcesByTagsDefaultLimit       = 15
+	describeResourcesByTagsMaxLimit           = 200
+	describeResourceTagsByTagKeysDefaultLimit = 15
+	describeResourceTagsByTagKeysMaxLimit     = 400
+	describeResourceTagsByTagKeysMaxIds       = 20
+
+	addressServiceType    = "vpc"
+	addressResourcePrefix = "eip"
+)
+
+// addressServiceTypes are the service types an address is indexed under by tag api,
+// which is why tag api returns every address twice if ServiceType is not specified.
+var addressServiceTypes = []string{"cvm", addressServiceType}
+
+// CreateTag creates a tag key value pair, ResourceInUse.TagDuplicate is returned if it already exists
+func (c *Cloud) CreateTag(request *tag.CreateTagRequest) (*tag.CreateTagResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionCreateTag); err != nil {
+		return nil, err
+	}
+
+	if request.TagKey == nil || request.TagValue == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing TagKey or TagValue")
+	}
+	if _, ok := c.tagValues[*request.TagKey][*request.TagValue]; ok {
+		return nil, c.errorf(ErrCodeTagDuplicate, "tag %s:%s already existed", *request.TagKey, *request.TagValue)
+	}
+	if c.tagValues[*request.TagKey] == nil {
+		c.tagValues[*request.TagKey] = map[string]struct{}{}
+	}
+	c.tagValues[*request.TagKey][*request.TagValue] = struct{}{}
+
+	resp := tag.NewCreateTagResponse()
+	initResponse(resp)
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// DescribeResourcesByTags returns resources that match all tag filters, a filter without values
+// matches any value of the key.
+func (c *Cloud) DescribeResourcesByTags(request *tag.DescribeResourcesByTagsRequest) (*tag.DescribeResourcesByTagsResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDescribeResourcesByTags); err != nil {
+		return nil, err
+	}
+
+	limit, offset := 0, 0
+	if request.Limit != nil {
+		limit = int(*request.Limit)
+	}
+	if request.Offset != nil {
+		offset = int(*request.Offset)
+	}
+	if limit > describeResourcesByTagsMaxLimit {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "limit %d exceeds %d", limit, describeResourcesByTagsMaxLimit)
+	}
+	if len(request.TagFilters) == 0 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing TagFilters")
+	}
+
+	serviceTypes := addressServiceTypes
+	if request.ServiceType != nil {
+		serviceTypes = []string{*request.ServiceType}
+	}
+
+	rows := make([]*tag.ResourceTag, 0)
+	for _, id := range c.sortedAddressIdsLocked() {
+		if request.ResourceId != nil && *request.ResourceId != id {
+			continue
+		}
+		if request.ResourcePrefix != nil && *request.ResourcePrefix != addressResourcePrefix {
+			continue
+		}
+		if request.ResourceRegion != nil && *request.ResourceRegion != c.Region {
+			continue
+		}
+		if !c.matchTagFiltersLocked(id, request.TagFilters) {
+			continue
+		}
+		for _, st := range serviceTypes {
+			if !util.ContainString(addressServiceTypes, st) {
+				continue
+			}
+			rows = append(rows, &tag.ResourceTag{
+				ResourceRegion: common.StringPtr(c.Region),
+				ServiceType:    common.StringPtr(st),
+				ResourcePrefix: common.StringPtr(addressResourcePrefix),
+				ResourceId:     common.StringPtr(id),
+				Tags:           c.tagsOfLocked(id, nil),
+			})
+		}
+	}
+
+	start, end := page(len(rows), offset, limit, describeResourcesByTagsDefaultLimit)
+	resp := tag.NewDescribeResourcesByTagsResponse()
+	initResponse(resp)
+	resp.Response.Rows = rows[start:end]
+	resp.Response.TotalCount = common.Uint64Ptr(uint64(len(rows)))
+	resp.Response.Offset = common.Uint64Ptr(uint64(start))
+	resp.Response.Limit = common.Uint64Ptr(uint64(end - start))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// DescribeResourceTagsByTagKeys returns the values of TagKeys bound to at most 20 ResourceIds
+func (c *Cloud) DescribeResourceTagsByTagKeys(request *tag.DescribeResourceTagsByTagKeysRequest) (*tag.DescribeResourceTagsByTagKeysResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDescribeResourceTagsByTagKeys); err != nil {
+		return nil, err
+	}
+
+	limit, offset := 0, 0
+	if request.Limit != nil {
+		limit = int(*request.Limit)
+	}
+	if request.Offset != nil {
+		offset = int(*request.Offset)
+	}
+	if limit > describeResourceTagsByTagKeysMaxLimit {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "limit %d exceeds %d", limit, describeResourceTagsByTagKeysMaxLimit)
+	}
+	if request.ServiceType == nil || request.ResourcePrefix == nil || request.ResourceRegion == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing ServiceType, ResourcePrefix or ResourceRegion")
+	}
+	if len(request.ResourceIds) == 0 || len(request.ResourceIds) > describeResourceTagsByTagKeysMaxIds {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "invalid ResourceIds length %d", len(request.ResourceIds))
+	}
+	if len(request.TagKeys) == 0 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing TagKeys")
+	}
+
+	keys := make([]string, 0, len(request.TagKeys))
+	for _, k := range request.TagKeys {
+		if k != nil {
+			keys = append(keys, *k)
+		}
+	}
+
+	rows := make([]*tag.ResourceIdTag, 0)
+	if util.ContainString(addressServiceTypes, *request.ServiceType) && *request.ResourcePrefix == addressResourcePrefix &&
+		*request.ResourceRegion == c.Region {
+		for _, id := range request.ResourceIds {
+			if id == nil {
+				continue
+			}
+			if _, ok := c.addresses[*id]; !ok {
+				continue
+			}
+			tags := c.tagsOfLocked(*id, keys)
+			if len(tags) == 0 {
+				continue
+			}
+			rows = append(rows, &tag.ResourceIdTag{
+				ResourceId:   common.StringPtr(*id),
+				TagKeyValues: tags,
+			})
+		}
+	}
+
+	start, end := page(len(rows), offset, limit, describeResourceTagsByTagKeysDefaultLimit)
+	resp := tag.NewDescribeResourceTagsByTagKeysResponse()
+	initResponse(resp)
+	resp.Response.Rows = rows[start:end]
+	resp.Response.TotalCount = common.Uint64Ptr(uint64(len(rows)))
+	resp.Response.Offset = common.Uint64Ptr(uint64(start))
+	resp.Response.Limit = common.Uint64Ptr(uint64(end - start))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// AttachResourcesTag binds a tag to addresses, replacing the value if the key is already bound. The tag
+// must have been created through CreateTag before, otherwise InvalidTag.NotExisted is returned.
+func (c *Cloud) AttachResourcesTag(request *tag.AttachResourcesTagRequest) (*tag.AttachResourcesTagResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionAttachResourcesTag); err != nil {
+		return nil, err
+	}
+
+	if request.TagKey == nil || request.TagValue == nil || len(request.ResourceIds) == 0 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing TagKey, TagValue or ResourceIds")
+	}
+	if request.ServiceType == nil || request.ResourcePrefix == nil || request.ResourceRegion == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing ServiceType, ResourcePrefix or ResourceRegion")
+	}
+	if _, ok := c.tagValues[*request.TagKey][*request.TagValue]; !ok {
+		return nil, c.errorf(ErrCodeTagNotExisted, "tag %s:%s not existed", *request.TagKey, *request.TagValue)
+	}
+	if !util.ContainString(addressServiceTypes, *request.ServiceType) || *request.ResourcePrefix != addressResourcePrefix ||
+		*request.ResourceRegion != c.Region {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "unsupported resource %s/%s in %s", *request.ServiceType,
+			*request.ResourcePrefix, *request.ResourceRegion)
+	}
+	for _, id := range request.ResourceIds {
+		if id == nil {
+			return nil, c.errorf(ErrCodeInvalidParameterValue, "empty resource id")
+		}
+		if _, ok := c.addresses[*id]; !ok {
+			return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *id)
+		}
+	}
+	for _, id := range request.ResourceIds {
+		c.resourceTags[*id][*request.TagKey] = *request.TagValue
+	}
+
+	resp := tag.NewAttachResourcesTagResponse()
+	initResponse(resp)
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// DetachResourcesTag unbinds a tag key from addresses, keys not bound are ignored
+func (c *Cloud) DetachResourcesTag(request *tag.DetachResourcesTagRequest) (*tag.DetachResourcesTagResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDetachResourcesTag); err != nil {
+		return nil, err
+	}
+
+	if request.TagKey == nil || len(request.ResourceIds) == 0 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing TagKey or ResourceIds")
+	}
+	if request.ServiceType == nil || request.ResourcePrefix == nil || request.ResourceRegion == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing ServiceType, ResourcePrefix or ResourceRegion")
+	}
+	if !util.ContainString(addressServiceTypes, *request.ServiceType) || *request.ResourcePrefix != addressResourcePrefix ||
+		*request.ResourceRegion != c.Region {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "unsupported resource %s/%s in %s", *request.ServiceType,
+			*request.ResourcePrefix, *request.ResourceRegion)
+	}
+	for _, id := range request.ResourceIds {
+		if id == nil {
+			return nil, c.errorf(ErrCodeInvalidParameterValue, "empty resource id")
+		}
+		if _, ok := c.addresses[*id]; !ok {
+			return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *id)
+		}
+	}
+	for _, id := range request.ResourceIds {
+		delete(c.resourceTags[*id], *request.TagKey)
+	}
+
+	resp := tag.NewDetachResourcesTagResponse()
+	initResponse(resp)
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+func (c *Cloud) matchTagFiltersLocked(resourceId string, filters []*tag.TagFilter) bool {
+	for _, f := range filters {
+		if f == nil || f.TagKey == nil {
+			continue
+		}
+		v, ok := c.resourceTags[resourceId][*f.TagKey]
+		if !ok {
+			return false
+		}
+		if len(f.TagValue) == 0 {
+			continue
+		}
+		matched := false
+		for _, fv := range f.TagValue {
+			if fv != nil && *fv == v {
+				matched = true
+			}
+		}
+		if !matched {
+			return false
+		}
+	}
+	return true
+}
+
+// tagsOfLocked returns tags of a resource ordered by key, only keys in onlyKeys are returned if it is not nil
+func (c *Cloud) tagsOfLocked(resourceId string, onlyKeys []string) []*tag.Tag {
+	res := make([]*tag.Tag, 0)
+	for _, k := range sortedKeys(c.resourceTags[resourceId]) {
+		if onlyKeys != nil && !util.ContainString(onlyKeys, k) {
+			continue
+		}
+		res = append(res, &tag.Tag{
+			TagKey:   common.StringPtr(k),
+			TagValue: common.StringPtr(c.resourceTags[resourceId][k]),
+		})
+	}
+	return res
+}
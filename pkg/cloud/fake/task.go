@@ -0,0 +1,95 @@
+package fake
+
+import (
+	"fmt"
+	"strconv"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+)
+
+// results of DescribeTaskResult
+const (
+	TaskResultSuccess = "SUCCESS"
+	TaskResultFailed  = "FAILED"
+	TaskResultRunning = "RUNNING"
+)
+
+// task is the asynchronous task returned by a vpc api, it runs until the transitions of its addresses settle
+type task struct {
+	addressIds []string
+	failed     bool
+}
+
+// FailTransition makes the pending transition of the address fail, its task reports FAILED. A BINDING or
+// UNBINDING address settles back in its previous status, a CREATING address is removed.
+func (c *Cloud) FailTransition(addressId string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	a, ok := c.addresses[addressId]
+	if !ok || a.pendingStatus == "" {
+		return
+	}
+	if t, ok := c.tasks[a.taskId]; ok {
+		t.failed = true
+	}
+	if a.previousStatus == "" {
+		delete(c.addresses, addressId)
+		delete(c.resourceTags, addressId)
+		return
+	}
+	a.pendingStatus = a.previousStatus
+}
+
+// DescribeTaskResult reports RUNNING while a transition started by the task is pending, each call observes the
+// transition like DescribeAddresses does. Tasks of synchronous apis succeed immediately.
+func (c *Cloud) DescribeTaskResult(request *vpc.DescribeTaskResultRequest) (*vpc.DescribeTaskResultResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDescribeTaskResult); err != nil {
+		return nil, err
+	}
+
+	if request.TaskId == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing TaskId")
+	}
+	taskId := strconv.FormatUint(*request.TaskId, 10)
+	t, ok := c.tasks[taskId]
+	if !ok {
+		return nil, c.errorf(ErrCodeTaskNotFound, "task %s not found", taskId)
+	}
+	result := TaskResultSuccess
+	if t.failed {
+		result = TaskResultFailed
+	}
+	for _, id := range t.addressIds {
+		a, ok := c.addresses[id]
+		if !ok || a.taskId != taskId || t.failed {
+			continue
+		}
+		c.observeLocked(a)
+		if a.pendingStatus != "" {
+			result = TaskResultRunning
+		}
+	}
+
+	resp := vpc.NewDescribeTaskResultResponse()
+	initResponse(resp)
+	resp.Response.TaskId = common.Uint64Ptr(*request.TaskId)
+	resp.Response.Result = common.StringPtr(result)
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// startTaskLocked records a task of the transitions of the addresses and returns its id
+func (c *Cloud) startTaskLocked(addrs ...*address) string {
+	c.seq++
+	taskId := fmt.Sprintf("%d", c.seq)
+	t := &task{}
+	for _, a := range addrs {
+		a.taskId = taskId
+		t.addressIds = append(t.addressIds, *a.AddressId)
+	}
+	c.tasks[taskId] = t
+	return taskId
+}
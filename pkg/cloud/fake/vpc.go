@@ -0,0 +1,372 @@
+package fake
+
+import (
+	"strings"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"tkestack.io/aia-ip-controller/pkg/controller/util"
+)
+
+const (
+	describeAddressesDefaultLimit = 20
+	describeAddressesMaxLimit     = 100
+	releaseAddressesMaxCount      = 20
+)
+
+// DescribeAddresses supports AddressIds, pagination and the address-id, address-name, address-ip,
+// address-status, address-type, instance-id, network-interface-id, private-ip-address, tag-key and
+// tag:<key> filters. Like the real api, only EIP type is returned if neither AddressIds nor
+// address-type filter is given.
+func (c *Cloud) DescribeAddresses(request *vpc.DescribeAddressesRequest) (*vpc.DescribeAddressesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDescribeAddresses); err != nil {
+		return nil, err
+	}
+
+	limit, offset := 0, 0
+	if request.Limit != nil {
+		limit = int(*request.Limit)
+	}
+	if request.Offset != nil {
+		offset = int(*request.Offset)
+	}
+	if limit > describeAddressesMaxLimit || limit < 0 || offset < 0 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "invalid offset %d or limit %d", offset, limit)
+	}
+
+	candidates := make([]*address, 0)
+	if len(request.AddressIds) > 0 {
+		for _, id := range request.AddressIds {
+			if id == nil {
+				continue
+			}
+			if a, ok := c.addresses[*id]; ok {
+				candidates = append(candidates, a)
+			}
+		}
+	} else {
+		for _, id := range c.sortedAddressIdsLocked() {
+			candidates = append(candidates, c.addresses[id])
+		}
+	}
+
+	hasTypeFilter := false
+	for _, f := range request.Filters {
+		if f != nil && f.Name != nil && *f.Name == "address-type" {
+			hasTypeFilter = true
+		}
+	}
+
+	matched := make([]*address, 0)
+	for _, a := range candidates {
+		if len(request.AddressIds) == 0 && !hasTypeFilter && *a.AddressType != "EIP" {
+			continue
+		}
+		ok, err := c.matchFiltersLocked(a, request.Filters)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			matched = append(matched, a)
+		}
+	}
+
+	start, end := page(len(matched), offset, limit, describeAddressesDefaultLimit)
+	resp := vpc.NewDescribeAddressesResponse()
+	initResponse(resp)
+	resp.Response.AddressSet = make([]*vpc.Address, 0)
+	for _, a := range matched[start:end] {
+		c.observeLocked(a)
+		// a failed transition may settle in a status the filters no longer match
+		if ok, _ := c.matchFiltersLocked(a, request.Filters); !ok {
+			continue
+		}
+		resp.Response.AddressSet = append(resp.Response.AddressSet, c.snapshotLocked(a))
+	}
+	resp.Response.TotalCount = common.Int64Ptr(int64(len(matched)))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+func (c *Cloud) matchFiltersLocked(a *address, filters []*vpc.Filter) (bool, error) {
+	for _, f := range filters {
+		if f == nil || f.Name == nil {
+			continue
+		}
+		values := make([]string, 0, len(f.Values))
+		for _, v := range f.Values {
+			if v != nil {
+				values = append(values, *v)
+			}
+		}
+		var actual *string
+		switch {
+		case *f.Name == "address-id":
+			actual = a.AddressId
+		case *f.Name == "address-name":
+			actual = a.AddressName
+		case *f.Name == "address-ip":
+			actual = a.AddressIp
+		case *f.Name == "address-status":
+			actual = a.AddressStatus
+		case *f.Name == "address-type":
+			actual = a.AddressType
+		case *f.Name == "instance-id":
+			actual = a.InstanceId
+		case *f.Name == "network-interface-id":
+			actual = a.NetworkInterfaceId
+		case *f.Name == "private-ip-address":
+			actual = a.PrivateAddressIp
+		case *f.Name == "tag-key":
+			matched := false
+			for _, v := range values {
+				if _, ok := c.resourceTags[*a.AddressId][v]; ok {
+					matched = true
+				}
+			}
+			if !matched {
+				return false, nil
+			}
+			continue
+		case strings.HasPrefix(*f.Name, "tag:"):
+			tagVal, ok := c.resourceTags[*a.AddressId][strings.TrimPrefix(*f.Name, "tag:")]
+			actual = &tagVal
+			if !ok {
+				actual = nil
+			}
+		default:
+			return false, c.errorf(ErrCodeInvalidParameterValue, "unsupported filter %s", *f.Name)
+		}
+		if actual == nil || !util.ContainString(values, *actual) {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// AllocateAddresses creates AddressCount addresses in CREATING status, all tags must have been created
+// through CreateTag before, otherwise InvalidTag.NotExisted is returned.
+func (c *Cloud) AllocateAddresses(request *vpc.AllocateAddressesRequest) (*vpc.AllocateAddressesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionAllocateAddresses); err != nil {
+		return nil, err
+	}
+
+	count := 1
+	if request.AddressCount != nil {
+		count = int(*request.AddressCount)
+	}
+	if count < 1 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "invalid address count %d", count)
+	}
+	if c.AddressQuota > 0 && len(c.addresses)+count > c.AddressQuota {
+		return nil, c.errorf(ErrCodeAddressQuotaLimitExceeded, "address quota %d exceeded", c.AddressQuota)
+	}
+
+	tags := map[string]string{}
+	for _, t := range request.Tags {
+		if t == nil || t.Key == nil || t.Value == nil {
+			continue
+		}
+		if _, ok := c.tagValues[*t.Key][*t.Value]; !ok {
+			return nil, c.errorf(ErrCodeTagNotExisted, "tag %s:%s not existed", *t.Key, *t.Value)
+		}
+		tags[*t.Key] = *t.Value
+	}
+
+	resp := vpc.NewAllocateAddressesResponse()
+	initResponse(resp)
+	allocated := make([]*address, 0, count)
+	for i := 0; i < count; i++ {
+		a := c.newAddressLocked()
+		if request.AddressType != nil {
+			a.AddressType = common.StringPtr(*request.AddressType)
+		}
+		if request.AddressName != nil {
+			a.AddressName = common.StringPtr(*request.AddressName)
+		}
+		if request.InternetMaxBandwidthOut != nil {
+			a.Bandwidth = common.Uint64Ptr(uint64(*request.InternetMaxBandwidthOut))
+		}
+		if request.InternetChargeType != nil {
+			a.InternetChargeType = common.StringPtr(*request.InternetChargeType)
+		}
+		c.addresses[*a.AddressId] = a
+		c.resourceTags[*a.AddressId] = copyTags(tags)
+		c.transitLocked(a, AddressStatusCreating, AddressStatusUnbind)
+		allocated = append(allocated, a)
+		resp.Response.AddressSet = append(resp.Response.AddressSet, common.StringPtr(*a.AddressId))
+	}
+	resp.Response.TaskId = common.StringPtr(c.startTaskLocked(allocated...))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// AssociateAddress binds an UNBIND address to a registered instance or to a private ip of an eni
+func (c *Cloud) AssociateAddress(request *vpc.AssociateAddressRequest) (*vpc.AssociateAddressResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionAssociateAddress); err != nil {
+		return nil, err
+	}
+
+	if request.AddressId == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing AddressId")
+	}
+	a, ok := c.addresses[*request.AddressId]
+	if !ok {
+		return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *request.AddressId)
+	}
+	if *a.AddressStatus != AddressStatusUnbind {
+		return nil, c.errorf(ErrCodeAddressStatusNotPermit, "address %s status is %s", *a.AddressId, *a.AddressStatus)
+	}
+
+	switch {
+	case request.InstanceId != nil:
+		if _, ok := c.instances[*request.InstanceId]; !ok {
+			return nil, c.errorf(ErrCodeInstanceNotFound, "instance %s not found", *request.InstanceId)
+		}
+		for _, other := range c.addresses {
+			if other.InstanceId != nil && *other.InstanceId == *request.InstanceId && other.NetworkInterfaceId == nil {
+				return nil, c.errorf(ErrCodeInstanceAlreadyBindEip, "instance %s already bind address %s", *request.InstanceId, *other.AddressId)
+			}
+		}
+		a.InstanceId = common.StringPtr(*request.InstanceId)
+	case request.NetworkInterfaceId != nil && request.PrivateIpAddress != nil:
+		if !c.hasPrivateIpLocked(*request.NetworkInterfaceId, *request.PrivateIpAddress) {
+			return nil, c.errorf(ErrCodeNetworkInterfaceNotFound, "private ip %s of network interface %s not found",
+				*request.PrivateIpAddress, *request.NetworkInterfaceId)
+		}
+		a.NetworkInterfaceId = common.StringPtr(*request.NetworkInterfaceId)
+		a.PrivateAddressIp = common.StringPtr(*request.PrivateIpAddress)
+	default:
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "one of InstanceId or NetworkInterfaceId and PrivateIpAddress is required")
+	}
+	c.transitLocked(a, AddressStatusBinding, AddressStatusBind)
+
+	resp := vpc.NewAssociateAddressResponse()
+	initResponse(resp)
+	resp.Response.TaskId = common.StringPtr(c.startTaskLocked(a))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// DisassociateAddress unbinds a BIND address
+func (c *Cloud) DisassociateAddress(request *vpc.DisassociateAddressRequest) (*vpc.DisassociateAddressResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionDisassociateAddress); err != nil {
+		return nil, err
+	}
+
+	if request.AddressId == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing AddressId")
+	}
+	a, ok := c.addresses[*request.AddressId]
+	if !ok {
+		return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *request.AddressId)
+	}
+	if *a.AddressStatus != AddressStatusBind {
+		return nil, c.errorf(ErrCodeAddressStatusNotPermit, "address %s status is %s", *a.AddressId, *a.AddressStatus)
+	}
+	c.transitLocked(a, AddressStatusUnbinding, AddressStatusUnbind)
+
+	resp := vpc.NewDisassociateAddressResponse()
+	initResponse(resp)
+	resp.Response.TaskId = common.StringPtr(c.startTaskLocked(a))
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// ReleaseAddresses releases UNBIND addresses, nothing is released if any of them cannot be released
+func (c *Cloud) ReleaseAddresses(request *vpc.ReleaseAddressesRequest) (*vpc.ReleaseAddressesResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionReleaseAddresses); err != nil {
+		return nil, err
+	}
+
+	if len(request.AddressIds) == 0 || len(request.AddressIds) > releaseAddressesMaxCount {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "invalid AddressIds length %d", len(request.AddressIds))
+	}
+	for _, id := range request.AddressIds {
+		if id == nil {
+			return nil, c.errorf(ErrCodeInvalidParameterValue, "empty address id")
+		}
+		a, ok := c.addresses[*id]
+		if !ok {
+			return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *id)
+		}
+		if *a.AddressStatus != AddressStatusUnbind {
+			return nil, c.errorf(ErrCodeAddressStatusNotPermit, "address %s status is %s", *id, *a.AddressStatus)
+		}
+	}
+	for _, id := range request.AddressIds {
+		delete(c.addresses, *id)
+		delete(c.resourceTags, *id)
+	}
+
+	resp := vpc.NewReleaseAddressesResponse()
+	initResponse(resp)
+	resp.Response.TaskId = common.StringPtr(c.startTaskLocked())
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// ModifyAddressesBandwidth changes the bandwidth of existing addresses immediately
+func (c *Cloud) ModifyAddressesBandwidth(request *vpc.ModifyAddressesBandwidthRequest) (*vpc.ModifyAddressesBandwidthResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionModifyAddressesBandwidth); err != nil {
+		return nil, err
+	}
+
+	if len(request.AddressIds) == 0 || request.InternetMaxBandwidthOut == nil || *request.InternetMaxBandwidthOut < 1 {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing AddressIds or invalid InternetMaxBandwidthOut")
+	}
+	for _, id := range request.AddressIds {
+		if id == nil {
+			return nil, c.errorf(ErrCodeInvalidParameterValue, "empty address id")
+		}
+		if _, ok := c.addresses[*id]; !ok {
+			return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *id)
+		}
+	}
+	for _, id := range request.AddressIds {
+		c.addresses[*id].Bandwidth = common.Uint64Ptr(uint64(*request.InternetMaxBandwidthOut))
+	}
+
+	resp := vpc.NewModifyAddressesBandwidthResponse()
+	initResponse(resp)
+	resp.Response.TaskId = common.StringPtr(c.startTaskLocked())
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
+
+// ModifyAddressAttribute changes the name of an address
+func (c *Cloud) ModifyAddressAttribute(request *vpc.ModifyAddressAttributeRequest) (*vpc.ModifyAddressAttributeResponse, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if err := c.begin(ActionModifyAddressAttribute); err != nil {
+		return nil, err
+	}
+
+	if request.AddressId == nil {
+		return nil, c.errorf(ErrCodeInvalidParameterValue, "missing AddressId")
+	}
+	a, ok := c.addresses[*request.AddressId]
+	if !ok {
+		return nil, c.errorf(ErrCodeAddressNotFound, "address %s not found", *request.AddressId)
+	}
+	if request.AddressName != nil {
+		a.AddressName = common.StringPtr(*request.AddressName)
+	}
+
+	resp := vpc.NewModifyAddressAttributeResponse()
+	initResponse(resp)
+	resp.Response.RequestId = common.StringPtr(c.nextRequestId())
+	return resp, nil
+}
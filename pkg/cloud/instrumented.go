@@ -0,0 +1,114 @@
+package cloud
+
+import (
+	"time"
+
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+const (
+	serviceVpc = "vpc"
+	serviceTag = "tag"
+	serviceCvm = "cvm"
+)
+
+// NewInstrumentedClients wraps clients so that every api call is recorded in metrics
+func NewInstrumentedClients(clients *Clients) *Clients {
+	return &Clients{
+		Vpc: &instrumentedVpc{next: clients.Vpc},
+		Tag: &instrumentedTag{next: clients.Tag},
+		Cvm: &instrumentedCvm{next: clients.Cvm},
+	}
+}
+
+type instrumentedVpc struct {
+	next VpcAPI
+}
+
+func (i *instrumentedVpc) DescribeAddresses(request *vpc.DescribeAddressesRequest) (resp *vpc.DescribeAddressesResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "DescribeAddresses", start, err) }(time.Now())
+	return i.next.DescribeAddresses(request)
+}
+
+func (i *instrumentedVpc) AllocateAddresses(request *vpc.AllocateAddressesRequest) (resp *vpc.AllocateAddressesResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "AllocateAddresses", start, err) }(time.Now())
+	return i.next.AllocateAddresses(request)
+}
+
+func (i *instrumentedVpc) AssociateAddress(request *vpc.AssociateAddressRequest) (resp *vpc.AssociateAddressResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "AssociateAddress", start, err) }(time.Now())
+	return i.next.AssociateAddress(request)
+}
+
+func (i *instrumentedVpc) DisassociateAddress(request *vpc.DisassociateAddressRequest) (resp *vpc.DisassociateAddressResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "DisassociateAddress", start, err) }(time.Now())
+	return i.next.DisassociateAddress(request)
+}
+
+func (i *instrumentedVpc) ReleaseAddresses(request *vpc.ReleaseAddressesRequest) (resp *vpc.ReleaseAddressesResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "ReleaseAddresses", start, err) }(time.Now())
+	return i.next.ReleaseAddresses(request)
+}
+
+func (i *instrumentedVpc) ModifyAddressesBandwidth(request *vpc.ModifyAddressesBandwidthRequest) (resp *vpc.ModifyAddressesBandwidthResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "ModifyAddressesBandwidth", start, err) }(time.Now())
+	return i.next.ModifyAddressesBandwidth(request)
+}
+
+func (i *instrumentedVpc) ModifyAddressAttribute(request *vpc.ModifyAddressAttributeRequest) (resp *vpc.ModifyAddressAttributeResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "ModifyAddressAttribute", start, err) }(time.Now())
+	return i.next.ModifyAddressAttribute(request)
+}
+
+func (i *instrumentedVpc) DescribeNetworkInterfaces(request *vpc.DescribeNetworkInterfacesRequest) (resp *vpc.DescribeNetworkInterfacesResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "DescribeNetworkInterfaces", start, err) }(time.Now())
+	return i.next.DescribeNetworkInterfaces(request)
+}
+
+func (i *instrumentedVpc) DescribeTaskResult(request *vpc.DescribeTaskResultRequest) (resp *vpc.DescribeTaskResultResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceVpc, "DescribeTaskResult", start, err) }(time.Now())
+	return i.next.DescribeTaskResult(request)
+}
+
+type instrumentedTag struct {
+	next TagAPI
+}
+
+func (i *instrumentedTag) CreateTag(request *tag.CreateTagRequest) (resp *tag.CreateTagResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceTag, "CreateTag", start, err) }(time.Now())
+	return i.next.CreateTag(request)
+}
+
+func (i *instrumentedTag) DescribeResourcesByTags(request *tag.DescribeResourcesByTagsRequest) (resp *tag.DescribeResourcesByTagsResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceTag, "DescribeResourcesByTags", start, err) }(time.Now())
+	return i.next.DescribeResourcesByTags(request)
+}
+
+func (i *instrumentedTag) DescribeResourceTagsByTagKeys(request *tag.DescribeResourceTagsByTagKeysRequest) (resp *tag.DescribeResourceTagsByTagKeysResponse, err error) {
+	defer func(start time.Time) {
+		metrics.ObserveCloudAPI(serviceTag, "DescribeResourceTagsByTagKeys", start, err)
+	}(time.Now())
+	return i.next.DescribeResourceTagsByTagKeys(request)
+}
+
+func (i *instrumentedTag) AttachResourcesTag(request *tag.AttachResourcesTagRequest) (resp *tag.AttachResourcesTagResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceTag, "AttachResourcesTag", start, err) }(time.Now())
+	return i.next.AttachResourcesTag(request)
+}
+
+func (i *instrumentedTag) DetachResourcesTag(request *tag.DetachResourcesTagRequest) (resp *tag.DetachResourcesTagResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceTag, "DetachResourcesTag", start, err) }(time.Now())
+	return i.next.DetachResourcesTag(request)
+}
+
+type instrumentedCvm struct {
+	next CvmAPI
+}
+
+func (i *instrumentedCvm) DescribeInstances(request *cvm.DescribeInstancesRequest) (resp *cvm.DescribeInstancesResponse, err error) {
+	defer func(start time.Time) { metrics.ObserveCloudAPI(serviceCvm, "DescribeInstances", start, err) }(time.Now())
+	return i.next.DescribeInstances(request)
+}
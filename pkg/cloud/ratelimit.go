@@ -0,0 +1,333 @@
+package cloud
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"strings"
+	"time"
+
+	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
+	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"golang.org/x/time/rate"
+	"k8s.io/klog/v2"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+const (
+	// DefaultMaxRetries is how many times a throttled or failed call is retried by default
+	DefaultMaxRetries = 3
+	// DefaultRetryBaseDelay is the delay before the first retry by default, it doubles for each retry
+	DefaultRetryBaseDelay = 500 * time.Millisecond
+	// DefaultRetryMaxDelay is the max delay between retries by default
+	DefaultRetryMaxDelay = 5 * time.Second
+)
+
+// DefaultRateLimit is the token bucket of each service that has no limit configured, it is below the default
+// request rate limit of tencent cloud apis
+var DefaultRateLimit = RateLimit{QPS: 20, Burst: 20}
+
+// apiActions are the actions of each service that aia-ip-controller calls
+var apiActions = map[string][]string{
+	serviceVpc: {"DescribeAddresses", "AllocateAddresses", "AssociateAddress", "DisassociateAddress", "ReleaseAddresses",
+		"ModifyAddressesBandwidth", "ModifyAddressAttribute", "DescribeNetworkInterfaces", "DescribeTaskResult"},
+	serviceTag: {"CreateTag", "DescribeResourcesByTags", "DescribeResourceTagsByTagKeys", "AttachResourcesTag",
+		"DetachResourcesTag"},
+	serviceCvm: {"DescribeInstances"},
+}
+
+// RateLimit is a token bucket of QPS tokens per second holding at most Burst tokens
+type RateLimit struct {
+	QPS   float64
+	Burst int
+}
+
+// RateLimitOptions configures the client side rate limits and retries of cloud api calls
+type RateLimitOptions struct {
+	// Limits are keyed by service (vpc, tag or cvm) or by action, such as AllocateAddresses. An action with its
+	// own limit does not take tokens from its service, services without limit get DefaultRateLimit.
+	Limits map[string]RateLimit
+	// MaxRetries is how many times a call is retried, 0 means DefaultMaxRetries and a negative value disables retries
+	MaxRetries     int
+	RetryBaseDelay time.Duration
+	RetryMaxDelay  time.Duration
+}
+
+// IsRateLimitKey returns whether key is a service or an action that can be rate limited
+func IsRateLimitKey(key string) bool {
+	for service, actions := range apiActions {
+		if key == service {
+			return true
+		}
+		for _, action := range actions {
+			if key == action {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// rateLimiter holds the token buckets shared by all callers of the clients, and retries throttled calls, and failed
+// calls that are safe to repeat, with jittered exponential backoff
+type rateLimiter struct {
+	limiters       map[string]*rate.Limiter
+	maxRetries     int
+	retryBaseDelay time.Duration
+	retryMaxDelay  time.Duration
+	sleep          func(time.Duration)
+}
+
+func newRateLimiter(opts RateLimitOptions) (*rateLimiter, error) {
+	l := &rateLimiter{
+		limiters:       map[string]*rate.Limiter{},
+		maxRetries:     opts.MaxRetries,
+		retryBaseDelay: opts.RetryBaseDelay,
+		retryMaxDelay:  opts.RetryMaxDelay,
+		sleep:          time.Sleep,
+	}
+	if l.maxRetries == 0 {
+		l.maxRetries = DefaultMaxRetries
+	}
+	if l.retryBaseDelay <= 0 {
+		l.retryBaseDelay = DefaultRetryBaseDelay
+	}
+	if l.retryMaxDelay <= 0 {
+		l.retryMaxDelay = DefaultRetryMaxDelay
+	}
+	for key, limit := range opts.Limits {
+		if !IsRateLimitKey(key) {
+			return nil, fmt.Errorf("unknown cloud api %s to rate limit", key)
+		}
+		l.limiters[key] = newLimiter(limit)
+	}
+	for service := range apiActions {
+		if _, ok := l.limiters[service]; !ok {
+			l.limiters[service] = newLimiter(DefaultRateLimit)
+		}
+	}
+	return l, nil
+}
+
+// newLimiter returns the token bucket of limit, a zero QPS does not limit
+func newLimiter(limit RateLimit) *rate.Limiter {
+	if limit.QPS <= 0 {
+		return rate.NewLimiter(rate.Inf, 0)
+	}
+	burst := limit.Burst
+	if burst <= 0 {
+		burst = int(limit.QPS)
+		if burst < 1 {
+			burst = 1
+		}
+	}
+	return rate.NewLimiter(rate.Limit(limit.QPS), burst)
+}
+
+// wait blocks until the limiter of the action, or of its service, has a token
+func (l *rateLimiter) wait(service, action string) {
+	limiter, ok := l.limiters[action]
+	if !ok {
+		limiter = l.limiters[service]
+	}
+	start := time.Now()
+	if err := limiter.Wait(context.Background()); err != nil {
+		klog.Warningf("wait rate limiter of %s %s failed, err: %v", service, action, err)
+	}
+	metrics.CloudAPIRateLimitWait.WithLabelValues(service, action).Observe(time.Since(start).Seconds())
+}
+
+// do calls the action once the rate limit allows, and retries it if err is worth retrying
+func (l *rateLimiter) do(service, action string, call func() error) error {
+	var err error
+	for i := 0; ; i++ {
+		l.wait(service, action)
+		err = call()
+		if err == nil || i >= l.maxRetries || !shouldRetry(action, err) {
+			return err
+		}
+		class := clouderrors.ClassOf(err)
+		metrics.CloudAPIRetriesTotal.WithLabelValues(service, action, string(class)).Inc()
+		delay := l.backoff(i)
+		klog.V(2).Infof("%s %s failed with %s error, retry %d/%d after %s, err: %v", service, action, class, i+1,
+			l.maxRetries, delay.Round(time.Millisecond), err)
+		l.sleep(delay)
+	}
+}
+
+// backoff returns the jittered delay before retry i, between half and all of the exponential delay
+func (l *rateLimiter) backoff(i int) time.Duration {
+	delay := l.retryMaxDelay
+	if i < 16 && l.retryBaseDelay<<uint(i) < l.retryMaxDelay {
+		delay = l.retryBaseDelay << uint(i)
+	}
+	return delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))
+}
+
+// shouldRetry returns whether the failed call is retried. Throttled calls are rejected before they run, so they
+// are always retried. Other transient errors, such as network errors, are only retried for describe calls, since
+// a mutation may have been done before the error.
+func shouldRetry(action string, err error) bool {
+	switch clouderrors.ClassOf(err) {
+	case clouderrors.ClassThrottled:
+		return true
+	case clouderrors.ClassRetryable:
+		return strings.HasPrefix(action, "Describe")
+	}
+	return false
+}
+
+// NewRateLimitedClients wraps clients so that every api call waits for the rate limit of its service or action,
+// and throttled calls are retried. The limits are shared by all callers of the returned clients.
+func NewRateLimitedClients(clients *Clients, opts RateLimitOptions) (*Clients, error) {
+	l, err := newRateLimiter(opts)
+	if err != nil {
+		return nil, err
+	}
+	return newRateLimitedClients(clients, l), nil
+}
+
+func newRateLimitedClients(clients *Clients, l *rateLimiter) *Clients {
+	return &Clients{
+		Vpc: &rateLimitedVpc{next: clients.Vpc, limiter: l},
+		Tag: &rateLimitedTag{next: clients.Tag, limiter: l},
+		Cvm: &rateLimitedCvm{next: clients.Cvm, limiter: l},
+	}
+}
+
+type rateLimitedVpc struct {
+	next    VpcAPI
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedVpc) DescribeAddresses(request *vpc.DescribeAddressesRequest) (resp *vpc.DescribeAddressesResponse, err error) {
+	err = r.limiter.do(serviceVpc, "DescribeAddresses", func() (err error) {
+		resp, err = r.next.DescribeAddresses(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) AllocateAddresses(request *vpc.AllocateAddressesRequest) (resp *vpc.AllocateAddressesResponse, err error) {
+	err = r.limiter.do(serviceVpc, "AllocateAddresses", func() (err error) {
+		resp, err = r.next.AllocateAddresses(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) AssociateAddress(request *vpc.AssociateAddressRequest) (resp *vpc.AssociateAddressResponse, err error) {
+	err = r.limiter.do(serviceVpc, "AssociateAddress", func() (err error) {
+		resp, err = r.next.AssociateAddress(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) DisassociateAddress(request *vpc.DisassociateAddressRequest) (resp *vpc.DisassociateAddressResponse, err error) {
+	err = r.limiter.do(serviceVpc, "DisassociateAddress", func() (err error) {
+		resp, err = r.next.DisassociateAddress(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) ReleaseAddresses(request *vpc.ReleaseAddressesRequest) (resp *vpc.ReleaseAddressesResponse, err error) {
+	err = r.limiter.do(serviceVpc, "ReleaseAddresses", func() (err error) {
+		resp, err = r.next.ReleaseAddresses(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) ModifyAddressesBandwidth(request *vpc.ModifyAddressesBandwidthRequest) (resp *vpc.ModifyAddressesBandwidthResponse, err error) {
+	err = r.limiter.do(serviceVpc, "ModifyAddressesBandwidth", func() (err error) {
+		resp, err = r.next.ModifyAddressesBandwidth(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) ModifyAddressAttribute(request *vpc.ModifyAddressAttributeRequest) (resp *vpc.ModifyAddressAttributeResponse, err error) {
+	err = r.limiter.do(serviceVpc, "ModifyAddressAttribute", func() (err error) {
+		resp, err = r.next.ModifyAddressAttribute(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) DescribeNetworkInterfaces(request *vpc.DescribeNetworkInterfacesRequest) (resp *vpc.DescribeNetworkInterfacesResponse, err error) {
+	err = r.limiter.do(serviceVpc, "DescribeNetworkInterfaces", func() (err error) {
+		resp, err = r.next.DescribeNetworkInterfaces(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedVpc) DescribeTaskResult(request *vpc.DescribeTaskResultRequest) (resp *vpc.DescribeTaskResultResponse, err error) {
+	err = r.limiter.do(serviceVpc, "DescribeTaskResult", func() (err error) {
+		resp, err = r.next.DescribeTaskResult(request)
+		return err
+	})
+	return resp, err
+}
+
+type rateLimitedTag struct {
+	next    TagAPI
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedTag) CreateTag(request *tag.CreateTagRequest) (resp *tag.CreateTagResponse, err error) {
+	err = r.limiter.do(serviceTag, "CreateTag", func() (err error) {
+		resp, err = r.next.CreateTag(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedTag) DescribeResourcesByTags(request *tag.DescribeResourcesByTagsRequest) (resp *tag.DescribeResourcesByTagsResponse, err error) {
+	err = r.limiter.do(serviceTag, "DescribeResourcesByTags", func() (err error) {
+		resp, err = r.next.DescribeResourcesByTags(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedTag) DescribeResourceTagsByTagKeys(request *tag.DescribeResourceTagsByTagKeysRequest) (resp *tag.DescribeResourceTagsByTagKeysResponse, err error) {
+	err = r.limiter.do(serviceTag, "DescribeResourceTagsByTagKeys", func() (err error) {
+		resp, err = r.next.DescribeResourceTagsByTagKeys(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedTag) AttachResourcesTag(request *tag.AttachResourcesTagRequest) (resp *tag.AttachResourcesTagResponse, err error) {
+	err = r.limiter.do(serviceTag, "AttachResourcesTag", func() (err error) {
+		resp, err = r.next.AttachResourcesTag(request)
+		return err
+	})
+	return resp, err
+}
+
+func (r *rateLimitedTag) DetachResourcesTag(request *tag.DetachResourcesTagRequest) (resp *tag.DetachResourcesTagResponse, err error) {
+	err = r.limiter.do(serviceTag, "DetachResourcesTag", func() (err error) {
+		resp, err = r.next.DetachResourcesTag(request)
+		return err
+	})
+	return resp, err
+}
+
+type rateLimitedCvm struct {
+	next    CvmAPI
+	limiter *rateLimiter
+}
+
+func (r *rateLimitedCvm) DescribeInstances(request *cvm.DescribeInstancesRequest) (resp *cvm.DescribeInstancesResponse, err error) {
+	err = r.limiter.do(serviceCvm, "DescribeInstances", func() (err error) {
+		resp, err = r.next.DescribeInstances(request)
+		return err
+	})
+	return resp, err
+}
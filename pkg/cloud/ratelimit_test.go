@@ -0,0 +1,70 @@
+package cloud_test
+
+import (
+	"testing"
+	"time"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+)
+
+func newRateLimitedFake(t *testing.T, opts cloud.RateLimitOptions) (*cloudfake.Cloud, *cloud.Clients) {
+	fakeCloud := cloudfake.NewCloud()
+	opts.RetryBaseDelay, opts.RetryMaxDelay = time.Millisecond, time.Millisecond
+	clients, err := cloud.NewRateLimitedClients(fakeCloud.Clients(), opts)
+	if err != nil {
+		t.Fatalf("create rate limited clients failed, err: %v", err)
+	}
+	return fakeCloud, clients
+}
+
+func TestRateLimitedClientsRetryThrottledCalls(t *testing.T) {
+	fakeCloud, clients := newRateLimitedFake(t, cloud.RateLimitOptions{MaxRetries: 2})
+	for i := 0; i < 2; i++ {
+		fakeCloud.InjectError(cloudfake.ActionAllocateAddresses, cloudfake.NewError("RequestLimitExceeded", "too many requests"))
+	}
+
+	req := vpc.NewAllocateAddressesRequest()
+	if _, err := clients.Vpc.AllocateAddresses(req); err != nil {
+		t.Fatalf("expect throttled allocation retried, got %v", err)
+	}
+	if n := fakeCloud.Calls(cloudfake.ActionAllocateAddresses); n != 3 {
+		t.Errorf("expect 3 AllocateAddresses calls, got %d", n)
+	}
+
+	for i := 0; i < 3; i++ {
+		fakeCloud.InjectError(cloudfake.ActionAllocateAddresses, cloudfake.NewError("RequestLimitExceeded", "too many requests"))
+	}
+	if _, err := clients.Vpc.AllocateAddresses(req); err == nil {
+		t.Errorf("expect allocation failed after 2 retries")
+	}
+}
+
+func TestRateLimitedClientsRetryTransientErrorsOfDescribeOnly(t *testing.T) {
+	fakeCloud, clients := newRateLimitedFake(t, cloud.RateLimitOptions{})
+	networkErr := cloudfake.NewError("ClientError.NetworkError", "connection reset")
+
+	fakeCloud.InjectError(cloudfake.ActionDescribeAddresses, networkErr)
+	if _, err := clients.Vpc.DescribeAddresses(vpc.NewDescribeAddressesRequest()); err != nil {
+		t.Errorf("expect describe retried after a network error, got %v", err)
+	}
+
+	// the address may have been allocated before the connection was reset
+	fakeCloud.InjectError(cloudfake.ActionAllocateAddresses, networkErr)
+	if _, err := clients.Vpc.AllocateAddresses(vpc.NewAllocateAddressesRequest()); err == nil {
+		t.Errorf("expect allocation not retried after a network error")
+	}
+	if n := fakeCloud.Calls(cloudfake.ActionAllocateAddresses); n != 1 {
+		t.Errorf("expect 1 AllocateAddresses call, got %d", n)
+	}
+}
+
+func TestRateLimitedClientsRejectUnknownApi(t *testing.T) {
+	_, err := cloud.NewRateLimitedClients(cloudfake.NewCloud().Clients(), cloud.RateLimitOptions{
+		Limits: map[string]cloud.RateLimit{"clb": {QPS: 1}},
+	})
+	if err == nil {
+		t.Errorf("expect rate limit of unknown api rejected")
+	}
+}
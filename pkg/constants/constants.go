@@ -8,17 +8,54 @@ const (
 	EipTypeAnyCast        = "AnycastEIP"
 	EipTypeHighQualityEIP = "HighQualityEIP"
 
+	AnycastZoneOverseas = "ANYCAST_ZONE_OVERSEAS"
+	AnycastZoneGlobal   = "ANYCAST_ZONE_GLOBAL"
+
 	// event reasons
 	FailedAllocateAnycastIp  = "FailedAllocateAnycastIp"
 	FailedAssociateAnycastIP = "FailedAssociateAnycastIp"
 	AlreadyHasAnycastIp      = "AlreadyHasAnycastIp"
 	FailedUntaintNode        = "FailedUntaintNode"
+	FailedReleaseAnycastIp   = "FailedReleaseAnycastIp"
+	ForceRemovedFinalizer    = "ForceRemovedFinalizer"
+	AnycastIpReleased        = "AnycastIpReleased"
+	AnycastIpDisassociated   = "AnycastIpDisassociated"
+	DryRun                   = "DryRun"
+	ConfigReloaded           = "ConfigReloaded"
+	InvalidConfig            = "InvalidConfig"
+	AnycastIpModified        = "AnycastIpModified"
+	FailedModifyAnycastIp    = "FailedModifyAnycastIp"
+	InvalidNodeAnnotation    = "InvalidNodeAnnotation"
+	AnycastIpMigrating       = "AnycastIpMigrating"
+	AnycastIpMigrated        = "AnycastIpMigrated"
+	WarmAnycastIpAssigned    = "WarmAnycastIpAssigned"
+	AnycastIpReused          = "AnycastIpReused"
+	AnycastIpAdopted         = "AnycastIpAdopted"
+	FailedAdoptAnycastIp     = "FailedAdoptAnycastIp"
+	MissingLoadBalancerClass = "MissingLoadBalancerClass"
 
 	// tag annotation key
 	AiaIpControllerClusterUuidAnnoKey = "aia-official-cluster-uuid"
 	AiaIpControllerClusterIdAnnoKey   = "aia-official-cluster-id"
 	AiaNodeNameAnnoKey                = "aia-node-name"
 	AiaNodeInsIdAnnoKey               = "aia-node-ins-id"
+	AiaPoolNameAnnoKey                = "aia-pool-name"
+	// tags of standby addresses in the warm pool, the pool is the name of the AnycastIPPool or WarmPoolDefault
+	// for the aia config, the spec is a hash of the address type, anycast zone and charge type
+	AiaWarmPoolAnnoKey = "aia-warm-pool"
+	AiaWarmSpecAnnoKey = "aia-warm-spec"
+	WarmPoolDefault    = "_default"
+	// tags of addresses in sticky mode, the identity is the value of the identity label of the node, an address
+	// of a deleted node is retained since the unix time in aia-retained-since
+	AiaNodeIdentityAnnoKey  = "aia-node-identity"
+	AiaRetainedSinceAnnoKey = "aia-retained-since"
+	// tag of a static address assigned to a node by the user, the value is its release policy
+	AiaStaticPolicyAnnoKey = "aia-static-policy"
+	// tag of an address bound to the eni ip of a pod, the value is namespace/name of the pod
+	AiaPodNameAnnoKey = "aia-pod-name"
+	// tag of an address allocated in a batch until it is handed out to its node, the value is the unix time of
+	// the allocation
+	AiaBatchAllocatedAnnoKey = "aia-batch-allocated"
 
 	// anycast eip id
 	AnycastIdPrefix = "eip-"
@@ -35,6 +72,34 @@ const (
 	AnycastIpIdAnnotationKey = "tke.cloud.tencent.com/anycast-ip-id"
 	AnycastIpIpAnnotationKey = "tke.cloud.tencent.com/anycast-ip-address"
 
+	// node annotations overriding the address spec of the anycast ip of the node. Bandwidth is in Mbps, tags are
+	// comma separated key=value pairs added to the configured tags
+	AnycastIpBandwidthAnnotationKey = "tke.cloud.tencent.com/anycast-ip-bandwidth"
+	AnycastIpTypeAnnotationKey      = "tke.cloud.tencent.com/anycast-ip-type"
+	AnycastIpZoneAnnotationKey      = "tke.cloud.tencent.com/anycast-ip-zone"
+	AnycastIpTagsAnnotationKey      = "tke.cloud.tencent.com/anycast-ip-tags"
+	AnycastIpNameAnnotationKey      = "tke.cloud.tencent.com/anycast-ip-name"
+
+	// node annotations assigning an existing address to the node instead of allocating one, and deciding
+	// whether it is released or detached when the node is deleted
+	AnycastIpStaticIdAnnotationKey            = "tke.cloud.tencent.com/anycast-ip-static-id"
+	AnycastIpStaticReleasePolicyAnnotationKey = "tke.cloud.tencent.com/anycast-ip-static-release-policy"
+
+	// pod annotation asking for an anycast ip bound to the eni ip of the pod, the address is recorded in the
+	// anycast ip annotations of the pod
+	PodAnycastIpAnnotationKey = "tke.cloud.tencent.com/need-aia-ip"
+
+	// service annotation publishing the anycast ips of the ready aia nodes backing a LoadBalancer service
+	// in its load balancer status
+	ServiceAnycastIpAnnotationKey = "tke.cloud.tencent.com/aia-loadbalancer"
+	// ServiceLoadBalancerClass must be the load balancer class of annotated services, so that the service
+	// controller of the cloud provider leaves their load balancer status to aia-ip-controller
+	ServiceLoadBalancerClass = "tke.cloud.tencent.com/aia"
+
+	// node finalizer, and the annotation to remove it without waiting for the anycast ip to be released
+	NodeFinalizer                     = "tke.cloud.tencent.com/aia-ip-release"
+	ForceRemoveFinalizerAnnotationKey = "tke.cloud.tencent.com/aia-force-remove-finalizer"
+
 	// Credential env key
 	ClusterIdEnvKey = "AIA_CLUSTER_ID"
 	AppIdEnvKey     = "AIA_APP_ID"
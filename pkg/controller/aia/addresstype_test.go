@@ -0,0 +1,57 @@
+package aia
+
+import (
+	"testing"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileChoosesAddressTypeByRules(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.TypeRules = []config.AddressTypeRule{
+		{
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"flavor": "hq"}},
+			AddressType:  constants.EipTypeHighQualityEIP,
+		},
+		{
+			NodeSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"flavor": "plain"}},
+			AddressType:  constants.EipTypeCommon,
+		},
+	}
+	nodes := map[string]string{
+		tc.createNode(map[string]string{testAiaLabel: "true", "flavor": "hq"}).Name:    constants.EipTypeHighQualityEIP,
+		tc.createNode(map[string]string{testAiaLabel: "true", "flavor": "plain"}).Name: constants.EipTypeCommon,
+		tc.createNode(map[string]string{testAiaLabel: "true"}).Name:                    constants.EipTypeAnyCast,
+	}
+	for name, addressType := range nodes {
+		tc.reconcileUntilDone(name)
+		if got := tc.getBinding(name).Status.AddressType; got != addressType {
+			t.Errorf("expect node %s to get %s, got %s", name, addressType, got)
+		}
+	}
+}
+
+func TestReconcileAcceptsAddressOfAnotherType(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.TypeConflictPolicy = config.TypeConflictPolicyAccept
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	eipType := constants.EipTypeHighQualityEIP
+	addressId := tc.cloud.AddAddress(vpc.Address{AddressType: &eipType, InstanceId: &insId}, nil)
+
+	tc.reconcileUntilDone(node.Name)
+
+	if got := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got != addressId {
+		t.Errorf("expect existing address %s accepted, got %q", addressId, got)
+	}
+	if hasNoAiaTaint(tc.getNode(node.Name)) {
+		t.Errorf("expect node %s untainted", node.Name)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != 0 {
+		t.Errorf("expect no AllocateAddresses call, got %d", n)
+	}
+}
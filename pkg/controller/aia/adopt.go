@@ -0,0 +1,32 @@
+package aia
+
+import (
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// adoptNodeAddress tags an address found on the node that has no cluster tag with the tags of an allocated address,
+// so that it is converged, and released when the node is deleted. An address of another cluster is left as is.
+func (m *MangerImp) adoptNodeAddress(node *corev1.Node, eipInfo *vpc.Address, spec *AddressSpec) error {
+	if spec.AdoptionPolicy != config.AdoptionPolicyAdopt {
+		return nil
+	}
+	id := stringValue(eipInfo.AddressId)
+	if uuid, ok := vpcTagMap(eipInfo.TagSet)[constants.AiaIpControllerClusterUuidAnnoKey]; ok {
+		if uuid != m.clusterUuid {
+			klog.V(2).Infof("address %s of node %s is owned by cluster uuid %s, not going to adopt it", id, node.Name, uuid)
+		}
+		return nil
+	}
+
+	klog.Infof("adopt %s %s of node %s", stringValue(eipInfo.AddressType), id, node.Name)
+	if err := m.attachTags(node, id, m.addressTags(node, spec)); err != nil {
+		return err
+	}
+	m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpAdopted,
+		"Existing %s %s/%s adopted", stringValue(eipInfo.AddressType), id, stringValue(eipInfo.AddressIp))
+	return nil
+}
@@ -0,0 +1,61 @@
+package aia
+
+import (
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// createNodeWithAnycastIp creates an aia node bound to an untagged anycast ip, as bound by the user
+func (tc *testContext) createNodeWithAnycastIp() (*corev1.Node, string) {
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	id := tc.cloud.AddAddress(vpc.Address{AddressType: common.StringPtr(constants.EipTypeAnyCast), InstanceId: &insId}, nil)
+	return node, id
+}
+
+func TestReconcileAdoptsExistingAddress(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.AdoptionPolicy = config.AdoptionPolicyAdopt
+	node, id := tc.createNodeWithAnycastIp()
+
+	tc.reconcileUntilDone(node.Name)
+
+	tags := tc.cloud.ResourceTags(id)
+	for k, v := range map[string]string{
+		constants.AiaIpControllerClusterUuidAnnoKey: tc.r.clusterUuid,
+		constants.AiaNodeNameAnnoKey:                node.Name,
+		constants.AiaNodeInsIdAnnoKey:               node.Labels[constants.TkeNodeInsIdAnnoKey],
+	} {
+		if tags[k] != v {
+			t.Errorf("expect tag %s=%s on adopted address %s, got %v", k, v, id, tags)
+		}
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeNormal, constants.AnycastIpAdopted) {
+		t.Errorf("expect event %s", constants.AnycastIpAdopted)
+	}
+	if addr, _ := tc.cloud.GetAddress(id); *addr.Bandwidth != testBandwidth {
+		t.Errorf("expect adopted address converged to bandwidth %d, got %d", testBandwidth, *addr.Bandwidth)
+	}
+}
+
+func TestReconcileIgnoresExistingAddressByDefault(t *testing.T) {
+	tc := newTestContext(t)
+	node, id := tc.createNodeWithAnycastIp()
+
+	tc.reconcileUntilDone(node.Name)
+
+	if got := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got != id {
+		t.Errorf("expect existing address %s accepted, got %q", id, got)
+	}
+	if tags := tc.cloud.ResourceTags(id); len(tags) != 0 {
+		t.Errorf("expect existing address %s left untagged, got %v", id, tags)
+	}
+	if addr, _ := tc.cloud.GetAddress(id); *addr.Bandwidth == testBandwidth {
+		t.Errorf("expect existing address %s not converged", id)
+	}
+}
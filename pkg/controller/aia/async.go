@@ -0,0 +1,174 @@
+package aia
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+// defaultAsyncPollInterval is how often an address is checked while a cloud operation on it is in progress
+const defaultAsyncPollInterval = 2 * time.Second
+
+// asynchronous cloud operations on an address, they return a task id before the address settles
+const (
+	asyncAllocate     = "Allocate"
+	asyncAssociate    = "Associate"
+	asyncDisassociate = "Disassociate"
+)
+
+// results of vpc DescribeTaskResult
+const (
+	taskResultFailed = "FAILED"
+)
+
+// asyncTargetStatus is the status the address settles in when each operation succeeds
+var asyncTargetStatus = map[string]string{
+	asyncAllocate:     "UNBIND",
+	asyncAssociate:    "BIND",
+	asyncDisassociate: "UNBIND",
+}
+
+// isTransitionalStatus returns whether the address is changing its status
+func isTransitionalStatus(status string) bool {
+	switch status {
+	case "CREATING", "BINDING", "UNBINDING", "OFFLINING":
+		return true
+	}
+	return false
+}
+
+// operationInProgressError is returned while a cloud operation on an address has not finished, the caller checks
+// again after the poll interval instead of backing off as for a failure
+type operationInProgressError struct {
+	operation string
+	addressId string
+	status    string
+	taskId    string
+	elapsed   time.Duration
+}
+
+func (e *operationInProgressError) Error() string {
+	if e.operation == "" {
+		return fmt.Sprintf("waiting anycast ip %s to change it status, currently is %s", e.addressId, e.status)
+	}
+	return fmt.Sprintf("%s of anycast ip %s in progress for %s, status %s, taskId %s", e.operation, e.addressId,
+		e.elapsed.Round(time.Millisecond), e.status, e.taskId)
+}
+
+// isInProgress returns whether err only tells that a cloud operation is in progress
+func isInProgress(err error) bool {
+	var inProgress *operationInProgressError
+	return errors.As(err, &inProgress)
+}
+
+// asyncOperation is a cloud operation started on an address
+type asyncOperation struct {
+	operation string
+	taskId    string
+	start     time.Time
+}
+
+// asyncTracker records the running operation of each address, so that its task can be polled and the time it
+// takes observed
+type asyncTracker struct {
+	lock       sync.Mutex
+	operations map[string]*asyncOperation
+}
+
+func newAsyncTracker() *asyncTracker {
+	return &asyncTracker{operations: map[string]*asyncOperation{}}
+}
+
+// start records the operation on the address, replacing the previous one
+func (t *asyncTracker) start(operation, addressId, taskId string) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.operations[addressId] = &asyncOperation{operation: operation, taskId: taskId, start: time.Now()}
+}
+
+// get returns a copy of the operation on the address, or nil if there is none
+func (t *asyncTracker) get(addressId string) *asyncOperation {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	op, ok := t.operations[addressId]
+	if !ok {
+		return nil
+	}
+	res := *op
+	return &res
+}
+
+// finish forgets the operation on the address and observes how long it took
+func (t *asyncTracker) finish(addressId, result string) {
+	t.lock.Lock()
+	op, ok := t.operations[addressId]
+	delete(t.operations, addressId)
+	t.lock.Unlock()
+	if !ok {
+		return
+	}
+	elapsed := time.Since(op.start)
+	metrics.AsyncOperationDuration.WithLabelValues(op.operation, result).Observe(elapsed.Seconds())
+	klog.Infof("%s of anycast ip %s finished with %s after %s, taskId %s", op.operation, addressId, result,
+		elapsed.Round(time.Millisecond), op.taskId)
+}
+
+// startAsync records an operation that was just started on the address and returns it as in progress
+func (m *MangerImp) startAsync(operation, addressId, taskId, status string) error {
+	m.async.start(operation, addressId, taskId)
+	return &operationInProgressError{operation: operation, addressId: addressId, status: status, taskId: taskId}
+}
+
+// waitAsync checks the operation on the address against its current status. The operation is finished if the
+// address settled, an operationInProgressError is returned while the address is changing its status, unless
+// the task of the operation failed.
+func (m *MangerImp) waitAsync(addressId, status string) error {
+	op := m.async.get(addressId)
+	if !isTransitionalStatus(status) {
+		if op != nil {
+			result := metrics.ResultSuccess
+			if status != asyncTargetStatus[op.operation] {
+				klog.Warningf("%s of anycast ip %s settled in status %s, taskId %s", op.operation, addressId, status, op.taskId)
+				result = metrics.ResultError
+			}
+			m.async.finish(addressId, result)
+		}
+		return nil
+	}
+	if op == nil {
+		return &operationInProgressError{addressId: addressId, status: status}
+	}
+	if failed, err := m.isTaskFailed(op.taskId); err != nil {
+		klog.Warningf("describe task %s of %s of anycast ip %s failed, err: %v", op.taskId, op.operation, addressId, err)
+	} else if failed {
+		m.async.finish(addressId, metrics.ResultError)
+		return fmt.Errorf("%s of anycast ip %s failed, taskId %s", op.operation, addressId, op.taskId)
+	}
+	return &operationInProgressError{operation: op.operation, addressId: addressId, status: status, taskId: op.taskId,
+		elapsed: time.Since(op.start)}
+}
+
+// isTaskFailed returns whether the asynchronous task failed, a task id that is not a number is not polled
+func (m *MangerImp) isTaskFailed(taskId string) (bool, error) {
+	id, err := strconv.ParseUint(taskId, 10, 64)
+	if err != nil {
+		return false, nil
+	}
+	req := vpc.NewDescribeTaskResultRequest()
+	req.TaskId = common.Uint64Ptr(id)
+	resp, err := m.vpcClient.DescribeTaskResult(req)
+	if err != nil {
+		return false, err
+	}
+	if resp == nil || resp.Response == nil {
+		return false, fmt.Errorf("DescribeTaskResult of task %s has no response", taskId)
+	}
+	return stringValue(resp.Response.Result) == taskResultFailed, nil
+}
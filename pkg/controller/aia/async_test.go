@@ -0,0 +1,79 @@
+package aia
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcilePollsAssociationWithoutError(t *testing.T) {
+	tc := newTestContext(t)
+	tc.cloud.TransitionDescribes = 3
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+
+	polled := 0
+	for i := 0; i < reconcileLimit; i++ {
+		res, err := tc.r.Reconcile(context.TODO(), req)
+		if err != nil {
+			// the tags of the address are created in the first round
+			if i == 0 {
+				continue
+			}
+			t.Fatalf("expect round %d to poll rather than fail, got %v", i, err)
+		}
+		if res.RequeueAfter == 0 {
+			break
+		}
+		if res.RequeueAfter != tc.r.asyncPollInterval {
+			t.Errorf("expect round %d to requeue after %s, got %s", i, tc.r.asyncPollInterval, res.RequeueAfter)
+		}
+		polled++
+	}
+
+	if polled == 0 {
+		t.Errorf("expect the address to be polled while it is changing its status")
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionDescribeTaskResult); n == 0 {
+		t.Errorf("expect the tasks of the address to be polled")
+	}
+	if got := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got == "" {
+		t.Errorf("expect node %s bound to an anycast ip", node.Name)
+	}
+	if binding := tc.getBinding(node.Name); binding.Status.LastError != "" {
+		t.Errorf("expect no error recorded while polling, got %s", binding.Status.LastError)
+	}
+}
+
+func TestReconcileReportsFailedAssociationTask(t *testing.T) {
+	tc := newTestContext(t)
+	tc.cloud.TransitionDescribes = 3
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+
+	for i := 0; i < reconcileLimit && tc.cloud.Calls(cloudfake.ActionAssociateAddress) == 0; i++ {
+		_, _ = tc.r.Reconcile(context.TODO(), req)
+	}
+	id := tc.getBinding(node.Name).Status.AddressID
+	tc.cloud.FailTransition(id)
+
+	if _, err := tc.r.Reconcile(context.TODO(), req); err == nil || !strings.Contains(err.Error(), "failed") {
+		t.Fatalf("expect the failed association task of %s to fail reconcile, got %v", id, err)
+	}
+	if binding := tc.getBinding(node.Name); binding.Status.LastError == "" {
+		t.Errorf("expect the failed association task recorded in the binding")
+	}
+
+	tc.reconcileUntilDone(node.Name)
+	if got := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got != id {
+		t.Errorf("expect node %s bound to anycast ip %s after associating again, got %q", node.Name, id, got)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionAssociateAddress); n != 2 {
+		t.Errorf("expect 2 AssociateAddress calls, got %d", n)
+	}
+}
@@ -0,0 +1,352 @@
+package aia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+// allocateAddressesBatch is the max number of addresses allocated for the nodes by one AllocateAddresses call
+const allocateAddressesBatch = 20
+
+// leftoverReleaseBackoff retries the release of an address allocated in a batch but not handed out for about 3m,
+// it can not be released before it finishes allocating
+var leftoverReleaseBackoff = wait.Backoff{Duration: 200 * time.Millisecond, Factor: 2, Steps: 10}
+
+const (
+	// batchAddressGracePeriod is how long an address allocated in a batch may stay without its node before it is
+	// released by the sweep, far longer than handing out a batch takes
+	batchAddressGracePeriod = 10 * time.Minute
+	// batchAddressSyncPeriod is how often addresses allocated in a batch are checked for being left over
+	batchAddressSyncPeriod = 10 * time.Minute
+)
+
+// batchResult is the result of one request of a batch, id is the allocated address if any
+type batchResult struct {
+	id  string
+	err error
+}
+
+type batchRequest struct {
+	item interface{}
+	done chan batchResult
+}
+
+type pendingBatch struct {
+	requests []*batchRequest
+	timer    *time.Timer
+}
+
+// coalescer groups the requests submitted with the same key into one batch of at most maxSize requests, flush
+// handles a batch and returns the result of each of its requests in order. A request is flushed right away if
+// nothing of its key is pending or being flushed, otherwise it waits up to window for the requests submitted
+// after it. Requests are flushed one by one if window is 0.
+type coalescer struct {
+	window  time.Duration
+	maxSize int
+	flush   func(items []interface{}) []batchResult
+
+	lock    sync.Mutex
+	pending map[string]*pendingBatch
+	// flushing counts the batches of each key being flushed
+	flushing map[string]int
+}
+
+func newCoalescer(window time.Duration, maxSize int, flush func(items []interface{}) []batchResult) *coalescer {
+	return &coalescer{window: window, maxSize: maxSize, flush: flush, pending: map[string]*pendingBatch{},
+		flushing: map[string]int{}}
+}
+
+// submit adds item to the pending batch of key and waits for the batch to be flushed
+func (c *coalescer) submit(key string, item interface{}) batchResult {
+	if c.window <= 0 || c.maxSize <= 1 {
+		return c.flush([]interface{}{item})[0]
+	}
+	req := &batchRequest{item: item, done: make(chan batchResult, 1)}
+	c.lock.Lock()
+	b, ok := c.pending[key]
+	if !ok && c.flushing[key] == 0 {
+		// there is nothing to batch with, do not wait
+		c.flushing[key]++
+		c.lock.Unlock()
+		c.run(key, &pendingBatch{requests: []*batchRequest{req}})
+		return <-req.done
+	}
+	if !ok {
+		b = &pendingBatch{}
+		c.pending[key] = b
+		b.timer = time.AfterFunc(c.window, func() { c.flushPending(key, b) })
+	}
+	b.requests = append(b.requests, req)
+	full := len(b.requests) >= c.maxSize
+	if full {
+		delete(c.pending, key)
+		c.flushing[key]++
+		b.timer.Stop()
+	}
+	c.lock.Unlock()
+
+	if full {
+		go c.run(key, b)
+	}
+	return <-req.done
+}
+
+// flushPending flushes b when its window ends, unless it was already flushed because it was full
+func (c *coalescer) flushPending(key string, b *pendingBatch) {
+	c.lock.Lock()
+	if c.pending[key] != b {
+		c.lock.Unlock()
+		return
+	}
+	delete(c.pending, key)
+	c.flushing[key]++
+	c.lock.Unlock()
+	c.run(key, b)
+}
+
+func (c *coalescer) run(key string, b *pendingBatch) {
+	defer func() {
+		c.lock.Lock()
+		if c.flushing[key]--; c.flushing[key] == 0 {
+			delete(c.flushing, key)
+		}
+		c.lock.Unlock()
+	}()
+	items := make([]interface{}, 0, len(b.requests))
+	for _, req := range b.requests {
+		items = append(items, req.item)
+	}
+	results := c.flush(items)
+	for i, req := range b.requests {
+		req.done <- results[i]
+	}
+}
+
+// allocateItem is a node waiting for an address of spec
+type allocateItem struct {
+	node *corev1.Node
+	spec *AddressSpec
+}
+
+// allocateBatchKey returns the key grouping the nodes whose addresses can be allocated by one call, that is
+// those whose specs only differ in the node tags
+func (m *MangerImp) allocateBatchKey(spec *AddressSpec) string {
+	tags := make([]string, 0, len(spec.Tags))
+	for k, v := range m.specTags(spec) {
+		tags = append(tags, k+"="+v)
+	}
+	sort.Strings(tags)
+	return fmt.Sprintf("%s/%s/%d/%s/%s/%s", spec.AddressType, spec.AnycastZone, spec.Bandwidth,
+		spec.InternetChargeType, spec.AddressName, strings.Join(tags, ","))
+}
+
+// allocateBatch allocates the addresses of a batch of nodes by one AllocateAddresses call with the tags the nodes
+// share, and hands them out to the nodes by attaching the node tags. The addresses carry the aia-batch-allocated tag
+// until they are handed out, so that those left over by a crash are found by the sweep. An address failed to be
+// handed out is released.
+func (m *MangerImp) allocateBatch(items []interface{}) []batchResult {
+	results := make([]batchResult, len(items))
+	if len(items) == 1 || m.dryRun.enabled {
+		for i, item := range items {
+			a := item.(*allocateItem)
+			results[i].id, results[i].err = m.allocateNodeAddress(a.node, a.spec)
+		}
+		return results
+	}
+	fail := func(err error) []batchResult {
+		for i, item := range items {
+			m.eventRecorder.Eventf(item.(*allocateItem).node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp,
+				"Failed to allocate anycast ip (will retry): %s", clouderrors.Describe(err))
+			results[i].err = err
+		}
+		return results
+	}
+
+	tags := m.specTags(items[0].(*allocateItem).spec)
+	tags[constants.AiaBatchAllocatedAnnoKey] = batchAllocatedSince()
+	req := newAllocateRequest(items[0].(*allocateItem).spec, tags)
+	req.AddressCount = common.Int64Ptr(int64(len(items)))
+	resp, err := m.vpcClient.AllocateAddresses(req)
+	if err != nil {
+		klog.Warningf("allocate %d anycast ip in batch failed, err: %v", len(items), err)
+		// eip api does not create tags, create them and try again instead of failing the whole batch
+		if tagCreateErr := m.createTags(nil, tags); tagCreateErr != nil {
+			return fail(fmt.Errorf("AllocateAddresses failed: %s.  CreateTag failed: %s", err.Error(), tagCreateErr.Error()))
+		}
+		if resp, err = m.vpcClient.AllocateAddresses(req); err != nil {
+			return fail(err)
+		}
+	}
+	if resp == nil || resp.Response == nil {
+		return fail(fmt.Errorf("allocate %d anycast ip in batch has no response", len(items)))
+	}
+	ids := common.StringValues(resp.Response.AddressSet)
+	metrics.AddressBatchSize.WithLabelValues("AllocateAddresses").Observe(float64(len(items)))
+	klog.Infof("allocate anycast ip %v in batch success, requestId: %s", ids, stringValue(resp.Response.RequestId))
+
+	var leftover []string
+	if len(ids) > len(items) {
+		leftover = append(leftover, ids[len(items):]...)
+	}
+	for i, item := range items {
+		a := item.(*allocateItem)
+		if i >= len(ids) {
+			results[i].err = fmt.Errorf("allocate %d anycast ip in batch got %d address set, requestId: %s",
+				len(items), len(ids), stringValue(resp.Response.RequestId))
+			continue
+		}
+		id := ids[i]
+		if !strings.HasPrefix(id, constants.AnycastIdPrefix) {
+			results[i].err = fmt.Errorf("allocate address got an invalid(has no prefix %s) anycast ip id: %s", constants.AnycastIdPrefix, id)
+			continue
+		}
+		m.async.start(asyncAllocate, id, stringValue(resp.Response.TaskId))
+		if err := m.attachTags(a.node, id, nodeTags(a.node, a.spec)); err != nil {
+			klog.Warningf("hand out anycast ip %s allocated in batch to node %s failed, release it, err: %v",
+				id, a.node.Name, err)
+			leftover = append(leftover, id)
+			results[i].err = err
+			continue
+		}
+		// the address belongs to the node now, the sweep detaches the tag again if this fails
+		if err := m.detachTags(a.node, id, []string{constants.AiaBatchAllocatedAnnoKey}); err != nil {
+			klog.Warningf("detach tag %s from anycast ip %s of node %s failed, err: %v",
+				constants.AiaBatchAllocatedAnnoKey, id, a.node.Name, err)
+		}
+		results[i].id = id
+	}
+	if len(leftover) > 0 {
+		go m.releaseLeftoverAddresses(leftover)
+	}
+	return results
+}
+
+// releaseLeftoverAddresses releases the addresses allocated in a batch but not handed out, retrying until they
+// finish allocating
+func (m *MangerImp) releaseLeftoverAddresses(ids []string) {
+	for _, id := range ids {
+		err := wait.ExponentialBackoff(leftoverReleaseBackoff, func() (bool, error) {
+			if err := m.releaseAddress(id); err != nil {
+				return false, nil
+			}
+			m.async.finish(id, metrics.ResultError)
+			return true, nil
+		})
+		if err != nil {
+			klog.Errorf("release anycast ip %s left over by a batch failed, it is left to the sweep, err: %v",
+				id, err)
+		}
+	}
+}
+
+// releaseBatch releases a batch of addresses by one ReleaseAddresses call, the addresses are released one by
+// one if the call fails so that the error is returned for the addresses it belongs to only
+func (m *MangerImp) releaseBatch(items []interface{}) []batchResult {
+	results := make([]batchResult, len(items))
+	ids := make([]string, 0, len(items))
+	for _, item := range items {
+		ids = append(ids, item.(string))
+	}
+	if len(ids) > 1 {
+		req := vpc.NewReleaseAddressesRequest()
+		req.AddressIds = common.StringPtrs(ids)
+		_, err := m.vpcClient.ReleaseAddresses(req)
+		if err == nil {
+			metrics.AddressBatchSize.WithLabelValues("ReleaseAddresses").Observe(float64(len(ids)))
+			klog.Infof("release anycast ip %v in batch success", ids)
+			return results
+		}
+		klog.Warningf("release anycast ip %v in batch failed, release them one by one, err: %v", ids, err)
+	}
+	for i, id := range ids {
+		results[i].err = m.releaseAddress(id)
+	}
+	return results
+}
+
+// batchAllocatedSince returns the value of the aia-batch-allocated tag of an address allocated now. The time is
+// rounded up to the hour to keep the number of tag values low, so an address is never taken for older than it is.
+func batchAllocatedSince() string {
+	now := time.Now()
+	since := now.Truncate(time.Hour)
+	if since.Before(now) {
+		since = since.Add(time.Hour)
+	}
+	return strconv.FormatInt(since.Unix(), 10)
+}
+
+// ListBatchAnycastIps returns the addresses of the cluster that have the aia-batch-allocated tag
+func (m *MangerImp) ListBatchAnycastIps() ([]*vpc.Address, error) {
+	return m.listTaggedAddresses(constants.AiaBatchAllocatedAnnoKey)
+}
+
+// UnmarkBatchAnycastIp detaches the aia-batch-allocated tag from an address handed out to its node
+func (m *MangerImp) UnmarkBatchAnycastIp(anycastIpId string) error {
+	return m.detachTags(nil, anycastIpId, []string{constants.AiaBatchAllocatedAnnoKey})
+}
+
+// isBatchAllocationStale returns whether the address allocated in a batch at the unix time since should have been
+// handed out by now, an address without a valid time is not
+func isBatchAllocationStale(since string) bool {
+	sec, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(sec, 0)) > batchAddressGracePeriod
+}
+
+// ReleaseLeftoverBatchAddresses releases the addresses allocated in a batch that were never handed out to their
+// nodes, e.g. because the controller crashed in the middle of a batch. The tag is only detached from an address
+// that has its node, which failed to be done after it was handed out.
+func (r *reconciler) ReleaseLeftoverBatchAddresses(ctx context.Context) {
+	addrs, err := r.AiaManger.ListBatchAnycastIps()
+	if err != nil {
+		klog.Errorf("list anycast ips allocated in batch failed, err: %v", err)
+		return
+	}
+	for _, addr := range addrs {
+		id := stringValue(addr.AddressId)
+		tags := vpcTagMap(addr.TagSet)
+		if !isBatchAllocationStale(tags[constants.AiaBatchAllocatedAnnoKey]) {
+			continue
+		}
+		if nodeName := tags[constants.AiaNodeNameAnnoKey]; nodeName != "" {
+			klog.Infof("anycast ip %s allocated in batch was handed out to node %s, detach tag %s", id, nodeName,
+				constants.AiaBatchAllocatedAnnoKey)
+			if err := r.AiaManger.UnmarkBatchAnycastIp(id); err != nil && !errors.Is(err, errDryRun) {
+				klog.Errorf("detach tag %s from anycast ip %s failed, err: %v", constants.AiaBatchAllocatedAnnoKey, id, err)
+			}
+			continue
+		}
+		if stringValue(addr.InstanceId) != "" || stringValue(addr.NetworkInterfaceId) != "" ||
+			stringValue(addr.AddressStatus) != constants.AnycastStatusUnBind {
+			klog.Warningf("anycast ip %s allocated in batch is %s, leave it", id, stringValue(addr.AddressStatus))
+			continue
+		}
+		klog.Infof("anycast ip %s allocated in batch was never handed out, release it", id)
+		if err := r.AiaManger.ReleaseAnycastIp(id); err != nil && !errors.Is(err, errDryRun) {
+			klog.Errorf("release anycast ip %s left over by a batch failed, err: %v", id, err)
+		}
+	}
+}
+
+// RunBatchAddressSweep releases the addresses left over by batches periodically until ctx is done, it runs on the
+// leader only
+func (r *reconciler) RunBatchAddressSweep(ctx context.Context) {
+	wait.UntilWithContext(ctx, r.ReleaseLeftoverBatchAddresses, batchAddressSyncPeriod)
+}
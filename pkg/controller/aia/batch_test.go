@@ -0,0 +1,225 @@
+package aia
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestCoalescerFlushesLoneRequestWithoutWaiting(t *testing.T) {
+	c := newCoalescer(time.Hour, 10, func(items []interface{}) []batchResult {
+		return make([]batchResult, len(items))
+	})
+	done := make(chan struct{})
+	go func() {
+		c.submit("key", 1)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expect a request with nothing to batch with flushed without waiting the window")
+	}
+}
+
+func TestCoalescerBatchesRequestsWhileFlushing(t *testing.T) {
+	flushing := make(chan struct{})
+	unblock := make(chan struct{})
+	var lock sync.Mutex
+	var sizes []int
+	c := newCoalescer(time.Hour, 3, func(items []interface{}) []batchResult {
+		lock.Lock()
+		sizes = append(sizes, len(items))
+		first := len(sizes) == 1
+		lock.Unlock()
+		if first {
+			close(flushing)
+			<-unblock
+		}
+		return make([]batchResult, len(items))
+	})
+
+	first := make(chan struct{})
+	go func() {
+		c.submit("key", 0)
+		close(first)
+	}()
+	<-flushing
+	// the requests submitted while the first one is flushed are batched, a full batch does not wait the window
+	var wg sync.WaitGroup
+	for i := 1; i <= 3; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			c.submit("key", i)
+		}(i)
+	}
+	wg.Wait()
+	close(unblock)
+	<-first
+
+	if len(sizes) != 2 || sizes[0] != 1 || sizes[1] != 3 {
+		t.Errorf("expect batches of 1 and 3 requests, got %v", sizes)
+	}
+}
+
+func TestAllocateAnycastIpInBatch(t *testing.T) {
+	tc := newTestContext(t)
+	m := tc.r.AiaManger.(*MangerImp)
+	nodes := make([]*corev1.Node, 0, 3)
+	items := make([]interface{}, 0, 3)
+	for i := 0; i < 3; i++ {
+		node := tc.createNode(map[string]string{testAiaLabel: "true"})
+		nodes = append(nodes, node)
+		items = append(items, &allocateItem{node: node, spec: tc.r.defaultAddressSpec(node)})
+	}
+
+	results := m.allocateBatch(items)
+
+	// the first call fails since the tags are not created yet
+	allocated := tc.cloud.Calls(cloudfake.ActionAllocateAddresses)
+	if allocated != 2 {
+		t.Errorf("expect 2 AllocateAddresses calls for %d nodes, got %d", len(nodes), allocated)
+	}
+	seen := map[string]bool{}
+	for i, node := range nodes {
+		if results[i].err != nil {
+			t.Fatalf("allocate anycast ip for node %s failed: %v", node.Name, results[i].err)
+		}
+		id := results[i].id
+		if seen[id] {
+			t.Errorf("expect anycast ip %s allocated to one node only", id)
+		}
+		seen[id] = true
+		tags := tc.cloud.ResourceTags(id)
+		if tags[constants.AiaNodeNameAnnoKey] != node.Name {
+			t.Errorf("expect anycast ip %s tagged for node %s, got %v", id, node.Name, tags)
+		}
+		if _, ok := tags[constants.AiaWarmPoolAnnoKey]; ok {
+			t.Errorf("expect anycast ip %s allocated in batch not in a warm pool, got %v", id, tags)
+		}
+		if _, ok := tags[constants.AiaBatchAllocatedAnnoKey]; ok {
+			t.Errorf("expect tag %s detached from anycast ip %s handed out, got %v", constants.AiaBatchAllocatedAnnoKey, id, tags)
+		}
+	}
+
+	for _, node := range nodes {
+		tc.reconcileUntilDone(node.Name)
+		if id := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; !seen[id] {
+			t.Errorf("expect node %s bound to an anycast ip allocated in batch, got %q", node.Name, id)
+		}
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != allocated {
+		t.Errorf("expect no more AllocateAddresses call, got %d", n-allocated)
+	}
+}
+
+func TestAllocateBatchReleasesAddressFailedToHandOut(t *testing.T) {
+	tc := newTestContext(t)
+	m := tc.r.AiaManger.(*MangerImp)
+	items := make([]interface{}, 0, 2)
+	for i := 0; i < 2; i++ {
+		node := tc.createNode(map[string]string{testAiaLabel: "true"})
+		items = append(items, &allocateItem{node: node, spec: tc.r.defaultAddressSpec(node)})
+	}
+	tc.cloud.InjectError(cloudfake.ActionAttachResourcesTag, cloudfake.NewError(cloudfake.ErrCodeInvalidParameterValue, "boom"))
+
+	results := m.allocateBatch(items)
+
+	if results[0].err == nil || results[1].err != nil {
+		t.Fatalf("expect the first node failed to be handed out its address only, got %+v", results)
+	}
+	err := wait.PollImmediate(10*time.Millisecond, 5*time.Second, func() (bool, error) {
+		tc.cloud.Settle()
+		return len(tc.cloud.ListAddresses()) == 1, nil
+	})
+	if err != nil {
+		t.Errorf("expect the address failed to be handed out released, got %d addresses", len(tc.cloud.ListAddresses()))
+	}
+	if _, ok := tc.cloud.GetAddress(results[1].id); !ok {
+		t.Errorf("expect anycast ip %s handed out kept", results[1].id)
+	}
+}
+
+func TestReleaseLeftoverBatchAddresses(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	addressTags := func(nodeName string, since time.Time) map[string]string {
+		tags := map[string]string{
+			constants.AiaIpControllerClusterUuidAnnoKey: tc.r.clusterUuid,
+			constants.AiaBatchAllocatedAnnoKey:          strconv.FormatInt(since.Unix(), 10),
+		}
+		if nodeName != "" {
+			tags[constants.AiaNodeNameAnnoKey] = nodeName
+		}
+		return tags
+	}
+	stale := time.Now().Add(-time.Hour)
+	leftover := tc.cloud.AddAddress(vpc.Address{}, addressTags("", stale))
+	handedOut := tc.cloud.AddAddress(vpc.Address{}, addressTags(node.Name, stale))
+	inFlight := tc.cloud.AddAddress(vpc.Address{}, addressTags("", time.Now()))
+	tc.cloud.Settle()
+
+	tc.r.ReleaseLeftoverBatchAddresses(context.TODO())
+
+	if _, ok := tc.cloud.GetAddress(leftover); ok {
+		t.Errorf("expect anycast ip %s never handed out released", leftover)
+	}
+	if _, ok := tc.cloud.GetAddress(handedOut); !ok {
+		t.Errorf("expect anycast ip %s of node %s kept", handedOut, node.Name)
+	}
+	if tags := tc.cloud.ResourceTags(handedOut); tags[constants.AiaBatchAllocatedAnnoKey] != "" {
+		t.Errorf("expect tag %s detached from anycast ip %s of node %s, got %v", constants.AiaBatchAllocatedAnnoKey,
+			handedOut, node.Name, tags)
+	}
+	if _, ok := tc.cloud.GetAddress(inFlight); !ok {
+		t.Errorf("expect anycast ip %s of a batch in flight kept", inFlight)
+	}
+}
+
+func TestReleaseAnycastIpInBatch(t *testing.T) {
+	tc := newTestContext(t)
+	m := tc.r.AiaManger.(*MangerImp)
+	release := func(ids []string) []error {
+		items := make([]interface{}, 0, len(ids))
+		for _, id := range ids {
+			items = append(items, id)
+		}
+		errs := make([]error, 0, len(ids))
+		for _, res := range m.releaseBatch(items) {
+			errs = append(errs, res.err)
+		}
+		return errs
+	}
+
+	ids := []string{tc.cloud.AddAddress(vpc.Address{}, nil), tc.cloud.AddAddress(vpc.Address{}, nil)}
+	for i, err := range release(ids) {
+		if err != nil {
+			t.Errorf("release anycast ip %s failed: %v", ids[i], err)
+		}
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionReleaseAddresses); n != 1 {
+		t.Errorf("expect 1 ReleaseAddresses call for %d addresses, got %d", len(ids), n)
+	}
+
+	// a failed batch is released one by one, so that the error goes to the address it belongs to
+	ids = []string{tc.cloud.AddAddress(vpc.Address{}, nil), "eip-notexist"}
+	errs := release(ids)
+	if errs[0] != nil {
+		t.Errorf("expect anycast ip %s released, got %v", ids[0], errs[0])
+	}
+	if errs[1] == nil {
+		t.Errorf("expect release of anycast ip %s failed", ids[1])
+	}
+	if _, ok := tc.cloud.GetAddress(ids[0]); ok {
+		t.Errorf("expect anycast ip %s not existed", ids[0])
+	}
+}
@@ -0,0 +1,233 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// getBinding returns the NodeAddressBinding of the node, or nil if it does not exist or the crd is not installed
+func (r *reconciler) getBinding(ctx context.Context, nodeName string) (*aiav1alpha1.NodeAddressBinding, error) {
+	if !r.EnableNodeAddressBinding {
+		return nil, nil
+	}
+	binding := &aiav1alpha1.NodeAddressBinding{}
+	err := r.k8sClient.Get(ctx, types.NamespacedName{Name: nodeName}, binding)
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		klog.Errorf("get NodeAddressBinding %s failed, err: %v", nodeName, err)
+		return nil, err
+	}
+	return binding, nil
+}
+
+// ensureBinding returns the NodeAddressBinding of the node, and creates it in Allocating phase if it does not exist.
+// Without the crd the binding is only kept in memory.
+func (r *reconciler) ensureBinding(ctx context.Context, node *corev1.Node) (*aiav1alpha1.NodeAddressBinding, error) {
+	binding, err := r.getBinding(ctx, node.Name)
+	if err != nil {
+		return nil, err
+	}
+	if binding != nil {
+		return binding, r.fillBindingInstance(ctx, node, binding)
+	}
+
+	binding = &aiav1alpha1.NodeAddressBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: node.Name},
+		Spec: aiav1alpha1.NodeAddressBindingSpec{
+			NodeName:   node.Name,
+			InstanceID: node.Labels[constants.TkeNodeInsIdAnnoKey],
+		},
+	}
+	if !r.EnableNodeAddressBinding ||
+		r.dryRun.skip(binding, "CreateNodeAddressBinding", "create binding of node %s", node.Name) {
+		binding.Status.Phase = aiav1alpha1.BindingPhaseAllocating
+		return binding, nil
+	}
+	if err := r.k8sClient.Create(ctx, binding); err != nil {
+		// the cache may not have seen the binding yet, let the next round get it
+		klog.Errorf("create NodeAddressBinding %s failed, err: %v", node.Name, err)
+		return nil, err
+	}
+	klog.Infof("created NodeAddressBinding %s", node.Name)
+	return binding, r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+		status.Phase = aiav1alpha1.BindingPhaseAllocating
+	})
+}
+
+// updateBindingStatus applies mutate to the status of binding and writes it if anything changed
+func (r *reconciler) updateBindingStatus(ctx context.Context, binding *aiav1alpha1.NodeAddressBinding,
+	mutate func(status *aiav1alpha1.NodeAddressBindingStatus)) error {
+	status := binding.Status.DeepCopy()
+	mutate(status)
+	if status.Phase != binding.Status.Phase {
+		now := metav1.Now()
+		status.LastTransitionTime = &now
+		klog.V(2).Infof("NodeAddressBinding %s phase changed from %q to %q", binding.Name, binding.Status.Phase, status.Phase)
+	}
+	if reflect.DeepEqual(*status, binding.Status) {
+		return nil
+	}
+
+	binding.Status = *status
+	if !r.EnableNodeAddressBinding {
+		return nil
+	}
+	if r.dryRun.skip(binding, "UpdateNodeAddressBinding", "update binding status to phase %s, address %s",
+		status.Phase, status.AddressID) {
+		return nil
+	}
+	if err := r.k8sClient.Status().Update(ctx, binding); err != nil {
+		klog.Errorf("update status of NodeAddressBinding %s failed, err: %v", binding.Name, err)
+		return err
+	}
+	return nil
+}
+
+// recordBindingError records err as the last error of binding, and returns err
+func (r *reconciler) recordBindingError(ctx context.Context, binding *aiav1alpha1.NodeAddressBinding, err error) error {
+	if isInProgress(err) {
+		return err
+	}
+	if uErr := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+		status.LastError = err.Error()
+	}); uErr != nil {
+		klog.Warningf("record error of NodeAddressBinding %s failed, err: %v", binding.Name, uErr)
+	}
+	return err
+}
+
+// syncBindingBound describes the address bound to the node and records it in binding, a migration waiting for
+// the address is completed
+func (r *reconciler) syncBindingBound(ctx context.Context, node *corev1.Node, binding *aiav1alpha1.NodeAddressBinding,
+	anycastId string) error {
+	if binding.Status.Phase == aiav1alpha1.BindingPhaseBound && binding.Status.AddressID == anycastId {
+		return nil
+	}
+	addr, err := r.AiaManger.DescribeAnycastIp(anycastId)
+	if err != nil {
+		return r.recordBindingError(ctx, binding, err)
+	}
+	if addr == nil {
+		return r.recordBindingError(ctx, binding, fmt.Errorf("anycast ip %s bound to node %s not found", anycastId, binding.Name))
+	}
+	migrated := false
+	if err := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+		status.Phase = aiav1alpha1.BindingPhaseBound
+		status.AddressID = anycastId
+		status.AddressIP = stringValue(addr.AddressIp)
+		status.AddressType = stringValue(addr.AddressType)
+		status.Bandwidth = 0
+		if addr.Bandwidth != nil {
+			status.Bandwidth = int64(*addr.Bandwidth)
+		}
+		status.Pool = ""
+		for _, aTag := range addr.TagSet {
+			if aTag != nil && aTag.Key != nil && *aTag.Key == constants.AiaPoolNameAnnoKey {
+				status.Pool = stringValue(aTag.Value)
+			}
+		}
+		status.LastError = ""
+		now := metav1.Now()
+		status.BoundTime = &now
+		if status.Migration != nil && status.Migration.Step == aiav1alpha1.MigrationStepAllocating {
+			status.Migration.Step = aiav1alpha1.MigrationStepCompleted
+			status.Migration.CompletionTime = &now
+			migrated = true
+		}
+	}); err != nil {
+		return err
+	}
+	if m := binding.Status.Migration; migrated {
+		r.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpMigrated, "Migrated %s %s to %s %s",
+			m.FromAddressType, m.FromAddressID, m.ToAddressType, anycastId)
+	}
+	return nil
+}
+
+// releaseBinding disassociates and releases the address recorded in the binding of a deleted node,
+// and deletes the binding once the address is gone. A binding without address is looked up by the node tag,
+// since the status may have failed to be written after the address was allocated.
+func (r *reconciler) releaseBinding(ctx context.Context, binding *aiav1alpha1.NodeAddressBinding) error {
+	if err := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+		status.Phase = aiav1alpha1.BindingPhaseReleasing
+	}); err != nil {
+		return err
+	}
+
+	anycastId := binding.Status.AddressID
+	if anycastId == "" {
+		found, taggedId, err := r.AiaManger.GetAnycastIpByTags(binding.Name)
+		if err != nil {
+			klog.Errorf("GetAnycastIpByTags of node %s failed, err: %v", binding.Name, err)
+			return r.recordBindingError(ctx, binding, err)
+		}
+		if found {
+			klog.Infof("found anycast ip %s of deleted node %s by tags, its binding has no address", taggedId, binding.Name)
+			anycastId = taggedId
+		}
+	}
+	if anycastId != "" {
+		addr, err := r.AiaManger.DescribeAnycastIp(anycastId)
+		if err != nil {
+			return r.recordBindingError(ctx, binding, err)
+		}
+		switch {
+		case addr == nil:
+			klog.Infof("anycast ip %s of deleted node %s not found, maybe has been released", anycastId, binding.Name)
+		case addr.InstanceId != nil && *addr.InstanceId != "" && *addr.InstanceId != binding.Spec.InstanceID:
+			klog.Warningf("anycast ip %s of deleted node %s is bound to another instance %s, not going to release it",
+				anycastId, binding.Name, *addr.InstanceId)
+		default:
+			if detached, err := r.detachStaticAddress(binding.Name, anycastId, vpcTagMap(addr.TagSet)); detached || err != nil {
+				if err != nil {
+					return r.recordBindingError(ctx, binding, err)
+				}
+				break
+			}
+			if retained, err := r.retainStickyAddress(binding.Name, addr); retained || err != nil {
+				if err != nil {
+					return r.recordBindingError(ctx, binding, err)
+				}
+				break
+			}
+			if err := r.AiaManger.DisassociateAnycastIp(anycastId); err != nil {
+				if !isInProgress(err) {
+					klog.Errorf("DisassociateAnycastIp %s failed, err: %v", anycastId, err)
+				}
+				return r.recordBindingError(ctx, binding, err)
+			}
+			if err := r.AiaManger.ReleaseAnycastIp(anycastId); err != nil {
+				klog.Errorf("ReleaseAnycastIp %s failed, err: %v", anycastId, err)
+				return r.recordBindingError(ctx, binding, err)
+			}
+		}
+	}
+
+	if r.dryRun.skip(binding, "DeleteNodeAddressBinding", "delete binding of node %s", binding.Name) {
+		return nil
+	}
+	if err := r.k8sClient.Delete(ctx, binding); err != nil && !errors.IsNotFound(err) {
+		klog.Errorf("delete NodeAddressBinding %s failed, err: %v", binding.Name, err)
+		return err
+	}
+	klog.Infof("deleted NodeAddressBinding %s", binding.Name)
+	return nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
@@ -0,0 +1,148 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileRecordsBoundBinding(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+
+	tc.reconcileUntilDone(node.Name)
+
+	binding := tc.getBinding(node.Name)
+	if binding == nil {
+		t.Fatalf("expect binding of node %s created", node.Name)
+	}
+	addr := tc.cloud.ListAddresses()[0]
+	if binding.Spec.InstanceID != node.Labels[constants.TkeNodeInsIdAnnoKey] {
+		t.Errorf("expect binding instance %s, got %s", node.Labels[constants.TkeNodeInsIdAnnoKey], binding.Spec.InstanceID)
+	}
+	status := binding.Status
+	if status.Phase != aiav1alpha1.BindingPhaseBound || status.AddressID != *addr.AddressId ||
+		status.AddressIP != *addr.AddressIp || status.AddressType != constants.EipTypeAnyCast ||
+		status.Bandwidth != testBandwidth {
+		t.Errorf("unexpected binding status %+v of address %s/%s", status, *addr.AddressId, *addr.AddressIp)
+	}
+	if status.LastError != "" || status.BoundTime == nil || status.LastTransitionTime == nil {
+		t.Errorf("expect bound binding without error and with timestamps, got %+v", status)
+	}
+}
+
+func TestReconcileRecordsFailedBinding(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	eipType := constants.EipTypeCommon
+	tc.cloud.AddAddress(vpc.Address{AddressType: &eipType, InstanceId: &insId}, nil)
+
+	tc.reconcileUntilDone(node.Name)
+
+	binding := tc.getBinding(node.Name)
+	if binding == nil || binding.Status.Phase != aiav1alpha1.BindingPhaseFailed || binding.Status.LastError == "" {
+		t.Errorf("expect failed binding with last error, got %+v", binding)
+	}
+}
+
+func TestReconcileReleasesAddressFromBinding(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	tagLookups := tc.cloud.Calls(cloudfake.ActionDescribeResourcesByTags)
+
+	tc.deleteNode(node.Name)
+	tc.reconcileUntilDone(node.Name)
+
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 0 {
+		t.Errorf("expect address released, got %d addresses left", len(addrs))
+	}
+	if tc.getBinding(node.Name) != nil {
+		t.Errorf("expect binding of node %s deleted", node.Name)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionDescribeResourcesByTags); n != tagLookups {
+		t.Errorf("expect no tag lookup when releasing from binding, got %d", n-tagLookups)
+	}
+}
+
+func TestReconcileWithoutBindingCrd(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.EnableNodeAddressBinding = false
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+
+	if id := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; id == "" {
+		t.Errorf("expect node %s bound to an anycast ip without the binding crd", node.Name)
+	}
+	if binding := tc.getBinding(node.Name); binding != nil {
+		t.Errorf("expect no binding created without the crd, got %+v", binding)
+	}
+
+	// the address of the deleted node is found by its tags
+	tc.deleteNode(node.Name)
+	tc.reconcileUntilDone(node.Name)
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 0 {
+		t.Errorf("expect anycast ip of deleted node released, got %d addresses", len(addrs))
+	}
+}
+
+// failingStatusClient fails the status updates of the bindings that record an address
+type failingStatusClient struct {
+	client.Client
+}
+
+func (c failingStatusClient) Status() client.StatusWriter {
+	return failingStatusWriter{StatusWriter: c.Client.Status()}
+}
+
+type failingStatusWriter struct {
+	client.StatusWriter
+}
+
+func (w failingStatusWriter) Update(ctx context.Context, obj client.Object, opts ...client.UpdateOption) error {
+	if binding, ok := obj.(*aiav1alpha1.NodeAddressBinding); ok && binding.Status.AddressID != "" {
+		return apierrors.NewConflict(aiav1alpha1.GroupVersion.WithResource("nodeaddressbindings").GroupResource(),
+			binding.Name, fmt.Errorf("the object has been modified"))
+	}
+	return w.StatusWriter.Update(ctx, obj, opts...)
+}
+
+func TestReconcileReleasesAddressOfBindingWithoutAddress(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.r.k8sClient = failingStatusClient{Client: tc.k8sClient}
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	// the first round fails since the tags are not created yet
+	for i := 0; i < 2 && len(tc.cloud.ListAddresses()) == 0; i++ {
+		if _, err := tc.r.Reconcile(context.TODO(), req); err == nil {
+			t.Fatalf("expect reconcile of node %s failed", node.Name)
+		}
+	}
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 1 {
+		t.Fatalf("expect 1 address allocated, got %d", len(addrs))
+	}
+	if binding := tc.getBinding(node.Name); binding == nil || binding.Status.AddressID != "" {
+		t.Fatalf("expect binding of node %s without address, got %+v", node.Name, binding)
+	}
+	tc.r.k8sClient = tc.k8sClient
+
+	tc.deleteNode(node.Name)
+	tc.reconcileUntilDone(node.Name)
+
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 0 {
+		t.Errorf("expect address found by tags released, got %d addresses left", len(addrs))
+	}
+	if tc.getBinding(node.Name) != nil {
+		t.Errorf("expect binding of node %s deleted", node.Name)
+	}
+}
@@ -0,0 +1,62 @@
+package aia
+
+import (
+	"context"
+	"reflect"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+)
+
+// Config returns the config in use, callers reading several fields should keep the returned pointer
+// rather than calling Config again, so that they see a consistent config during reload
+func (r *reconciler) Config() *config.YamlValueConfig {
+	return r.conf.Load().(*config.YamlValueConfig)
+}
+
+// ReloadConfig swaps in a new config, and requeues the nodes matching the old or new labels if the node labels
+// changed, so that nodes which lost the labels go through the label removal policy.
+// It returns an error if the config changes parts that need a restart, and the old config is kept.
+func (r *reconciler) ReloadConfig(conf *config.YamlValueConfig) error {
+	old := r.Config()
+	if err := conf.ValidateReload(old); err != nil {
+		return err
+	}
+	r.conf.Store(conf)
+	r.AiaManger.SetProcessingEipType(conf.Aia.AddressType)
+	klog.Infof("aia-ip-controller config reloaded")
+
+	if !reflect.DeepEqual(old.Node.Labels, conf.Node.Labels) {
+		klog.Infof("node labels changed from %v to %v, requeue nodes matching any of them", old.Node.Labels, conf.Node.Labels)
+		r.requeueAiaNodes(old, conf)
+	}
+	return nil
+}
+
+// requeueAiaNodes sends the nodes matching the labels of old or conf to ConfigEvents. Only the leader runs the
+// controller reading ConfigEvents, a new leader processes all nodes anyway.
+func (r *reconciler) requeueAiaNodes(old, conf *config.YamlValueConfig) {
+	if !r.isLeader {
+		return
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.k8sClient.List(context.TODO(), nodes); err != nil {
+		klog.Errorf("list nodes to requeue after config reloaded failed, err: %v", err)
+		return
+	}
+	events := make([]event.GenericEvent, 0)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if r.AiaManger.IsAiaNode(conf.Node.Labels, node) || r.AiaManger.IsAiaNode(old.Node.Labels, node) {
+			events = append(events, event.GenericEvent{Object: node})
+		}
+	}
+	klog.V(2).Infof("requeue %d nodes after config reloaded", len(events))
+	go func() {
+		for _, e := range events {
+			r.ConfigEvents <- e
+		}
+	}()
+}
@@ -0,0 +1,83 @@
+package aia
+
+import (
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/util/sets"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+)
+
+func TestReloadConfigSwapsLabelsAndAddressType(t *testing.T) {
+	tc := newTestContext(t)
+	conf := *tc.r.Config()
+	conf.Node = config.NodeConfig{Labels: map[string]string{"edge": "true"}}
+	conf.Aia.AddressType = "HighQualityEIP"
+	if err := tc.r.ReloadConfig(&conf); err != nil {
+		t.Fatalf("ReloadConfig failed: %v", err)
+	}
+
+	node := tc.createNode(map[string]string{"edge": "true"})
+	tc.reconcileUntilDone(node.Name)
+
+	addrs := tc.cloud.ListAddresses()
+	if len(addrs) != 1 {
+		t.Fatalf("expect 1 address allocated for node with the new labels, got %d", len(addrs))
+	}
+	if got := *addrs[0].AddressType; got != "HighQualityEIP" {
+		t.Errorf("expect address type HighQualityEIP, got %s", got)
+	}
+}
+
+func TestReloadConfigRejectsCredentialChange(t *testing.T) {
+	tc := newTestContext(t)
+	old := tc.r.Config()
+	conf := *old
+	conf.Credential.ClusterID = "cls-other"
+	if err := tc.r.ReloadConfig(&conf); err == nil {
+		t.Fatalf("expect credential change rejected")
+	}
+	if tc.r.Config() != old {
+		t.Errorf("expect old config kept after rejected reload")
+	}
+}
+
+func TestReloadConfigRejectsCloudAPIChange(t *testing.T) {
+	tc := newTestContext(t)
+	old := tc.r.Config()
+	conf := *old
+	conf.CloudAPI.RateLimits = map[string]config.RateLimitConfig{"AllocateAddresses": {QPS: 1, Burst: 1}}
+	if err := tc.r.ReloadConfig(&conf); err == nil {
+		t.Fatalf("expect cloud api rate limit change rejected")
+	}
+	if tc.r.Config() != old {
+		t.Errorf("expect old config kept after rejected reload")
+	}
+}
+
+func TestReloadConfigRequeuesNodesOfOldAndNewLabels(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.isLeader = true
+	oldNode := tc.createNode(map[string]string{testAiaLabel: "true"})
+	newNode := tc.createNode(map[string]string{"edge": "true"})
+	tc.createNode(map[string]string{"other": "true"})
+
+	conf := *tc.r.Config()
+	conf.Node = config.NodeConfig{Labels: map[string]string{"edge": "true"}}
+	if err := tc.r.ReloadConfig(&conf); err != nil {
+		t.Fatalf("ReloadConfig failed: %v", err)
+	}
+
+	requeued := sets.NewString()
+	for requeued.Len() < 2 {
+		select {
+		case e := <-tc.r.ConfigEvents:
+			requeued.Insert(e.Object.GetName())
+		case <-time.After(time.Second):
+			t.Fatalf("expect nodes requeued after labels changed, got %v", requeued.List())
+		}
+	}
+	if !requeued.HasAll(oldNode.Name, newNode.Name) {
+		t.Errorf("expect nodes %s and %s requeued, got %v", oldNode.Name, newNode.Name, requeued.List())
+	}
+}
@@ -2,24 +2,26 @@ package aia
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/go-logr/logr"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common/profile"
-	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
-	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
-	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
 	corev1 "k8s.io/api/core/v1"
-	"k8s.io/apimachinery/pkg/api/errors"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	clientset "k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/klog/v2"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
 	"sigs.k8s.io/controller-runtime/pkg/log"
 	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
 	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
 	"tkestack.io/aia-ip-controller/pkg/constants"
 )
 
@@ -29,39 +31,44 @@ type reconciler struct {
 	eventRecorder           record.EventRecorder
 	logger                  logr.Logger
 	maxConcurrentReconciles int
-	syncPeriod              time.Duration
-	clusterId               string
-	clusterUuid             string
-	Conf                    *config.YamlValueConfig
-	vpcClient               *vpc.Client
-	cvmClient               *cvm.Client
-	tagClient               *tag.Client
-	AiaManger               Manger
-	isLeader                bool
-	EnableReverseReconcile  bool
+	// syncPeriod is how often bound addresses are checked for drift, 0 means only when the node changes
+	syncPeriod  time.Duration
+	clusterId   string
+	clusterUuid string
+	// conf holds the *config.YamlValueConfig in use, it is swapped when values.yaml is reloaded
+	conf                   atomic.Value
+	vpcClient              cloud.VpcAPI
+	cvmClient              cloud.CvmAPI
+	tagClient              cloud.TagAPI
+	AiaManger              Manger
+	isLeader               bool
+	EnableReverseReconcile bool
+	EnableNodeFinalizer    bool
+	NodeFinalizerTimeout   time.Duration
+	// asyncPollInterval is how often an address is checked while a cloud operation on it is in progress
+	asyncPollInterval time.Duration
+	dryRun            *dryRun
+	// EnableAnycastIPPool is set if the AnycastIPPool crd is installed
+	EnableAnycastIPPool bool
+	// EnableNodeAddressBinding is set if the NodeAddressBinding crd is installed, otherwise bindings are kept
+	// in memory for one reconcile and addresses of deleted nodes are found by their tags
+	EnableNodeAddressBinding bool
+	// ConfigEvents receives the nodes to requeue after the node labels in config changed
+	ConfigEvents chan event.GenericEvent
+	// anycastPods holds the keys of the pods that asked for an anycast ip, so that their deletion is passed by
+	// PodPredicate even if the annotation was removed before
+	anycastPods sync.Map
 }
 
-func NewReconcile(k8sClient client.Client, eventRecorder record.EventRecorder, controllerConfig *config.ControllerConfig, logger logr.Logger) (*reconciler, error) {
+// NewReconcile creates the node reconciler, k8sClient is usually the cached client of the manager,
+// while kubeClient talks to apiserver directly.
+func NewReconcile(k8sClient client.Client, kubeClient clientset.Interface, eventRecorder record.EventRecorder,
+	controllerConfig *config.ControllerConfig, cloudClients *cloud.Clients, logger logr.Logger) (*reconciler, error) {
 
-	credential := common.NewCredential(controllerConfig.ConfigFileConf.Credential.SecretID, controllerConfig.ConfigFileConf.Credential.SecretKey)
-	vpcClient, cErr := vpc.NewClient(credential, controllerConfig.ConfigFileConf.Region.LongName, profile.NewClientProfile())
-	if cErr != nil {
-		return nil, cErr
-	}
-
-	cvmClient, cErr := cvm.NewClient(credential, controllerConfig.ConfigFileConf.Region.LongName, profile.NewClientProfile())
-	if cErr != nil {
-		return nil, cErr
-	}
-
-	tagClient, cErr := tag.NewClient(credential, controllerConfig.ConfigFileConf.Region.LongName, profile.NewClientProfile())
-	if cErr != nil {
-		return nil, cErr
-	}
-
-	aiaManager, aErr := NewAiaManager(k8sClient, cvmClient, vpcClient, tagClient, eventRecorder,
-		controllerConfig.ConfigFileConf.Credential.ClusterID, controllerConfig.ConfigFileConf.Aia.Bandwidth,
-		controllerConfig.ConfigFileConf.Aia.AnycastZone, controllerConfig.ConfigFileConf.Aia.AddressType)
+	aiaManager, aErr := NewAiaManager(k8sClient, kubeClient, cloudClients.Cvm, cloudClients.Vpc, cloudClients.Tag, eventRecorder,
+		controllerConfig.ConfigFileConf.Credential.ClusterID, controllerConfig.ConfigFileConf.Region.LongName,
+		controllerConfig.ConfigFileConf.Aia.AddressType, controllerConfig.DryRun, controllerConfig.AddressInventoryPeriod > 0,
+		controllerConfig.AddressBatchWindow)
 	if aErr != nil {
 		klog.Errorf("NewAiaManager failed, err: %v", aErr)
 		return nil, aErr
@@ -73,61 +80,76 @@ func NewReconcile(k8sClient client.Client, eventRecorder record.EventRecorder, c
 		return nil, gErr
 	}
 
-	return &reconciler{
-		k8sClient:               k8sClient,
-		eventRecorder:           eventRecorder,
-		logger:                  logger,
-		maxConcurrentReconciles: controllerConfig.MaxAiaIpControllerConcurrentReconciles,
-		clusterId:               controllerConfig.ConfigFileConf.Credential.ClusterID,
-		clusterUuid:             clsUuid,
-		Conf:                    controllerConfig.ConfigFileConf,
-		isLeader:                false,
-		vpcClient:               vpcClient,
-		cvmClient:               cvmClient,
-		tagClient:               tagClient,
-		AiaManger:               aiaManager,
-		EnableReverseReconcile:  controllerConfig.EnableReverseReconcile,
-	}, nil
+	r := &reconciler{
+		k8sClient:                k8sClient,
+		eventRecorder:            eventRecorder,
+		logger:                   logger,
+		maxConcurrentReconciles:  controllerConfig.MaxAiaIpControllerConcurrentReconciles,
+		syncPeriod:               controllerConfig.AddressSyncPeriod,
+		clusterId:                controllerConfig.ConfigFileConf.Credential.ClusterID,
+		clusterUuid:              clsUuid,
+		isLeader:                 false,
+		vpcClient:                cloudClients.Vpc,
+		cvmClient:                cloudClients.Cvm,
+		tagClient:                cloudClients.Tag,
+		AiaManger:                aiaManager,
+		EnableReverseReconcile:   controllerConfig.EnableReverseReconcile,
+		EnableNodeFinalizer:      controllerConfig.EnableNodeFinalizer,
+		NodeFinalizerTimeout:     controllerConfig.NodeFinalizerTimeout,
+		asyncPollInterval:        controllerConfig.AsyncPollInterval,
+		dryRun:                   &dryRun{enabled: controllerConfig.DryRun, eventRecorder: eventRecorder},
+		EnableAnycastIPPool:      controllerConfig.EnableAnycastIPPool,
+		EnableNodeAddressBinding: controllerConfig.EnableNodeAddressBinding,
+		ConfigEvents:             make(chan event.GenericEvent),
+	}
+	if r.asyncPollInterval <= 0 {
+		r.asyncPollInterval = defaultAsyncPollInterval
+	}
+	r.conf.Store(controllerConfig.ConfigFileConf)
+	return r, nil
 }
 
-func (r *reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Result, error) {
+func (r *reconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
 	// set up a convenient log object so that we don't have to type request over and over again
 	log := log.FromContext(ctx)
+	// a mutation skipped in dry run mode would fail the same way in the next round, do not retry
+	defer func() {
+		if errors.Is(err, errDryRun) {
+			log.V(2).Info("Reconcile stopped in dry run mode", "nodeName", req.Name)
+			err = nil
+		}
+		// a cloud operation in progress is polled rather than retried with backoff
+		if isInProgress(err) {
+			log.V(2).Info("Waiting for cloud operation", "nodeName", req.Name, "operation", err.Error())
+			res, err = ctrl.Result{RequeueAfter: r.asyncPollInterval}, nil
+		}
+		// a cloud error that would not go away soon is retried after a fixed interval of its class
+		if interval := retryInterval(err); interval > 0 {
+			log.Error(err, "Reconcile failed, will retry", "nodeName", req.Name, "after", interval)
+			res, err = ctrl.Result{RequeueAfter: interval}, nil
+		}
+	}()
 
 	r.isLeader = true // let reverse reconcile loop know this
 
 	// Fetch the node from the cache
 	node := &corev1.Node{}
-	err := r.k8sClient.Get(ctx, req.NamespacedName, node)
-	if errors.IsNotFound(err) {
+	err = r.k8sClient.Get(ctx, req.NamespacedName, node)
+	if apierrors.IsNotFound(err) {
 		log.Error(nil, fmt.Sprintf("Could not find node %s", req.Name))
-		// call tag api to check if any anycast ip or eip related with the deleted node
-		found, legacyAnycastId, err := r.AiaManger.GetAnycastIpByTags(req.Name)
-		if err != nil {
-			klog.Errorf("GetAnycastIpByTags of node %s failed, err: %v", req.Name, err)
-			return reconcile.Result{}, err
-		}
-		if !found {
-			klog.Infof("found node %s has no legacy anycast ip, just skip it", req.Name)
-			return reconcile.Result{}, nil
-		}
-		// if legacy anycast ip found, need to disassociate it
-		if err := r.AiaManger.DisassociateAnycastIp(legacyAnycastId); err != nil {
-			klog.Errorf("DisassociateAnycastIp %s failed, err: %v", legacyAnycastId, err)
-			return reconcile.Result{}, err
-		}
-		// release it if necessary
-		if err := r.AiaManger.ReleaseAnycastIp(legacyAnycastId); err != nil {
-			klog.Errorf("ReleaseAnycastIp %s failed, err: %v", legacyAnycastId, err)
-			return reconcile.Result{}, err
-		}
-
-		return reconcile.Result{}, nil
+		return reconcile.Result{}, r.releaseNodeAddress(ctx, req.Name)
 	}
 	if err != nil {
 		return reconcile.Result{}, fmt.Errorf("could not fetch node %s: %+v", req.Name, err)
 	}
 
+	if node.DeletionTimestamp != nil {
+		return r.finalizeNode(ctx, node)
+	}
+	if !r.AiaManger.IsAiaNode(r.Config().Node.Labels, node) {
+		return reconcile.Result{}, r.processLabelRemoval(ctx, node)
+	}
+
 	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
 	log.V(2).Info("Reconciling node...", "nodeIns", cvmInsId, "nodePhase", node.Status.Phase, "maxConcurrentReconciles", r.maxConcurrentReconciles)
 
@@ -137,28 +159,88 @@ func (r *reconciler) Reconcile(ctx context.Context, req ctrl.Request) (ctrl.Resu
 		// if the node terminating, do nothing, we will disassociate and release anycast ip after it has been removed from cluster
 		return reconcile.Result{}, fmt.Errorf("found node %s is %s, keep return err", node.Name, corev1.NodeTerminated)
 	default:
-		isAllocate, err := r.AiaManger.IsCvmNeedToAllocateAnyCastIp(node)
+		spec, err := r.addressSpecOfNode(ctx, node)
 		if err != nil {
-			klog.Errorf("check IsCvmNeedToAllocateAnyCastIp for node %s failed, err: %v", node.Name, err)
 			return reconcile.Result{}, err
 		}
+		binding, err := r.ensureBinding(ctx, node)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		applyBindingStatic(node, binding, spec)
+		if r.EnableNodeFinalizer {
+			if err := r.ensureNodeFinalizer(ctx, node); err != nil {
+				return reconcile.Result{}, err
+			}
+		}
+		// resume an interrupted migration, the new address is allocated once the old one is gone
+		if isMigrating(binding) {
+			if err := r.continueMigration(ctx, node, binding); err != nil {
+				return reconcile.Result{}, r.recordBindingError(ctx, binding, err)
+			}
+		}
+		isAllocate, err := r.AiaManger.IsCvmNeedToAllocateAnyCastIp(node, spec)
+		var conflict *addressTypeConflictError
+		if errors.As(err, &conflict) {
+			if err := r.startMigration(ctx, node, binding, conflict); err != nil {
+				return reconcile.Result{}, r.recordBindingError(ctx, binding, err)
+			}
+			return reconcile.Result{Requeue: true}, nil
+		}
+		if err != nil {
+			if !isInProgress(err) {
+				klog.Errorf("check IsCvmNeedToAllocateAnyCastIp for node %s failed, err: %v", node.Name, err)
+			}
+			return reconcile.Result{}, r.recordBindingError(ctx, binding, err)
+		}
 		// if no need to allocate and associate, just return
 		if !isAllocate {
 			klog.Infof("no need to allocate and associate anycast ip for node %s, just return nil", node.Name)
-			return reconcile.Result{}, nil
+			// the node is annotated once its anycast ip is found, otherwise it has another public address
+			if anycastId := node.Annotations[constants.AnycastIpIdAnnotationKey]; anycastId != "" {
+				if err := r.AiaManger.ConvergeAnycastIp(node, anycastId, spec); err != nil {
+					klog.Errorf("ConvergeAnycastIp %s for node %s failed, err: %v", anycastId, node.Name, err)
+					return reconcile.Result{}, r.recordBindingError(ctx, binding, err)
+				}
+				// check the address again later, it may be edited in the console
+				return reconcile.Result{RequeueAfter: r.syncPeriod}, r.syncBindingBound(ctx, node, binding, anycastId)
+			}
+			return reconcile.Result{}, r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+				status.Phase = aiav1alpha1.BindingPhaseFailed
+				status.LastError = fmt.Sprintf("node already has a public address that is not %s", spec.AddressType)
+			})
+		}
+		if err := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+			if status.Phase != aiav1alpha1.BindingPhaseAssociating {
+				status.Phase = aiav1alpha1.BindingPhaseAllocating
+			}
+		}); err != nil {
+			return reconcile.Result{}, err
 		}
-		anycastId, err := r.AiaManger.AllocateAnycastIp(node, r.Conf.Aia.Tags)
+		anycastId, err := r.AiaManger.AllocateAnycastIp(node, spec)
 		if err != nil {
 			klog.Errorf("AllocateAnycastIp for node %s failed, err: %v", node.Name, err)
+			return reconcile.Result{}, r.recordBindingError(ctx, binding, err)
+		}
+		if err := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+			status.Phase = aiav1alpha1.BindingPhaseAssociating
+			status.AddressID = anycastId
+			status.Pool = spec.Pool
+		}); err != nil {
 			return reconcile.Result{}, err
 		}
 		if err := r.AiaManger.AssociateAnycastIp(node, anycastId); err != nil {
-			klog.Errorf("AssociateAnycastIp %s for node %s failed, err: %v", anycastId, node.Name, err)
-			return reconcile.Result{}, err
+			if !isInProgress(err) {
+				klog.Errorf("AssociateAnycastIp %s for node %s failed, err: %v", anycastId, node.Name, err)
+			}
+			return reconcile.Result{}, r.recordBindingError(ctx, binding, err)
 		}
 		klog.Infof("associate anycast ip %s for node %s success", anycastId, node.Name)
+		if err := r.syncBindingBound(ctx, node, binding, anycastId); err != nil {
+			return reconcile.Result{}, err
+		}
 	}
 
 	log.V(2).Info("Reconcile node successfully", "nodeName", req.Name)
-	return reconcile.Result{}, nil
+	return reconcile.Result{RequeueAfter: r.syncPeriod}, nil
 }
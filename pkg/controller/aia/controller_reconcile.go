@@ -3,6 +3,7 @@ package aia
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"strings"
@@ -15,6 +16,7 @@ import (
 	"k8s.io/klog/v2"
 	"tkestack.io/aia-ip-controller/pkg/constants"
 	"tkestack.io/aia-ip-controller/pkg/controller/util"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
 )
 
 // ReverseReconcile should use leader election too
@@ -69,6 +71,10 @@ func (r *reconciler) ReverseReconcile() {
 	}
 
 	// 5. disassociate and release anycast ip
+	if r.dryRun.skip(nil, "ReleaseAddresses", "release legacy anycast ip (%s) in UNBIND status and (%s) in BIND status",
+		strings.Join(unbindLegacyAnycastId, ","), strings.Join(needDisassociateAnycastId, ",")) {
+		return
+	}
 	if len(unbindLegacyAnycastId) > 0 {
 		releaseAddrReq := vpc.NewReleaseAddressesRequest()
 		releaseAddrReq.AddressIds = common.StringPtrs(unbindLegacyAnycastId)
@@ -77,6 +83,7 @@ func (r *reconciler) ReverseReconcile() {
 			klog.Errorf("release legacy unbind anycast ip (%s) failed, err: %v", strings.Join(unbindLegacyAnycastId, ","), rErr)
 			return
 		}
+		metrics.ReverseReconcileReleasedTotal.Add(float64(len(unbindLegacyAnycastId)))
 	}
 
 	// wait for the anycast ip to be unbind
@@ -89,6 +96,7 @@ func (r *reconciler) ReverseReconcile() {
 			klog.Errorf("release legacy disassociated anycast ip (%s) failed, err: %v", strings.Join(needDisassociateAnycastId, ","), rErr2)
 			return
 		}
+		metrics.ReverseReconcileReleasedTotal.Add(float64(len(needDisassociateAnycastId)))
 	}
 	if len(unbindLegacyAnycastId) > 0 || len(needDisassociateAnycastId) > 0 {
 		klog.Infof("ReverseReconcile release %d legacy anycast ip, %d in BIND (%s) and %d in UNBIND (%s) status originally",
@@ -169,8 +177,9 @@ func (r *reconciler) getLegacyAnycastIds(existedNodeNames, existedAnycastIds []s
 		for i := 1; i < retryTimeLimit2 && (offset2+limit2) <= totalCount2; i++ {
 			descResourceByTagKeysReq := tag.NewDescribeResourceTagsByTagKeysRequest()
 			descResourceByTagKeysReq.ServiceType = common.StringPtr("vpc")
-			descResourceByTagKeysReq.ResourceRegion = common.StringPtr(r.Conf.Region.LongName)
-			descResourceByTagKeysReq.TagKeys = common.StringPtrs([]string{constants.AiaIpControllerClusterUuidAnnoKey, constants.AiaNodeNameAnnoKey})
+			descResourceByTagKeysReq.ResourceRegion = common.StringPtr(r.Config().Region.LongName)
+			descResourceByTagKeysReq.TagKeys = common.StringPtrs([]string{constants.AiaIpControllerClusterUuidAnnoKey, constants.AiaNodeNameAnnoKey,
+				constants.AiaNodeIdentityAnnoKey, constants.AiaRetainedSinceAnnoKey, constants.AiaStaticPolicyAnnoKey})
 			descResourceByTagKeysReq.ResourceIds = common.StringPtrs(curUsedAnycastId)
 			descResourceByTagKeysReq.ResourcePrefix = common.StringPtr("eip")
 			descResourceByTagKeysReq.Limit = common.Uint64Ptr(uint64(limit2))
@@ -189,16 +198,26 @@ func (r *reconciler) getLegacyAnycastIds(existedNodeNames, existedAnycastIds []s
 			klog.V(2).Infof("ReverseReconcile descResourceByTagKeysResp(round:%d): %s", i, string(descResourceByTagKeysRespB))
 
 			for _, row := range descResourceByTagKeysResp.Response.Rows {
-				nodeNameInTag := ""
+				rowTags := map[string]string{}
 				for _, aTag := range row.TagKeyValues {
-					if aTag != nil && aTag.TagKey != nil && *aTag.TagKey == constants.AiaNodeNameAnnoKey && aTag.TagValue != nil {
-						nodeNameInTag = *aTag.TagValue
+					if aTag != nil && aTag.TagKey != nil && aTag.TagValue != nil {
+						rowTags[*aTag.TagKey] = *aTag.TagValue
 					}
 				}
+				nodeNameInTag := rowTags[constants.AiaNodeNameAnnoKey]
 				if nodeNameInTag == "" {
 					continue
 				}
 				if !util.ContainString(existedNodeNames, nodeNameInTag) && row.ResourceId != nil { // found an anycast ip in tag but not in cluster
+					if detached, err := r.detachStaticAddress(nodeNameInTag, *row.ResourceId, rowTags); detached || err != nil {
+						if err != nil && !errors.Is(err, errDryRun) {
+							klog.Warningf("ReverseReconcile detach static anycast ip %s failed, err: %v", *row.ResourceId, err)
+						}
+						continue
+					}
+					if r.isRetainedStickyAddress(*row.ResourceId, rowTags) {
+						continue
+					}
 					legacyAnycastIds = append(legacyAnycastIds, *row.ResourceId)
 				}
 			}
@@ -249,6 +268,10 @@ func (r *reconciler) getUnBindAndNeedDisassociateLegacyAnycastIds(legacyAnycastI
 					// no batch api for disassociate, so we just call api here
 					disAssReq := vpc.NewDisassociateAddressRequest()
 					disAssReq.AddressId = common.StringPtr(*addr.AddressId)
+					if r.dryRun.skip(nil, "DisassociateAddress", "disassociate legacy anycast ip %s from %s",
+						*addr.AddressId, *addr.InstanceId) {
+						break
+					}
 					_, err := r.vpcClient.DisassociateAddress(disAssReq)
 					if err != nil {
 						klog.Warningf("ReverseReconcile disassociate address %s failed, err: %v", *addr.AddressId, err)
@@ -261,3 +284,22 @@ func (r *reconciler) getUnBindAndNeedDisassociateLegacyAnycastIds(legacyAnycastI
 
 	return unbindLegacyAnycastId, needDisassociateAnycastId, nil
 }
+
+// UpdateTaintedNodesMetric counts nodes that are tainted because they are waiting for anycast ip
+func (r *reconciler) UpdateTaintedNodesMetric(ctx context.Context) {
+	nodes := &corev1.NodeList{}
+	if err := r.k8sClient.List(ctx, nodes); err != nil {
+		klog.Warningf("UpdateTaintedNodesMetric list nodes of cluster failed, err: %v", err)
+		return
+	}
+	tainted := 0
+	for _, node := range nodes.Items {
+		for _, taint := range node.Spec.Taints {
+			if taint.Key == constants.NoAnycastIpTaintKey {
+				tainted++
+				break
+			}
+		}
+	}
+	metrics.TaintedNodes.Set(float64(tainted))
+}
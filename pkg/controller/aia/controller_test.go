@@ -0,0 +1,179 @@
+package aia
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileAllocatesAndAssociatesAnycastIp(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+
+	tc.reconcileUntilDone(node.Name)
+
+	addrs := tc.cloud.ListAddresses()
+	if len(addrs) != 1 {
+		t.Fatalf("expect 1 address, got %d", len(addrs))
+	}
+	addr := addrs[0]
+	if *addr.AddressType != constants.EipTypeAnyCast {
+		t.Errorf("expect address type %s, got %s", constants.EipTypeAnyCast, *addr.AddressType)
+	}
+	if addr.InstanceId == nil || *addr.InstanceId != insId {
+		t.Errorf("expect address bound to %s, got %v", insId, addr.InstanceId)
+	}
+	if *addr.Bandwidth != testBandwidth {
+		t.Errorf("expect bandwidth %d, got %d", testBandwidth, *addr.Bandwidth)
+	}
+	tags := tc.cloud.ResourceTags(*addr.AddressId)
+	for k, v := range map[string]string{
+		constants.AiaIpControllerClusterUuidAnnoKey: tc.r.clusterUuid,
+		constants.AiaIpControllerClusterIdAnnoKey:   testClusterId,
+		constants.AiaNodeNameAnnoKey:                node.Name,
+		constants.AiaNodeInsIdAnnoKey:               insId,
+		"team":                                      "edge",
+	} {
+		if tags[k] != v {
+			t.Errorf("expect tag %s=%s, got %s", k, v, tags[k])
+		}
+	}
+
+	// the controller may need several rounds to settle, make sure the last round untainted the node
+	tc.reconcileUntilDone(node.Name)
+	updated := tc.getNode(node.Name)
+	if hasNoAiaTaint(updated) {
+		t.Errorf("expect node %s untainted", node.Name)
+	}
+	if updated.Annotations[constants.AnycastIpIdAnnotationKey] != *addr.AddressId {
+		t.Errorf("expect annotation %s=%s, got %s", constants.AnycastIpIdAnnotationKey, *addr.AddressId,
+			updated.Annotations[constants.AnycastIpIdAnnotationKey])
+	}
+	if updated.Annotations[constants.AnycastIpIpAnnotationKey] != *addr.AddressIp {
+		t.Errorf("expect annotation %s=%s, got %s", constants.AnycastIpIpAnnotationKey, *addr.AddressIp,
+			updated.Annotations[constants.AnycastIpIpAnnotationKey])
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != 2 {
+		t.Errorf("expect AllocateAddresses called twice (tags created after the first call), got %d", n)
+	}
+}
+
+func TestReconcileTaintsNodeThatAlreadyHasEip(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	eipType := constants.EipTypeCommon
+	tc.cloud.AddAddress(vpc.Address{AddressType: &eipType, InstanceId: &insId}, nil)
+
+	tc.reconcileUntilDone(node.Name)
+
+	if !hasNoAiaTaint(tc.getNode(node.Name)) {
+		t.Errorf("expect node %s tainted with %s", node.Name, constants.NoAnycastIpTaintKey)
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeWarning, constants.FailedAllocateAnycastIp) {
+		t.Errorf("expect a %s warning event", constants.FailedAllocateAnycastIp)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != 0 {
+		t.Errorf("expect no AllocateAddresses call, got %d", n)
+	}
+}
+
+func TestReconcileRetriesFailedAllocation(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.cloud.InjectError(cloudfake.ActionAllocateAddresses,
+		cloudfake.NewError(cloudfake.ErrCodeAddressQuotaLimitExceeded, "quota exceeded"))
+
+	tc.reconcileUntilDone(node.Name)
+
+	if !hasEvent(tc.events(), corev1.EventTypeWarning, constants.FailedAllocateAnycastIp) {
+		t.Errorf("expect a %s warning event", constants.FailedAllocateAnycastIp)
+	}
+	if len(tc.cloud.ListAddresses()) != 1 {
+		t.Errorf("expect address allocated after retry")
+	}
+}
+
+func TestReconcileDelaysRetryOfQuotaExceeded(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.cloud.InjectError(cloudfake.ActionAllocateAddresses,
+		cloudfake.NewError(cloudfake.ErrCodeAddressQuotaLimitExceeded, "quota exceeded"))
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	for i := 0; i < reconcileLimit && tc.cloud.Calls(cloudfake.ActionAllocateAddresses) == 0; i++ {
+		res, err := tc.r.Reconcile(context.TODO(), req)
+		if err != nil {
+			t.Fatalf("expect quota exceeded retried after an interval rather than failed, got %v", err)
+		}
+		if tc.cloud.Calls(cloudfake.ActionAllocateAddresses) > 0 && res.RequeueAfter != permanentRetryInterval {
+			t.Errorf("expect requeue after %s, got %s", permanentRetryInterval, res.RequeueAfter)
+		}
+	}
+
+	found := false
+	for _, e := range tc.events() {
+		if strings.Contains(e, constants.FailedAllocateAnycastIp) && strings.Contains(e, cloudfake.ErrCodeAddressQuotaLimitExceeded) &&
+			strings.Contains(e, "RequestId") {
+			found = true
+		}
+	}
+	if !found {
+		t.Errorf("expect a %s event with the error code and request id", constants.FailedAllocateAnycastIp)
+	}
+}
+
+func TestReconcileReleasesAddressOfDeletedNode(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	if len(tc.cloud.ListAddresses()) != 1 {
+		t.Fatalf("expect 1 address allocated")
+	}
+
+	tc.deleteNode(node.Name)
+	tc.reconcileUntilDone(node.Name)
+
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 0 {
+		t.Errorf("expect address released, got %d addresses left", len(addrs))
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionDisassociateAddress); n != 1 {
+		t.Errorf("expect DisassociateAddress called once, got %d", n)
+	}
+}
+
+func TestReconcileDeletedNodeWithoutAddress(t *testing.T) {
+	tc := newTestContext(t)
+
+	if rounds := tc.reconcileUntilDone("not-existed-node"); rounds != 1 {
+		t.Errorf("expect deleted node without address done in 1 round, got %d", rounds)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionReleaseAddresses); n != 0 {
+		t.Errorf("expect no ReleaseAddresses call, got %d", n)
+	}
+}
+
+func TestProcessNodeUpdateEnqueuesLabeledNode(t *testing.T) {
+	tc := newTestContext(t)
+	oldNode := &corev1.Node{}
+	oldNode.Name = "node-1"
+	oldNode.Labels = map[string]string{constants.TkeNodeInsIdAnnoKey: "ins-1"}
+	newNode := oldNode.DeepCopy()
+	newNode.Labels[testAiaLabel] = "true"
+
+	if !tc.r.ProcessNodeUpdate(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode}) {
+		t.Errorf("expect node with aia label added to be enqueued")
+	}
+	if tc.r.ProcessNodeUpdate(event.UpdateEvent{ObjectOld: newNode, ObjectNew: newNode.DeepCopy()}) {
+		t.Errorf("expect node without label change not to be enqueued")
+	}
+}
@@ -14,5 +14,5 @@ func (r *reconciler) ProcessNodeCreate(createEvent event.CreateEvent) bool {
 	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
 	klog.V(2).Infof("watched node %s(%s) create event", node.Name, cvmInsId)
 	// 1. check if the node has all specified labels
-	return r.AiaManger.IsAiaNode(r.Conf.Node.Labels, node)
+	return r.AiaManger.IsAiaNode(r.Config().Node.Labels, node)
 }
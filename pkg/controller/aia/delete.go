@@ -1,6 +1,8 @@
 package aia
 
 import (
+	"context"
+
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/event"
@@ -13,5 +15,14 @@ func (r *reconciler) ProcessNodeDelete(deleteEvent event.DeleteEvent) bool {
 	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
 	klog.V(2).Infof("watched node %s(%s) delete event", node.Name, cvmInsId)
 	// 1. check if the node has all specified labels
-	return r.AiaManger.IsAiaNode(r.Conf.Node.Labels, node)
+	if r.AiaManger.IsAiaNode(r.Config().Node.Labels, node) {
+		return true
+	}
+	// 2. a node which lost the labels may still have an anycast ip kept in its binding
+	binding, err := r.getBinding(context.TODO(), node.Name)
+	if err != nil {
+		klog.Warningf("get binding of deleted node %s failed, enqueue it anyway, err: %v", node.Name, err)
+		return true
+	}
+	return binding != nil
 }
@@ -0,0 +1,177 @@
+package aia
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+const (
+	addressServiceType    = "vpc"
+	addressResourcePrefix = "eip"
+)
+
+// ConvergeAnycastIp modifies the bandwidth, name and tags of an address bound to the node if they drift from spec,
+// e.g. after the config changed or the address was edited in the console. Tags not in spec are left as is.
+// Only the tags of a static address are converged, its bandwidth and name are up to the user. Other addresses not
+// allocated by aia-ip-controller, e.g. bound by the user and not adopted, are left as is.
+func (m *MangerImp) ConvergeAnycastIp(node *corev1.Node, anycastIpId string, spec *AddressSpec) (err error) {
+	address, err := m.DescribeAnycastIp(anycastIpId)
+	if err != nil {
+		return err
+	}
+	if address == nil {
+		klog.Warningf("anycast ip %s of node %s not found, skip converging it", anycastIpId, node.Name)
+		return nil
+	}
+
+	static := spec.StaticAddressID == anycastIpId
+	current := vpcTagMap(address.TagSet)
+	if !static && current[constants.AiaIpControllerClusterUuidAnnoKey] != m.clusterUuid {
+		klog.V(2).Infof("anycast ip %s of node %s was not allocated by aia-ip-controller, skip converging it",
+			anycastIpId, node.Name)
+		return nil
+	}
+
+	changed := false
+	defer func(start time.Time) {
+		if changed || err != nil {
+			metrics.ObserveOperation("ConvergeAnycastIp", start, err)
+		}
+		if err != nil && !errors.Is(err, errDryRun) {
+			m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedModifyAnycastIp,
+				"Failed to modify anycast ip %s (will retry): %s", anycastIpId, clouderrors.Describe(err))
+		}
+	}(time.Now())
+
+	if !static && spec.Bandwidth > 0 && (address.Bandwidth == nil || int64(*address.Bandwidth) != spec.Bandwidth) {
+		changed = true
+		if err := m.modifyBandwidth(node, address, spec.Bandwidth); err != nil {
+			return err
+		}
+	}
+	if !static && spec.AddressName != "" && stringValue(address.AddressName) != spec.AddressName {
+		changed = true
+		if err := m.modifyName(node, address, spec.AddressName); err != nil {
+			return err
+		}
+	}
+
+	desired := m.addressTags(node, spec)
+	if static {
+		desired = m.staticTags(node, spec)
+	}
+	missing := map[string]string{}
+	for k, v := range desired {
+		if cur, ok := current[k]; !ok || cur != v {
+			missing[k] = v
+		}
+	}
+	if len(missing) > 0 {
+		changed = true
+		if err := m.attachTags(node, anycastIpId, missing); err != nil {
+			return err
+		}
+		m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpModified,
+			"Tags %v of anycast ip %s modified", missing, anycastIpId)
+	}
+	return nil
+}
+
+func (m *MangerImp) modifyBandwidth(node *corev1.Node, address *vpc.Address, bandwidth int64) error {
+	current := "unknown"
+	if address.Bandwidth != nil {
+		current = fmt.Sprintf("%d", *address.Bandwidth)
+	}
+	klog.Infof("bandwidth of anycast ip %s of node %s is %s Mbps, modify it to %d Mbps", *address.AddressId,
+		node.Name, current, bandwidth)
+	if m.dryRun.skip(node, "ModifyAddressesBandwidth", "modify bandwidth of anycast ip %s from %s to %d Mbps",
+		*address.AddressId, current, bandwidth) {
+		return errDryRun
+	}
+	req := vpc.NewModifyAddressesBandwidthRequest()
+	req.AddressIds = common.StringPtrs([]string{*address.AddressId})
+	req.InternetMaxBandwidthOut = common.Int64Ptr(bandwidth)
+	if _, err := m.vpcClient.ModifyAddressesBandwidth(req); err != nil {
+		klog.Errorf("modify bandwidth of anycast ip %s failed, err: %v", *address.AddressId, err)
+		return err
+	}
+	m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpModified,
+		"Bandwidth of anycast ip %s modified from %s to %d Mbps", *address.AddressId, current, bandwidth)
+	return nil
+}
+
+func (m *MangerImp) modifyName(node *corev1.Node, address *vpc.Address, name string) error {
+	klog.Infof("name of anycast ip %s of node %s is %q, modify it to %q", *address.AddressId, node.Name,
+		stringValue(address.AddressName), name)
+	if m.dryRun.skip(node, "ModifyAddressAttribute", "modify name of anycast ip %s to %s", *address.AddressId, name) {
+		return errDryRun
+	}
+	req := vpc.NewModifyAddressAttributeRequest()
+	req.AddressId = common.StringPtr(*address.AddressId)
+	req.AddressName = common.StringPtr(name)
+	if _, err := m.vpcClient.ModifyAddressAttribute(req); err != nil {
+		klog.Errorf("modify name of anycast ip %s failed, err: %v", *address.AddressId, err)
+		return err
+	}
+	m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpModified,
+		"Name of anycast ip %s modified to %s", *address.AddressId, name)
+	return nil
+}
+
+// attachTags binds the tags to the address, the tags are created first since tag api does not create them.
+// obj is the object the tags are attached for if any.
+func (m *MangerImp) attachTags(obj client.Object, anycastIpId string, tags map[string]string) error {
+	if err := m.createTags(obj, tags); err != nil {
+		return err
+	}
+	for k, v := range tags {
+		if m.dryRun.skip(obj, "AttachResourcesTag", "attach tag %s:%s to anycast ip %s", k, v, anycastIpId) {
+			return errDryRun
+		}
+		req := tag.NewAttachResourcesTagRequest()
+		req.ServiceType = common.StringPtr(addressServiceType)
+		req.ResourcePrefix = common.StringPtr(addressResourcePrefix)
+		req.ResourceRegion = common.StringPtr(m.region)
+		req.ResourceIds = common.StringPtrs([]string{anycastIpId})
+		req.TagKey = common.StringPtr(k)
+		req.TagValue = common.StringPtr(v)
+		if _, err := m.tagClient.AttachResourcesTag(req); err != nil {
+			klog.Errorf("attach tag %s:%s to anycast ip %s failed, err: %v", k, v, anycastIpId, err)
+			return err
+		}
+	}
+	klog.Infof("attach tags %v to anycast ip %s success", tags, anycastIpId)
+	return nil
+}
+
+// detachTags unbinds the tag keys from the address, obj is the object the address is detached for if any
+func (m *MangerImp) detachTags(obj client.Object, anycastIpId string, keys []string) error {
+	for _, k := range keys {
+		if m.dryRun.skip(obj, "DetachResourcesTag", "detach tag %s from anycast ip %s", k, anycastIpId) {
+			return errDryRun
+		}
+		req := tag.NewDetachResourcesTagRequest()
+		req.ServiceType = common.StringPtr(addressServiceType)
+		req.ResourcePrefix = common.StringPtr(addressResourcePrefix)
+		req.ResourceRegion = common.StringPtr(m.region)
+		req.ResourceIds = common.StringPtrs([]string{anycastIpId})
+		req.TagKey = common.StringPtr(k)
+		if _, err := m.tagClient.DetachResourcesTag(req); err != nil {
+			klog.Errorf("detach tag %s from anycast ip %s failed, err: %v", k, anycastIpId, err)
+			return err
+		}
+	}
+	klog.Infof("detach tags %v from anycast ip %s success", keys, anycastIpId)
+	return nil
+}
@@ -0,0 +1,76 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// annotateNode sets an annotation on the node
+func (tc *testContext) annotateNode(name, key, value string) {
+	node := tc.getNode(name)
+	if node.Annotations == nil {
+		node.Annotations = map[string]string{}
+	}
+	node.Annotations[key] = value
+	if err := tc.k8sClient.Update(context.TODO(), node); err != nil {
+		tc.t.Fatalf("annotate node %s failed: %v", name, err)
+	}
+}
+
+func TestReconcileConvergesEditedAddress(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	addressId := *tc.cloud.ListAddresses()[0].AddressId
+
+	// edited in the console
+	req := vpc.NewModifyAddressesBandwidthRequest()
+	req.AddressIds = common.StringPtrs([]string{addressId})
+	req.InternetMaxBandwidthOut = common.Int64Ptr(10)
+	if _, err := tc.cloud.ModifyAddressesBandwidth(req); err != nil {
+		t.Fatalf("ModifyAddressesBandwidth failed: %v", err)
+	}
+	tc.r.Config().Aia.Tags["owner"] = "sre"
+	tc.reconcileUntilDone(node.Name)
+
+	addr, _ := tc.cloud.GetAddress(addressId)
+	if *addr.Bandwidth != testBandwidth {
+		t.Errorf("expect bandwidth converged to %d, got %d", testBandwidth, *addr.Bandwidth)
+	}
+	if tags := tc.cloud.ResourceTags(addressId); tags["owner"] != "sre" {
+		t.Errorf("expect new config tag attached, got %v", tags)
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeNormal, constants.AnycastIpModified) {
+		t.Errorf("expect a %s event", constants.AnycastIpModified)
+	}
+	if got := tc.getBinding(node.Name).Status.Bandwidth; got != testBandwidth {
+		t.Errorf("expect binding bandwidth %d, got %d", testBandwidth, got)
+	}
+}
+
+func TestReconcileAppliesBandwidthAnnotation(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+
+	tc.annotateNode(node.Name, constants.AnycastIpBandwidthAnnotationKey, "500")
+	tc.reconcileUntilDone(node.Name)
+	if got := *tc.cloud.ListAddresses()[0].Bandwidth; got != 500 {
+		t.Errorf("expect bandwidth 500 from annotation, got %d", got)
+	}
+
+	tc.annotateNode(node.Name, constants.AnycastIpBandwidthAnnotationKey, "fast")
+	tc.events()
+	tc.reconcileUntilDone(node.Name)
+	if !hasEvent(tc.events(), corev1.EventTypeWarning, constants.InvalidNodeAnnotation) {
+		t.Errorf("expect a %s warning event", constants.InvalidNodeAnnotation)
+	}
+	if got := *tc.cloud.ListAddresses()[0].Bandwidth; got != testBandwidth {
+		t.Errorf("expect bandwidth back to config %d after invalid annotation, got %d", testBandwidth, got)
+	}
+}
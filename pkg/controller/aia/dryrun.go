@@ -0,0 +1,42 @@
+package aia
+
+import (
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+// errDryRun is returned by operations whose cloud mutation was skipped in dry run mode, the reconcile of
+// the node stops there without retrying, because the following steps depend on the skipped mutation
+var errDryRun = errors.New("skipped in dry run mode")
+
+// dryRun decides whether mutations are skipped, and records the skipped ones
+type dryRun struct {
+	enabled       bool
+	eventRecorder record.EventRecorder
+}
+
+// skip returns true in dry run mode, after logging the mutation that would be done and counting it in metrics.
+// An event is recorded on obj if it is a node.
+func (d *dryRun) skip(obj client.Object, action, format string, args ...interface{}) bool {
+	if d == nil || !d.enabled {
+		return false
+	}
+	detail := fmt.Sprintf(format, args...)
+	if obj != nil {
+		klog.InfoS("Dry run, would do", "action", action, "object", klog.KObj(obj), "detail", detail)
+	} else {
+		klog.InfoS("Dry run, would do", "action", action, "detail", detail)
+	}
+	metrics.DryRunMutationsTotal.WithLabelValues(action).Inc()
+	if node, ok := obj.(*corev1.Node); ok && d.eventRecorder != nil {
+		d.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.DryRun, "Would %s: %s", action, detail)
+	}
+	return true
+}
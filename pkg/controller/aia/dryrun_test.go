@@ -0,0 +1,35 @@
+package aia
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileInDryRunMode(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.dryRun.enabled = true
+	tc.r.AiaManger.(*MangerImp).dryRun.enabled = true
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+
+	if rounds := tc.reconcileUntilDone(node.Name); rounds != 1 {
+		t.Errorf("expect dry run reconcile done in 1 round, got %d", rounds)
+	}
+
+	for _, action := range []string{cloudfake.ActionAllocateAddresses, cloudfake.ActionCreateTag} {
+		if n := tc.cloud.Calls(action); n != 0 {
+			t.Errorf("expect no %s call in dry run mode, got %d", action, n)
+		}
+	}
+	if hasNoAiaTaint(tc.getNode(node.Name)) {
+		t.Errorf("expect node %s not tainted in dry run mode", node.Name)
+	}
+	if tc.getBinding(node.Name) != nil {
+		t.Errorf("expect no binding created in dry run mode")
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeNormal, constants.DryRun) {
+		t.Errorf("expect a %s event", constants.DryRun)
+	}
+}
@@ -0,0 +1,134 @@
+package aia
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// releaseNodeAddress disassociates and releases the anycast ip of a deleted or deleting node
+func (r *reconciler) releaseNodeAddress(ctx context.Context, nodeName string) error {
+	// the binding records the address of the node, release it
+	binding, err := r.getBinding(ctx, nodeName)
+	if err != nil {
+		return err
+	}
+	if binding != nil {
+		return r.releaseBinding(ctx, binding)
+	}
+
+	// call tag api to check if any anycast ip or eip related with the node,
+	// in case it was allocated before bindings were introduced
+	found, legacyAnycastId, err := r.AiaManger.GetAnycastIpByTags(nodeName)
+	if err != nil {
+		klog.Errorf("GetAnycastIpByTags of node %s failed, err: %v", nodeName, err)
+		return err
+	}
+	if !found {
+		klog.Infof("found node %s has no legacy anycast ip, just skip it", nodeName)
+		return nil
+	}
+	addr, err := r.AiaManger.DescribeAnycastIp(legacyAnycastId)
+	if err != nil {
+		return err
+	}
+	if addr != nil {
+		if detached, err := r.detachStaticAddress(nodeName, legacyAnycastId, vpcTagMap(addr.TagSet)); detached || err != nil {
+			return err
+		}
+		if retained, err := r.retainStickyAddress(nodeName, addr); retained || err != nil {
+			return err
+		}
+	}
+	// if legacy anycast ip found, need to disassociate it
+	if err := r.AiaManger.DisassociateAnycastIp(legacyAnycastId); err != nil {
+		if !isInProgress(err) {
+			klog.Errorf("DisassociateAnycastIp %s failed, err: %v", legacyAnycastId, err)
+		}
+		return err
+	}
+	// release it if necessary
+	if err := r.AiaManger.ReleaseAnycastIp(legacyAnycastId); err != nil {
+		klog.Errorf("ReleaseAnycastIp %s failed, err: %v", legacyAnycastId, err)
+		return err
+	}
+	return nil
+}
+
+// finalizeNode releases the anycast ip of a deleting node and removes the finalizer afterwards. The finalizer is
+// removed without waiting if the node has the force remove annotation or has been deleting for too long.
+func (r *reconciler) finalizeNode(ctx context.Context, node *corev1.Node) (ctrl.Result, error) {
+	if !controllerutil.ContainsFinalizer(node, constants.NodeFinalizer) {
+		klog.V(2).Infof("node %s is deleting without finalizer, release anycast ip after it is removed", node.Name)
+		return ctrl.Result{}, nil
+	}
+
+	deleting := time.Since(node.DeletionTimestamp.Time)
+	if node.Annotations[constants.ForceRemoveFinalizerAnnotationKey] == "true" {
+		klog.Warningf("node %s has annotation %s, remove finalizer without releasing anycast ip",
+			node.Name, constants.ForceRemoveFinalizerAnnotationKey)
+		r.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.ForceRemovedFinalizer,
+			"Finalizer %s removed by annotation %s, anycast ip may not be released", constants.NodeFinalizer,
+			constants.ForceRemoveFinalizerAnnotationKey)
+		return ctrl.Result{}, r.removeNodeFinalizer(ctx, node)
+	}
+	if r.NodeFinalizerTimeout > 0 && deleting > r.NodeFinalizerTimeout {
+		klog.Warningf("node %s has been deleting for %s, remove finalizer without waiting for anycast ip released",
+			node.Name, deleting)
+		r.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.ForceRemovedFinalizer,
+			"Anycast ip not released in %s, finalizer %s removed, release will be retried after the node is removed",
+			r.NodeFinalizerTimeout, constants.NodeFinalizer)
+		return ctrl.Result{}, r.removeNodeFinalizer(ctx, node)
+	}
+
+	if err := r.releaseNodeAddress(ctx, node.Name); err != nil {
+		if errors.Is(err, errDryRun) {
+			return ctrl.Result{}, err
+		}
+		r.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedReleaseAnycastIp,
+			"Failed to release anycast ip (will retry): %s", clouderrors.Describe(err))
+		return ctrl.Result{}, err
+	}
+	klog.Infof("anycast ip of deleting node %s released, remove finalizer", node.Name)
+	return ctrl.Result{}, r.removeNodeFinalizer(ctx, node)
+}
+
+// ensureNodeFinalizer adds the finalizer to the node if it does not have one
+func (r *reconciler) ensureNodeFinalizer(ctx context.Context, node *corev1.Node) error {
+	if controllerutil.ContainsFinalizer(node, constants.NodeFinalizer) {
+		return nil
+	}
+	if r.dryRun.skip(node, "PatchNode", "add finalizer %s", constants.NodeFinalizer) {
+		return nil
+	}
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	controllerutil.AddFinalizer(node, constants.NodeFinalizer)
+	if err := r.k8sClient.Patch(ctx, node, patch); err != nil {
+		klog.Errorf("add finalizer to node %s failed, err: %v", node.Name, err)
+		return err
+	}
+	klog.V(2).Infof("add finalizer to node %s success", node.Name)
+	return nil
+}
+
+func (r *reconciler) removeNodeFinalizer(ctx context.Context, node *corev1.Node) error {
+	if r.dryRun.skip(node, "PatchNode", "remove finalizer %s", constants.NodeFinalizer) {
+		return nil
+	}
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	controllerutil.RemoveFinalizer(node, constants.NodeFinalizer)
+	if err := r.k8sClient.Patch(ctx, node, patch); err != nil {
+		klog.Errorf("remove finalizer of node %s failed, err: %v", node.Name, err)
+		return err
+	}
+	klog.V(2).Infof("remove finalizer of node %s success", node.Name)
+	return nil
+}
@@ -0,0 +1,69 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileReleasesAddressBeforeRemovingFinalizer(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.EnableNodeFinalizer = true
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	if !controllerutil.ContainsFinalizer(tc.getNode(node.Name), constants.NodeFinalizer) {
+		t.Fatalf("expect finalizer %s added to node %s", constants.NodeFinalizer, node.Name)
+	}
+
+	tc.deleteNode(node.Name)
+	deleting := &corev1.Node{}
+	if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: node.Name}, deleting); err != nil || deleting.DeletionTimestamp == nil {
+		t.Fatalf("expect node %s deleting, got err %v", node.Name, err)
+	}
+	tc.reconcileUntilDone(node.Name)
+
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 0 {
+		t.Errorf("expect address released before the finalizer removed, got %d addresses left", len(addrs))
+	}
+	if tc.getBinding(node.Name) != nil {
+		t.Errorf("expect binding of node %s deleted", node.Name)
+	}
+	if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: node.Name}, deleting); err == nil &&
+		controllerutil.ContainsFinalizer(deleting, constants.NodeFinalizer) {
+		t.Errorf("expect finalizer of node %s removed", node.Name)
+	}
+}
+
+func TestReconcileForceRemovesFinalizer(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.EnableNodeFinalizer = true
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	tc.deleteNode(node.Name)
+
+	deleting := tc.getNode(node.Name)
+	deleting.Annotations[constants.ForceRemoveFinalizerAnnotationKey] = "true"
+	if err := tc.k8sClient.Update(context.TODO(), deleting); err != nil {
+		t.Fatalf("annotate node %s failed: %v", node.Name, err)
+	}
+	if !tc.r.ProcessNodeUpdate(event.UpdateEvent{ObjectOld: deleting, ObjectNew: deleting}) {
+		t.Errorf("expect deleting node with finalizer to be enqueued")
+	}
+	tc.reconcileUntilDone(node.Name)
+
+	if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: node.Name}, deleting); err == nil &&
+		controllerutil.ContainsFinalizer(deleting, constants.NodeFinalizer) {
+		t.Errorf("expect finalizer of node %s removed", node.Name)
+	}
+	if len(tc.cloud.ListAddresses()) != 1 {
+		t.Errorf("expect address kept when the finalizer is force removed")
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeWarning, constants.ForceRemovedFinalizer) {
+		t.Errorf("expect a %s warning event", constants.ForceRemovedFinalizer)
+	}
+}
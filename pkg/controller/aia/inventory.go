@@ -0,0 +1,412 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"k8s.io/apimachinery/pkg/util/sets"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+// indexes of the address inventory, the values of the index label of lookup metrics
+const (
+	inventoryIndexId       = "id"
+	inventoryIndexInstance = "instance"
+	inventoryIndexTags     = "tags"
+)
+
+// inventoryAddressTypes are the address types kept in the inventory, DescribeAddresses only returns EIP without
+// an address-type filter
+var inventoryAddressTypes = []string{constants.EipTypeCommon, constants.EipTypeWanIp, constants.EipTypeAnyCast,
+	constants.EipTypeHighQualityEIP}
+
+// addressInventory is an in-memory index of the addresses in the region by address id, instance id and tags, so that
+// reconciling a node does not describe its addresses and tags every time. It is refreshed as a whole periodically,
+// and an address mutated through the clients returned by wrap is described again on its next lookup. A lookup that
+// misses, or finds an address that is stale or changing its status, goes to the cloud api. A fresh inventory
+// answers that there is no address itself, unless an address was mutated since it was refreshed.
+type addressInventory struct {
+	vpcClient cloud.VpcAPI
+	enabled   bool
+
+	lock       sync.RWMutex
+	synced     bool
+	addresses  map[string]*vpc.Address
+	byInstance map[string]sets.String
+	byTag      map[string]sets.String
+	// stale holds the time each address was last mutated, it is dropped once the address is described afterwards
+	stale map[string]time.Time
+	// refreshed is when the last refresh started, the inventory is not served once it is not refreshed for two
+	// periods
+	refreshed time.Time
+	period    time.Duration
+}
+
+func newAddressInventory(vpcClient cloud.VpcAPI, enabled bool) *addressInventory {
+	return &addressInventory{
+		vpcClient:  vpcClient,
+		enabled:    enabled,
+		addresses:  map[string]*vpc.Address{},
+		byInstance: map[string]sets.String{},
+		byTag:      map[string]sets.String{},
+		stale:      map[string]time.Time{},
+	}
+}
+
+func tagIndexKey(key, value string) string {
+	return key + "=" + value
+}
+
+// refresh describes all addresses in the region and replaces the inventory with them
+func (i *addressInventory) refresh() error {
+	if !i.enabled {
+		return nil
+	}
+	start := time.Now()
+	addrs := make([]*vpc.Address, 0)
+	for offset := int64(0); ; offset += describeAddressesLimit {
+		req := vpc.NewDescribeAddressesRequest()
+		req.Filters = []*vpc.Filter{
+			{
+				Name:   common.StringPtr("address-type"),
+				Values: common.StringPtrs(inventoryAddressTypes),
+			},
+		}
+		req.Offset = common.Int64Ptr(offset)
+		req.Limit = common.Int64Ptr(describeAddressesLimit)
+		resp, err := i.vpcClient.DescribeAddresses(req)
+		if err != nil {
+			return err
+		}
+		if resp == nil || resp.Response == nil || resp.Response.TotalCount == nil {
+			return fmt.Errorf("DescribeAddresses of address inventory has no response")
+		}
+		addrs = append(addrs, resp.Response.AddressSet...)
+		if len(resp.Response.AddressSet) == 0 || offset+describeAddressesLimit >= *resp.Response.TotalCount {
+			break
+		}
+	}
+
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	// addresses mutated while describing keep the data they had before the refresh until they are described again
+	old := i.addresses
+	i.addresses = map[string]*vpc.Address{}
+	i.byInstance = map[string]sets.String{}
+	i.byTag = map[string]sets.String{}
+	for _, addr := range addrs {
+		i.putLocked(addr)
+	}
+	for id, mutated := range i.stale {
+		if !mutated.Before(start) {
+			if addr, ok := old[id]; ok {
+				i.putLocked(addr)
+			}
+			continue
+		}
+		delete(i.stale, id)
+	}
+	i.synced = true
+	i.refreshed = start
+	metrics.AddressInventoryAddresses.Set(float64(len(i.addresses)))
+	klog.V(2).Infof("address inventory refreshed with %d addresses in %s", len(i.addresses),
+		time.Since(start).Round(time.Millisecond))
+	return nil
+}
+
+// run refreshes the inventory every period until ctx is done
+func (i *addressInventory) run(ctx context.Context, period time.Duration) {
+	if !i.enabled {
+		return
+	}
+	i.lock.Lock()
+	i.period = period
+	i.lock.Unlock()
+	wait.UntilWithContext(ctx, func(ctx context.Context) {
+		if err := i.refresh(); err != nil {
+			klog.Errorf("refresh address inventory failed, err: %v", err)
+		}
+	}, period)
+}
+
+// putLocked adds addr to the inventory and its indexes, replacing the previous one of its id
+func (i *addressInventory) putLocked(addr *vpc.Address) {
+	if addr == nil || addr.AddressId == nil {
+		return
+	}
+	i.removeLocked(*addr.AddressId)
+	id := *addr.AddressId
+	i.addresses[id] = addr
+	if insId := stringValue(addr.InstanceId); insId != "" {
+		if i.byInstance[insId] == nil {
+			i.byInstance[insId] = sets.NewString()
+		}
+		i.byInstance[insId].Insert(id)
+	}
+	for k, v := range vpcTagMap(addr.TagSet) {
+		key := tagIndexKey(k, v)
+		if i.byTag[key] == nil {
+			i.byTag[key] = sets.NewString()
+		}
+		i.byTag[key].Insert(id)
+	}
+}
+
+// removeLocked removes the address of id from the inventory and its indexes
+func (i *addressInventory) removeLocked(id string) {
+	addr, ok := i.addresses[id]
+	if !ok {
+		return
+	}
+	delete(i.addresses, id)
+	if insId := stringValue(addr.InstanceId); insId != "" {
+		i.byInstance[insId].Delete(id)
+		if i.byInstance[insId].Len() == 0 {
+			delete(i.byInstance, insId)
+		}
+	}
+	for k, v := range vpcTagMap(addr.TagSet) {
+		key := tagIndexKey(k, v)
+		i.byTag[key].Delete(id)
+		if i.byTag[key].Len() == 0 {
+			delete(i.byTag, key)
+		}
+	}
+}
+
+// update records addresses described at since, ids of addresses that were not found are removed
+func (i *addressInventory) update(since time.Time, addrs []*vpc.Address, notFound ...string) {
+	if !i.enabled {
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	for _, addr := range addrs {
+		if addr == nil || addr.AddressId == nil {
+			continue
+		}
+		i.putLocked(addr)
+		i.freshenLocked(*addr.AddressId, since)
+	}
+	for _, id := range notFound {
+		i.removeLocked(id)
+		i.freshenLocked(id, since)
+	}
+	metrics.AddressInventoryAddresses.Set(float64(len(i.addresses)))
+}
+
+// freshenLocked drops the stale mark of id if it was mutated before since
+func (i *addressInventory) freshenLocked(id string, since time.Time) {
+	if mutated, ok := i.stale[id]; ok && mutated.Before(since) {
+		delete(i.stale, id)
+	}
+}
+
+// invalidate marks the addresses as mutated, they are described again on their next lookup
+func (i *addressInventory) invalidate(ids ...string) {
+	if !i.enabled || len(ids) == 0 {
+		return
+	}
+	i.lock.Lock()
+	defer i.lock.Unlock()
+	now := time.Now()
+	for _, id := range ids {
+		i.stale[id] = now
+	}
+}
+
+// freshLocked returns whether the inventory can be served, it is not if it was never refreshed or the refreshes
+// keep failing
+func (i *addressInventory) freshLocked() bool {
+	return i.synced && (i.period <= 0 || time.Since(i.refreshed) < 2*i.period)
+}
+
+// cachedLocked returns the address of id if it can be served from the inventory
+func (i *addressInventory) cachedLocked(id string) (*vpc.Address, bool) {
+	if _, ok := i.stale[id]; ok {
+		return nil, false
+	}
+	addr, ok := i.addresses[id]
+	if !ok || isTransitionalStatus(stringValue(addr.AddressStatus)) {
+		return nil, false
+	}
+	return addr, true
+}
+
+// lookup returns the addresses of ids in the index, ok is false if the inventory is not fresh, or any of them can
+// not be served from it. No ids is only answered if no address was mutated since the refresh, since a mutated
+// address may have been allocated, bound or tagged.
+func (i *addressInventory) lookup(index string, ids sets.String) (res []*vpc.Address, ok bool) {
+	defer func() { observeInventoryLookup(index, ok) }()
+	if !i.enabled {
+		return nil, false
+	}
+	i.lock.RLock()
+	defer i.lock.RUnlock()
+	if !i.freshLocked() || (ids.Len() == 0 && len(i.stale) > 0) {
+		return nil, false
+	}
+	for _, id := range ids.List() {
+		addr, cached := i.cachedLocked(id)
+		if !cached {
+			return nil, false
+		}
+		res = append(res, addr)
+	}
+	return res, true
+}
+
+func observeInventoryLookup(index string, hit bool) {
+	result := "miss"
+	if hit {
+		result = "hit"
+	}
+	metrics.AddressInventoryLookupsTotal.WithLabelValues(index, result).Inc()
+}
+
+// get returns the address of id, or nil if it does not exist, from the inventory or by describing it
+func (i *addressInventory) get(id string) (*vpc.Address, error) {
+	if i.enabled {
+		i.lock.RLock()
+		addr, cached := i.cachedLocked(id)
+		synced := i.freshLocked()
+		i.lock.RUnlock()
+		if synced {
+			observeInventoryLookup(inventoryIndexId, cached)
+		}
+		if cached && synced {
+			return addr, nil
+		}
+	}
+
+	start := time.Now()
+	req := vpc.NewDescribeAddressesRequest()
+	req.AddressIds = common.StringPtrs([]string{id})
+	resp, err := i.vpcClient.DescribeAddresses(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || resp.Response == nil {
+		return nil, fmt.Errorf("DescribeAddresses of %s has no response", id)
+	}
+	for _, addr := range resp.Response.AddressSet {
+		if addr != nil && addr.AddressId != nil && *addr.AddressId == id {
+			i.update(start, []*vpc.Address{addr})
+			return addr, nil
+		}
+	}
+	i.update(start, nil, id)
+	return nil, nil
+}
+
+// getByInstance returns the addresses bound to the instance in the inventory, which is empty if the instance has
+// none, ok is false if they have to be described
+func (i *addressInventory) getByInstance(insId string) ([]*vpc.Address, bool) {
+	i.lock.RLock()
+	ids := sets.NewString(i.byInstance[insId].UnsortedList()...)
+	i.lock.RUnlock()
+	return i.lookup(inventoryIndexInstance, ids)
+}
+
+// getByTags returns the addresses having all the tags in the inventory, which is empty if no address has them, ok
+// is false if they have to be looked up through the tag api
+func (i *addressInventory) getByTags(tags map[string]string) ([]*vpc.Address, bool) {
+	i.lock.RLock()
+	var ids sets.String
+	for k, v := range tags {
+		matched := i.byTag[tagIndexKey(k, v)]
+		if ids == nil {
+			ids = sets.NewString(matched.UnsortedList()...)
+		} else {
+			ids = ids.Intersection(matched)
+		}
+	}
+	i.lock.RUnlock()
+	if ids == nil {
+		ids = sets.NewString()
+	}
+	return i.lookup(inventoryIndexTags, ids)
+}
+
+// wrap returns clients whose address mutations invalidate the mutated addresses in the inventory
+func (i *addressInventory) wrap(clients *cloud.Clients) *cloud.Clients {
+	return &cloud.Clients{
+		Vpc: &inventoryVpc{VpcAPI: clients.Vpc, inventory: i},
+		Tag: &inventoryTag{TagAPI: clients.Tag, inventory: i},
+		Cvm: clients.Cvm,
+	}
+}
+
+// inventoryVpc invalidates the addresses mutated by vpc api calls, describe calls are passed through
+type inventoryVpc struct {
+	cloud.VpcAPI
+	inventory *addressInventory
+}
+
+func (v *inventoryVpc) AllocateAddresses(request *vpc.AllocateAddressesRequest) (*vpc.AllocateAddressesResponse, error) {
+	resp, err := v.VpcAPI.AllocateAddresses(request)
+	if err == nil && resp != nil && resp.Response != nil {
+		v.inventory.invalidate(stringValues(resp.Response.AddressSet)...)
+	}
+	return resp, err
+}
+
+func (v *inventoryVpc) AssociateAddress(request *vpc.AssociateAddressRequest) (*vpc.AssociateAddressResponse, error) {
+	defer v.inventory.invalidate(stringValue(request.AddressId))
+	return v.VpcAPI.AssociateAddress(request)
+}
+
+func (v *inventoryVpc) DisassociateAddress(request *vpc.DisassociateAddressRequest) (*vpc.DisassociateAddressResponse, error) {
+	defer v.inventory.invalidate(stringValue(request.AddressId))
+	return v.VpcAPI.DisassociateAddress(request)
+}
+
+func (v *inventoryVpc) ReleaseAddresses(request *vpc.ReleaseAddressesRequest) (*vpc.ReleaseAddressesResponse, error) {
+	defer v.inventory.invalidate(stringValues(request.AddressIds)...)
+	return v.VpcAPI.ReleaseAddresses(request)
+}
+
+func (v *inventoryVpc) ModifyAddressesBandwidth(request *vpc.ModifyAddressesBandwidthRequest) (*vpc.ModifyAddressesBandwidthResponse, error) {
+	defer v.inventory.invalidate(stringValues(request.AddressIds)...)
+	return v.VpcAPI.ModifyAddressesBandwidth(request)
+}
+
+func (v *inventoryVpc) ModifyAddressAttribute(request *vpc.ModifyAddressAttributeRequest) (*vpc.ModifyAddressAttributeResponse, error) {
+	defer v.inventory.invalidate(stringValue(request.AddressId))
+	return v.VpcAPI.ModifyAddressAttribute(request)
+}
+
+// inventoryTag invalidates the addresses whose tags are changed by tag api calls
+type inventoryTag struct {
+	cloud.TagAPI
+	inventory *addressInventory
+}
+
+func (t *inventoryTag) AttachResourcesTag(request *tag.AttachResourcesTagRequest) (*tag.AttachResourcesTagResponse, error) {
+	defer t.inventory.invalidate(stringValues(request.ResourceIds)...)
+	return t.TagAPI.AttachResourcesTag(request)
+}
+
+func (t *inventoryTag) DetachResourcesTag(request *tag.DetachResourcesTagRequest) (*tag.DetachResourcesTagResponse, error) {
+	defer t.inventory.invalidate(stringValues(request.ResourceIds)...)
+	return t.TagAPI.DetachResourcesTag(request)
+}
+
+func stringValues(ss []*string) []string {
+	res := make([]string, 0, len(ss))
+	for _, s := range ss {
+		if s != nil {
+			res = append(res, *s)
+		}
+	}
+	return res
+}
@@ -0,0 +1,110 @@
+package aia
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// enableInventory turns on the address inventory of the manager, the clients of the manager already invalidate it
+func (tc *testContext) enableInventory() *MangerImp {
+	m := tc.r.AiaManger.(*MangerImp)
+	m.inventory.enabled = true
+	return m
+}
+
+func TestReconcileBoundNodeFromInventory(t *testing.T) {
+	tc := newTestContext(t)
+	m := tc.enableInventory()
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	if err := m.inventory.refresh(); err != nil {
+		t.Fatalf("refresh address inventory failed: %v", err)
+	}
+
+	describes := tc.cloud.Calls(cloudfake.ActionDescribeAddresses)
+	tagLookups := tc.cloud.Calls(cloudfake.ActionDescribeResourcesByTags)
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	if _, err := tc.r.Reconcile(context.TODO(), req); err != nil {
+		t.Fatalf("reconcile bound node failed: %v", err)
+	}
+
+	if n := tc.cloud.Calls(cloudfake.ActionDescribeAddresses) - describes; n != 0 {
+		t.Errorf("expect no DescribeAddresses call for a bound node, got %d", n)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionDescribeResourcesByTags) - tagLookups; n != 0 {
+		t.Errorf("expect no DescribeResourcesByTags call for a bound node, got %d", n)
+	}
+}
+
+func TestInventoryDescribesMutatedAddress(t *testing.T) {
+	tc := newTestContext(t)
+	m := tc.enableInventory()
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	if err := m.inventory.refresh(); err != nil {
+		t.Fatalf("refresh address inventory failed: %v", err)
+	}
+	id := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]
+
+	if err := m.DisassociateAnycastIp(id); err != nil && !isInProgress(err) {
+		t.Fatalf("disassociate anycast ip %s failed: %v", id, err)
+	}
+	describes := tc.cloud.Calls(cloudfake.ActionDescribeAddresses)
+	addr, err := m.DescribeAnycastIp(id)
+	if err != nil {
+		t.Fatalf("describe anycast ip %s failed: %v", id, err)
+	}
+	if tc.cloud.Calls(cloudfake.ActionDescribeAddresses) == describes {
+		t.Errorf("expect the disassociated anycast ip %s described again", id)
+	}
+	if status := stringValue(addr.AddressStatus); status == constants.AnycastStatusBIND {
+		t.Errorf("expect anycast ip %s no longer %s after disassociate", id, status)
+	}
+}
+
+func TestFreshInventoryAnswersNoAddress(t *testing.T) {
+	tc := newTestContext(t)
+	m := tc.enableInventory()
+	if err := m.inventory.refresh(); err != nil {
+		t.Fatalf("refresh address inventory failed: %v", err)
+	}
+	lookup := func() int {
+		describes := tc.cloud.Calls(cloudfake.ActionDescribeAddresses)
+		tagLookups := tc.cloud.Calls(cloudfake.ActionDescribeResourcesByTags)
+		addrs, err := m.describeInstanceAddresses("ins-new")
+		if err != nil || len(addrs) != 0 {
+			t.Fatalf("expect no address of a new instance, got %v, err: %v", addrs, err)
+		}
+		found, id, err := m.GetAnycastIpByTags("node-new")
+		if err != nil || found {
+			t.Fatalf("expect no address tagged for a new node, got %q, err: %v", id, err)
+		}
+		return tc.cloud.Calls(cloudfake.ActionDescribeAddresses) - describes +
+			tc.cloud.Calls(cloudfake.ActionDescribeResourcesByTags) - tagLookups
+	}
+
+	if n := lookup(); n != 0 {
+		t.Errorf("expect a fresh inventory to answer no address without cloud api call, got %d calls", n)
+	}
+
+	// an address mutated since the refresh may be the one of the new node
+	m.inventory.invalidate("eip-mutated")
+	if n := lookup(); n != 2 {
+		t.Errorf("expect 2 cloud api calls once an address is mutated, got %d", n)
+	}
+
+	if err := m.inventory.refresh(); err != nil {
+		t.Fatalf("refresh address inventory failed: %v", err)
+	}
+	m.inventory.period = time.Minute
+	m.inventory.refreshed = time.Now().Add(-3 * time.Minute)
+	if n := lookup(); n != 2 {
+		t.Errorf("expect 2 cloud api calls once the inventory is not refreshed for two periods, got %d", n)
+	}
+}
@@ -0,0 +1,148 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// processLabelRemoval takes care of the anycast ip of a node that no longer has the aia labels,
+// according to the label removal policy
+func (r *reconciler) processLabelRemoval(ctx context.Context, node *corev1.Node) error {
+	policy := r.Config().Node.LabelRemovalPolicy
+	if policy == "" || policy == config.LabelRemovalPolicyIgnore {
+		klog.V(2).Infof("node %s has no aia labels, label removal policy is %s, just skip it", node.Name, config.LabelRemovalPolicyIgnore)
+		return nil
+	}
+
+	binding, err := r.getBinding(ctx, node.Name)
+	if err != nil {
+		return err
+	}
+	// only look up the address of nodes that have one, the binding or annotation tells
+	if binding != nil || node.Annotations[constants.AnycastIpIdAnnotationKey] != "" {
+		switch policy {
+		case config.LabelRemovalPolicyRelease:
+			if err := r.releaseNodeAddress(ctx, node.Name); err != nil {
+				return err
+			}
+			klog.Infof("node %s lost aia labels, anycast ip released", node.Name)
+			r.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpReleased,
+				"Anycast ip released because node has no aia labels")
+		case config.LabelRemovalPolicyDisassociate:
+			if binding == nil || binding.Status.Phase != aiav1alpha1.BindingPhaseDetached {
+				if err := r.detachNodeAddress(ctx, node, binding); err != nil {
+					return err
+				}
+				klog.Infof("node %s lost aia labels, anycast ip disassociated and kept", node.Name)
+				r.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpDisassociated,
+					"Anycast ip disassociated and kept because node has no aia labels")
+			}
+		default:
+			return fmt.Errorf("unknown node label removal policy %s", policy)
+		}
+	}
+
+	return r.cleanNodeWithoutAiaLabels(ctx, node, policy == config.LabelRemovalPolicyRelease)
+}
+
+// detachNodeAddress disassociates the anycast ip of the node and records it in a Detached binding, so that
+// it is associated again if the labels come back, and released when the node is deleted
+func (r *reconciler) detachNodeAddress(ctx context.Context, node *corev1.Node, binding *aiav1alpha1.NodeAddressBinding) error {
+	anycastId := ""
+	if binding != nil {
+		anycastId = binding.Status.AddressID
+	} else {
+		found, foundAnycastId, err := r.AiaManger.GetAnycastIpByTags(node.Name)
+		if err != nil {
+			klog.Errorf("GetAnycastIpByTags of node %s failed, err: %v", node.Name, err)
+			return err
+		}
+		if !found {
+			return nil
+		}
+		anycastId = foundAnycastId
+	}
+
+	if anycastId != "" {
+		addr, err := r.AiaManger.DescribeAnycastIp(anycastId)
+		if err != nil {
+			return err
+		}
+		if addr == nil {
+			klog.Infof("anycast ip %s of node %s not found, maybe has been released", anycastId, node.Name)
+			anycastId = ""
+		} else if addr.InstanceId != nil && *addr.InstanceId != "" && *addr.InstanceId != node.Labels[constants.TkeNodeInsIdAnnoKey] {
+			klog.Warningf("anycast ip %s of node %s is bound to another instance %s, not going to disassociate it",
+				anycastId, node.Name, *addr.InstanceId)
+			anycastId = ""
+		} else if stringValue(addr.AddressStatus) != constants.AnycastStatusUnBind {
+			// the address is recorded as detached once it is UNBIND
+			if err := r.AiaManger.DisassociateAnycastIp(anycastId); err != nil {
+				if !isInProgress(err) {
+					klog.Errorf("DisassociateAnycastIp %s failed, err: %v", anycastId, err)
+				}
+				return err
+			}
+		}
+	}
+
+	if binding == nil {
+		var err error
+		if binding, err = r.ensureBinding(ctx, node); err != nil {
+			return err
+		}
+	}
+	return r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+		status.Phase = aiav1alpha1.BindingPhaseDetached
+		status.AddressID = anycastId
+		status.LastError = ""
+	})
+}
+
+// cleanNodeWithoutAiaLabels removes the anycast ip annotations and the no-aia-ip taint of the node,
+// as well as the finalizer if the anycast ip has been released
+func (r *reconciler) cleanNodeWithoutAiaLabels(ctx context.Context, node *corev1.Node, removeFinalizer bool) error {
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	changed := false
+	for _, key := range []string{constants.AnycastIpIdAnnotationKey, constants.AnycastIpIpAnnotationKey} {
+		if _, ok := node.Annotations[key]; ok {
+			delete(node.Annotations, key)
+			changed = true
+		}
+	}
+	taints := make([]corev1.Taint, 0, len(node.Spec.Taints))
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == constants.NoAnycastIpTaintKey {
+			changed = true
+			continue
+		}
+		taints = append(taints, taint)
+	}
+	node.Spec.Taints = taints
+	if removeFinalizer && controllerutil.ContainsFinalizer(node, constants.NodeFinalizer) {
+		controllerutil.RemoveFinalizer(node, constants.NodeFinalizer)
+		changed = true
+	}
+	if !changed {
+		return nil
+	}
+	if r.dryRun.skip(node, "PatchNode", "remove anycast ip annotations and taint %s, remove finalizer %v",
+		constants.NoAnycastIpTaintKey, removeFinalizer) {
+		return nil
+	}
+
+	if err := r.k8sClient.Patch(ctx, node, patch); err != nil {
+		klog.Errorf("clean anycast ip annotations and taint of node %s failed, err: %v", node.Name, err)
+		return err
+	}
+	klog.V(2).Infof("clean anycast ip annotations and taint of node %s success", node.Name)
+	return nil
+}
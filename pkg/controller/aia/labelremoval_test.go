@@ -0,0 +1,95 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// removeAiaLabel removes the aia label of the node and returns whether the update event is enqueued
+func (tc *testContext) removeAiaLabel(name string) bool {
+	oldNode := tc.getNode(name)
+	newNode := oldNode.DeepCopy()
+	delete(newNode.Labels, testAiaLabel)
+	if err := tc.k8sClient.Update(context.TODO(), newNode); err != nil {
+		tc.t.Fatalf("remove label of node %s failed: %v", name, err)
+	}
+	return tc.r.ProcessNodeUpdate(event.UpdateEvent{ObjectOld: oldNode, ObjectNew: newNode})
+}
+
+func TestLabelRemovalReleasesAddress(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Node.LabelRemovalPolicy = config.LabelRemovalPolicyRelease
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+
+	if !tc.removeAiaLabel(node.Name) {
+		t.Fatalf("expect node losing aia label to be enqueued")
+	}
+	tc.reconcileUntilDone(node.Name)
+
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 0 {
+		t.Errorf("expect address released, got %d addresses left", len(addrs))
+	}
+	if tc.getBinding(node.Name) != nil {
+		t.Errorf("expect binding of node %s deleted", node.Name)
+	}
+	if anno := tc.getNode(node.Name).Annotations; anno[constants.AnycastIpIdAnnotationKey] != "" ||
+		anno[constants.AnycastIpIpAnnotationKey] != "" {
+		t.Errorf("expect anycast ip annotations removed, got %v", anno)
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeNormal, constants.AnycastIpReleased) {
+		t.Errorf("expect a %s event", constants.AnycastIpReleased)
+	}
+}
+
+func TestLabelRemovalDisassociatesAndKeepsAddress(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Node.LabelRemovalPolicy = config.LabelRemovalPolicyDisassociate
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	addressId := *tc.cloud.ListAddresses()[0].AddressId
+
+	tc.removeAiaLabel(node.Name)
+	tc.reconcileUntilDone(node.Name)
+
+	addr, ok := tc.cloud.GetAddress(addressId)
+	if !ok || *addr.AddressStatus != constants.AnycastStatusUnBind {
+		t.Fatalf("expect address %s kept and unbound, got %+v", addressId, addr)
+	}
+	if binding := tc.getBinding(node.Name); binding == nil || binding.Status.Phase != aiav1alpha1.BindingPhaseDetached {
+		t.Errorf("expect detached binding, got %+v", binding)
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeNormal, constants.AnycastIpDisassociated) {
+		t.Errorf("expect a %s event", constants.AnycastIpDisassociated)
+	}
+
+	// the kept address is associated again once the label comes back
+	relabeled := tc.getNode(node.Name)
+	relabeled.Labels[testAiaLabel] = "true"
+	if err := tc.k8sClient.Update(context.TODO(), relabeled); err != nil {
+		t.Fatalf("relabel node failed: %v", err)
+	}
+	tc.reconcileUntilDone(node.Name)
+	tc.reconcileUntilDone(node.Name)
+
+	if addrs := tc.cloud.ListAddresses(); len(addrs) != 1 || *addrs[0].AddressId != addressId ||
+		*addrs[0].AddressStatus != constants.AnycastStatusBIND {
+		t.Errorf("expect address %s bound again, got %+v", addressId, addrs)
+	}
+}
+
+func TestLabelRemovalIgnoredByDefault(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+
+	if tc.removeAiaLabel(node.Name) {
+		t.Errorf("expect node losing aia label not to be enqueued by default")
+	}
+}
@@ -5,9 +5,10 @@ import (
 	"encoding/json"
 	"fmt"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
-	cvm "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/cvm/v20170312"
 	tag "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/tag/v20180813"
 	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
 	corev1 "k8s.io/api/core/v1"
@@ -16,90 +17,137 @@ import (
 	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/apimachinery/pkg/util/uuid"
 	clientset "k8s.io/client-go/kubernetes"
-	"k8s.io/client-go/rest"
 	"k8s.io/client-go/tools/record"
 	"k8s.io/client-go/util/retry"
 	"k8s.io/klog/v2"
 	"sigs.k8s.io/controller-runtime/pkg/client"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
 	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
 )
 
 // Manger is not only take care of aia ip staff, but also HighQualityEIP
 type Manger interface {
 	ProcessingEipType() string
+	SetProcessingEipType(eipType string)
 	IsAiaNode(labels map[string]string, node *corev1.Node) bool
-	IsCvmNeedToAllocateAnyCastIp(node *corev1.Node) (bool, error)
+	IsCvmNeedToAllocateAnyCastIp(node *corev1.Node, spec *AddressSpec) (bool, error)
 	GetAnycastIpByTags(nodeName string) (bool, string, error)
+	DescribeAnycastIp(anycastIpId string) (*vpc.Address, error)
 	GetOrCreateClusterUuidInCm() (string, error)
-	AllocateAnycastIp(node *corev1.Node, additionalTags map[string]string) (string, error)
+	AllocateAnycastIp(node *corev1.Node, spec *AddressSpec) (string, error)
 	AssociateAnycastIp(node *corev1.Node, anycastIpId string) error
 	DisassociateAnycastIp(anycastIpId string) error
 	ReleaseAnycastIp(anycastIpId string) error
+	ConvergeAnycastIp(node *corev1.Node, anycastIpId string, spec *AddressSpec) error
+	RetainAnycastIp(anycastIpId string) error
+	ListRetainedAnycastIps() ([]*vpc.Address, error)
+	DetachAnycastIp(anycastIpId string) error
+	SyncWarmPools(specs []*AddressSpec) error
+	WarmPoolTaken() <-chan struct{}
+	RunAddressInventory(ctx context.Context, period time.Duration)
+	GetPodAnycastIpByTags(podKey string) (bool, string, error)
+	ListPodAnycastIps() ([]*vpc.Address, error)
+	AllocatePodAnycastIp(pod *corev1.Pod, spec *AddressSpec) (string, error)
+	AssociatePodAnycastIp(pod *corev1.Pod, anycastIpId string) error
+	ListBatchAnycastIps() ([]*vpc.Address, error)
+	UnmarkBatchAnycastIp(anycastIpId string) error
 }
 
 const (
-	tagDuplicateErrCode     = "TagDuplicate"
+	tagDuplicateErrCode     = "ResourceInUse.TagDuplicate"
 	tagNotExistedErrCode    = "InvalidTag.NotExisted"
 	newTagNotExistedErrCode = "InvalidParameterValue.TagNotExisted"
 )
 
 type MangerImp struct {
-	cvmClient        *cvm.Client
-	vpcClient        *vpc.Client
-	tagClient        *tag.Client
+	cvmClient        cloud.CvmAPI
+	vpcClient        cloud.VpcAPI
+	tagClient        cloud.TagAPI
 	eventRecorder    record.EventRecorder
 	clusterId        string
 	clusterUuid      string
-	bandwidth        int64
-	anycastZone      string
+	region           string
+	addressTypeLock  sync.RWMutex
 	addressType      string
 	k8sClient        client.Client
 	k8sNoCacheClient clientset.Interface
+	dryRun           *dryRun
+	// warmPoolLock serializes taking standby addresses and releasing surplus ones
+	warmPoolLock sync.Mutex
+	// warmPoolTaken is signaled when a standby address is taken, so that the pool is refilled
+	warmPoolTaken chan struct{}
+	// async tracks the cloud operations started on addresses until they settle
+	async *asyncTracker
+	// inventory is consulted before describing addresses, vpcClient and tagClient invalidate the addresses they mutate
+	inventory *addressInventory
+	// allocator and releaser batch the allocations of the same spec and the releases of concurrent reconciles
+	allocator *coalescer
+	releaser  *coalescer
 }
 
+// NewAiaManager creates a Manger, kubeClient is used to read and write objects that are not cached by k8sClient
 func NewAiaManager(
 	k8sClient client.Client,
-	cvmClient *cvm.Client,
-	vpcClient *vpc.Client,
-	tagClient *tag.Client,
+	kubeClient clientset.Interface,
+	cvmClient cloud.CvmAPI,
+	vpcClient cloud.VpcAPI,
+	tagClient cloud.TagAPI,
 	record record.EventRecorder,
 	clusterId string,
-	bandwidth int64,
-	anycastZone string,
+	region string,
 	addressType string,
+	dryRunEnabled bool,
+	inventoryEnabled bool,
+	batchWindow time.Duration,
 ) (Manger, error) {
-
-	config, err := rest.InClusterConfig()
-	if err != nil {
-		return nil, err
-	}
-	kubeClient, err := clientset.NewForConfig(config)
-	if err != nil {
-		return nil, err
-	}
-
-	return &MangerImp{
+	inventory := newAddressInventory(vpcClient, inventoryEnabled)
+	clients := inventory.wrap(&cloud.Clients{Vpc: vpcClient, Tag: tagClient, Cvm: cvmClient})
+	m := &MangerImp{
 		cvmClient:        cvmClient,
-		vpcClient:        vpcClient,
-		tagClient:        tagClient,
+		vpcClient:        clients.Vpc,
+		tagClient:        clients.Tag,
 		eventRecorder:    record,
 		clusterId:        clusterId,
-		bandwidth:        bandwidth,
+		region:           region,
 		k8sClient:        k8sClient,
-		anycastZone:      anycastZone,
 		addressType:      addressType,
 		k8sNoCacheClient: kubeClient,
-	}, nil
+		dryRun:           &dryRun{enabled: dryRunEnabled, eventRecorder: record},
+		warmPoolTaken:    make(chan struct{}, 1),
+		async:            newAsyncTracker(),
+		inventory:        inventory,
+	}
+	m.allocator = newCoalescer(batchWindow, allocateAddressesBatch, m.allocateBatch)
+	m.releaser = newCoalescer(batchWindow, releaseAddressesBatch, m.releaseBatch)
+	return m, nil
 }
 
-// ProcessingEipType return eip type that this controller processing, default is AnycastEIP
+// RunAddressInventory refreshes the address inventory every period until ctx is done, it runs on the leader only
+func (m *MangerImp) RunAddressInventory(ctx context.Context, period time.Duration) {
+	m.inventory.run(ctx, period)
+}
+
+// ProcessingEipType return eip type that this controller processing if the node is not selected by any pool,
+// default is AnycastEIP
 func (m *MangerImp) ProcessingEipType() string {
+	m.addressTypeLock.RLock()
+	defer m.addressTypeLock.RUnlock()
 	if m.addressType != "" {
 		return m.addressType
 	}
 	return constants.EipTypeAnyCast
 }
 
+// SetProcessingEipType changes the eip type returned by ProcessingEipType, empty means AnycastEIP
+func (m *MangerImp) SetProcessingEipType(eipType string) {
+	m.addressTypeLock.Lock()
+	defer m.addressTypeLock.Unlock()
+	m.addressType = eipType
+}
+
 // IsAiaNode will check if a node has labels that user specify in config file
 func (m *MangerImp) IsAiaNode(labels map[string]string, node *corev1.Node) bool {
 	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
@@ -118,6 +166,20 @@ func (m *MangerImp) IsAiaNode(labels map[string]string, node *corev1.Node) bool
 }
 
 func (m *MangerImp) GetAnycastIpByTags(nodeName string) (bool, string, error) {
+	return m.getAnycastIpByTag(constants.AiaNodeNameAnnoKey, nodeName)
+}
+
+// getAnycastIpByTag returns an address of the cluster that has the tag key:value
+func (m *MangerImp) getAnycastIpByTag(key, value string) (bool, string, error) {
+	if addrs, ok := m.inventory.getByTags(map[string]string{
+		constants.AiaIpControllerClusterUuidAnnoKey: m.clusterUuid,
+		key: value,
+	}); ok {
+		if len(addrs) == 0 {
+			return false, "", nil
+		}
+		return true, *addrs[0].AddressId, nil
+	}
 	descTagReq := tag.NewDescribeResourcesByTagsRequest()
 	descTagReq.TagFilters = []*tag.TagFilter{
 		{
@@ -125,8 +187,8 @@ func (m *MangerImp) GetAnycastIpByTags(nodeName string) (bool, string, error) {
 			TagValue: common.StringPtrs([]string{m.clusterUuid}),
 		},
 		{
-			TagKey:   common.StringPtr(constants.AiaNodeNameAnnoKey),
-			TagValue: common.StringPtrs([]string{nodeName}),
+			TagKey:   common.StringPtr(key),
+			TagValue: common.StringPtrs([]string{value}),
 		},
 	}
 	descTagResp, err := m.tagClient.DescribeResourcesByTags(descTagReq)
@@ -138,15 +200,23 @@ func (m *MangerImp) GetAnycastIpByTags(nodeName string) (bool, string, error) {
 	}
 	if len(descTagResp.Response.Rows) < 1 {
 		klog.V(2).Infof("get anycast ip using tags(%s:%s, %s:%s) found no resource, requestId %s",
-			constants.AiaIpControllerClusterUuidAnnoKey, m.clusterUuid, constants.AiaNodeNameAnnoKey, nodeName, *descTagResp.Response.RequestId)
+			constants.AiaIpControllerClusterUuidAnnoKey, m.clusterUuid, key, value, *descTagResp.Response.RequestId)
 		return false, "", nil
 	}
 	return true, *descTagResp.Response.Rows[0].ResourceId, nil
 }
 
-func (m *MangerImp) AllocateAnycastIp(node *corev1.Node, additionalTags map[string]string) (string, error) {
-	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+// DescribeAnycastIp returns the address of anycastIpId, or nil if it does not exist, from the address inventory
+// unless it is stale or changing its status
+func (m *MangerImp) DescribeAnycastIp(anycastIpId string) (*vpc.Address, error) {
+	return m.inventory.get(anycastIpId)
+}
 
+func (m *MangerImp) AllocateAnycastIp(node *corev1.Node, spec *AddressSpec) (_ string, err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("AllocateAnycastIp", start, err) }(time.Now())
+	if spec.StaticAddressID != "" {
+		return m.adoptStaticAnycastIp(node, spec)
+	}
 	// 1. call tag api to find existed anycast ip
 	anycastFound, foundAnycastId, err := m.GetAnycastIpByTags(node.Name)
 	if err != nil {
@@ -155,58 +225,53 @@ func (m *MangerImp) AllocateAnycastIp(node *corev1.Node, additionalTags map[stri
 	if anycastFound {
 		return foundAnycastId, nil
 	}
+	if spec.Identity != "" {
+		stickyId, err := m.takeStickyAnycastIp(node, spec)
+		if err != nil {
+			return "", err
+		}
+		if stickyId != "" {
+			return stickyId, nil
+		}
+	}
+	if spec.WarmPoolSize > 0 {
+		warmId, err := m.takeWarmAnycastIp(node, spec)
+		if err != nil {
+			return "", err
+		}
+		if warmId != "" {
+			return warmId, nil
+		}
+	}
 
 	klog.V(2).Infof("describe resources by tags has no resource, going to create a new anycast ip")
 
-	// 2. call vpc to create a new one
-	allocateReq := vpc.NewAllocateAddressesRequest()
-	allocateReq.AddressName = common.StringPtr(fmt.Sprintf("%s-aia", m.clusterId))
-	allocateReq.AddressType = common.StringPtr(m.ProcessingEipType())
-	if m.ProcessingEipType() == constants.EipTypeAnyCast && m.anycastZone != "" {
-		allocateReq.AnycastZone = common.StringPtr(m.anycastZone)
-	}
-	if m.bandwidth > 0 {
-		allocateReq.InternetMaxBandwidthOut = common.Int64Ptr(m.bandwidth)
-	}
+	// 2. call vpc to create a new one, together with the other nodes allocating an address of the same spec
+	res := m.allocator.submit(m.allocateBatchKey(spec), &allocateItem{node: node, spec: spec})
+	return res.id, res.err
+}
 
-	tagKeyValMap := map[string]string{
-		constants.AiaIpControllerClusterUuidAnnoKey: m.clusterUuid,
-		constants.AiaIpControllerClusterIdAnnoKey:   m.clusterId,
-		constants.AiaNodeNameAnnoKey:                node.Name,
-		constants.AiaNodeInsIdAnnoKey:               cvmInsId,
-	}
-	for k, v := range additionalTags {
-		tagKeyValMap[k] = v
-	}
+// allocateNodeAddress allocates an address tagged for the node by its own AllocateAddresses call
+func (m *MangerImp) allocateNodeAddress(node *corev1.Node, spec *AddressSpec) (string, error) {
+	tagKeyValMap := m.addressTags(node, spec)
+	allocateReq := newAllocateRequest(spec, tagKeyValMap)
 
-	for k, v := range tagKeyValMap {
-		allocateReq.Tags = append(allocateReq.Tags, &vpc.Tag{
-			Key:   common.StringPtr(k),
-			Value: common.StringPtr(v),
-		})
+	if m.dryRun.skip(node, "AllocateAddresses", "allocate %s with bandwidth %d and tags %v", spec.AddressType,
+		spec.Bandwidth, tagKeyValMap) {
+		return "", errDryRun
 	}
-
 	allocateResp, err := m.vpcClient.AllocateAddresses(allocateReq)
 	if err != nil {
 		klog.Warningf("allocate addresses for node %s failed, err: %v.", node.Name, err)
 		// try to create tag key and value, because eip api do not support auto create tag.
 		// and the stupid tag create api is cannot reentry, so we have to create tag here...
-		if !strings.Contains(err.Error(), tagNotExistedErrCode) && !strings.Contains(err.Error(), newTagNotExistedErrCode) {
-			eventStr := strings.Split(err.Error(), ", RequestId")[0]
-			m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, fmt.Sprintf("Failed to allocate Anycast ip (will retry): %s", eventStr))
+		if !clouderrors.IsCode(err, tagNotExistedErrCode, newTagNotExistedErrCode) {
+			m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "Failed to allocate Anycast ip (will retry): %s", clouderrors.Describe(err))
 			// event if error not container tag not exist code, we will still try to create tag, in case vpc api change error code
 		}
-		for k, v := range tagKeyValMap {
-			reqCreateTag := tag.NewCreateTagRequest()
-			reqCreateTag.TagKey = common.StringPtr(k)
-			reqCreateTag.TagValue = common.StringPtr(v)
-			_, tagCreateErr := m.tagClient.CreateTag(reqCreateTag)
-			if tagCreateErr != nil && !strings.Contains(tagCreateErr.Error(), tagDuplicateErrCode) {
-				rB, _ := json.Marshal(reqCreateTag)
-				klog.Errorf("create tag failed, createTag req: %s, err: %v", string(rB), tagCreateErr)
-				m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "Failed to allocate anycast ip (will retry): %s", err.Error())
-				return "", fmt.Errorf("DescribeResourcesByTags failed: %s.  CreateTag failed: %s", err.Error(), tagCreateErr.Error())
-			}
+		if tagCreateErr := m.createTags(node, tagKeyValMap); tagCreateErr != nil {
+			m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "Failed to allocate anycast ip (will retry): %s", clouderrors.Describe(err))
+			return "", fmt.Errorf("DescribeResourcesByTags failed: %s.  CreateTag failed: %s", err.Error(), tagCreateErr.Error())
 		}
 		// create tag
 		// make sure outside loop will describe tag again, if query tag resource not exist at first
@@ -224,39 +289,114 @@ func (m *MangerImp) AllocateAnycastIp(node *corev1.Node, additionalTags map[stri
 	if !strings.HasPrefix(anycastIdAllocated, constants.AnycastIdPrefix) {
 		return "", fmt.Errorf("allocate address got an invalid(has no prefix %s) anycast ip id: %s", constants.AnycastIdPrefix, anycastIdAllocated)
 	}
+	m.async.start(asyncAllocate, anycastIdAllocated, stringValue(allocateResp.Response.TaskId))
 	return anycastIdAllocated, nil
 }
 
-func (m *MangerImp) AssociateAnycastIp(node *corev1.Node, anycastIpId string) error {
+// newAllocateRequest returns the request allocating an address of spec with the tags
+func newAllocateRequest(spec *AddressSpec, tags map[string]string) *vpc.AllocateAddressesRequest {
+	allocateReq := vpc.NewAllocateAddressesRequest()
+	allocateReq.AddressName = common.StringPtr(spec.AddressName)
+	allocateReq.AddressType = common.StringPtr(spec.AddressType)
+	if spec.AddressType == constants.EipTypeAnyCast && spec.AnycastZone != "" {
+		allocateReq.AnycastZone = common.StringPtr(spec.AnycastZone)
+	}
+	if spec.Bandwidth > 0 {
+		allocateReq.InternetMaxBandwidthOut = common.Int64Ptr(spec.Bandwidth)
+	}
+	if spec.InternetChargeType != "" {
+		allocateReq.InternetChargeType = common.StringPtr(spec.InternetChargeType)
+	}
+	for k, v := range tags {
+		allocateReq.Tags = append(allocateReq.Tags, &vpc.Tag{
+			Key:   common.StringPtr(k),
+			Value: common.StringPtr(v),
+		})
+	}
+	return allocateReq
+}
+
+// addressTags returns the tags an address of the node should have, the ownership tags and those of spec
+func (m *MangerImp) addressTags(node *corev1.Node, spec *AddressSpec) map[string]string {
+	tagKeyValMap := m.specTags(spec)
+	for k, v := range nodeTags(node, spec) {
+		tagKeyValMap[k] = v
+	}
+	return tagKeyValMap
+}
+
+// nodeTags returns the tags binding an address to the node, and to its identity in sticky mode
+func nodeTags(node *corev1.Node, spec *AddressSpec) map[string]string {
+	tags := map[string]string{
+		constants.AiaNodeNameAnnoKey:  node.Name,
+		constants.AiaNodeInsIdAnnoKey: node.Labels[constants.TkeNodeInsIdAnnoKey],
+	}
+	if spec.Identity != "" {
+		tags[constants.AiaNodeIdentityAnnoKey] = spec.Identity
+	}
+	return tags
+}
+
+// specTags returns the cluster ownership tags and those of spec
+func (m *MangerImp) specTags(spec *AddressSpec) map[string]string {
+	tagKeyValMap := map[string]string{
+		constants.AiaIpControllerClusterUuidAnnoKey: m.clusterUuid,
+		constants.AiaIpControllerClusterIdAnnoKey:   m.clusterId,
+	}
+	if spec.Pool != "" {
+		tagKeyValMap[constants.AiaPoolNameAnnoKey] = spec.Pool
+	}
+	for k, v := range spec.Tags {
+		tagKeyValMap[k] = v
+	}
+	return tagKeyValMap
+}
+
+// createTags creates the tag key value pairs, those already existed are skipped. obj is the object
+// the tags are created for, it is nil for the standby addresses.
+func (m *MangerImp) createTags(obj client.Object, tags map[string]string) error {
+	for k, v := range tags {
+		if m.dryRun.skip(obj, "CreateTag", "create tag %s:%s", k, v) {
+			continue
+		}
+		reqCreateTag := tag.NewCreateTagRequest()
+		reqCreateTag.TagKey = common.StringPtr(k)
+		reqCreateTag.TagValue = common.StringPtr(v)
+		_, err := m.tagClient.CreateTag(reqCreateTag)
+		if err != nil && !clouderrors.IsCode(err, tagDuplicateErrCode) {
+			rB, _ := json.Marshal(reqCreateTag)
+			klog.Errorf("create tag failed, createTag req: %s, err: %v", string(rB), err)
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *MangerImp) AssociateAnycastIp(node *corev1.Node, anycastIpId string) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("AssociateAnycastIp", start, err) }(time.Now())
 	klog.V(2).Infof("trying to associate node %s with anycastIp %s", node.Name, anycastIpId)
 	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
 	// 1. describe anycast ip status
-	descAddrReq := vpc.NewDescribeAddressesRequest()
-	descAddrReq.AddressIds = common.StringPtrs([]string{anycastIpId})
-	descAddrResp, err := m.vpcClient.DescribeAddresses(descAddrReq)
+	addr, err := m.DescribeAnycastIp(anycastIpId)
 	if err != nil {
 		return err
 	}
-	if descAddrResp == nil || descAddrResp.Response == nil || descAddrResp.Response.AddressSet == nil {
-		return fmt.Errorf("DescribeAddresses of %s has no response", anycastIpId)
+	if addr == nil {
+		return fmt.Errorf("anycast ip %s not found", anycastIpId)
 	}
-	if len(descAddrResp.Response.AddressSet) != 1 {
-		return fmt.Errorf("DescribeAddresses of %s has wrong addressSet len %d, expect to be 1", anycastIpId, len(descAddrResp.Response.AddressSet))
-	}
-	if descAddrResp.Response.AddressSet[0].AddressIp == nil {
-		msg := fmt.Sprintf("DescribeAddresses of %s has no addressIp", anycastIpId)
-		if descAddrResp.Response.RequestId != nil {
-			msg += fmt.Sprintf(", requestId %s", *descAddrResp.Response.RequestId)
-		}
-		return fmt.Errorf(msg)
+	if addr.AddressIp == nil {
+		return fmt.Errorf("DescribeAddresses of %s has no addressIp", anycastIpId)
 	}
 
 	// 2. check if anycast has associated with this node
-	anycastIpStatus := *descAddrResp.Response.AddressSet[0].AddressStatus
-	anycastIpAddrIp := *descAddrResp.Response.AddressSet[0].AddressIp
+	anycastIpStatus := stringValue(addr.AddressStatus)
+	anycastIpAddrIp := *addr.AddressIp
 	anycastAssociatedInsId := "NONE"
-	if descAddrResp.Response.AddressSet[0].InstanceId != nil {
-		anycastAssociatedInsId = *descAddrResp.Response.AddressSet[0].InstanceId
+	if addr.InstanceId != nil {
+		anycastAssociatedInsId = *addr.InstanceId
+	}
+	if err := m.waitAsync(anycastIpId, anycastIpStatus); err != nil {
+		return err
 	}
 
 	switch anycastIpStatus {
@@ -275,6 +415,9 @@ func (m *MangerImp) AssociateAnycastIp(node *corev1.Node, anycastIpId string) er
 		assAddrReq := vpc.NewAssociateAddressRequest()
 		assAddrReq.AddressId = common.StringPtr(anycastIpId)
 		assAddrReq.InstanceId = common.StringPtr(cvmInsId)
+		if m.dryRun.skip(node, "AssociateAddress", "associate anycast ip %s with instance %s", anycastIpId, cvmInsId) {
+			return errDryRun
+		}
 		assAddrResp, err := m.vpcClient.AssociateAddress(assAddrReq)
 		if err != nil {
 			return err
@@ -282,37 +425,33 @@ func (m *MangerImp) AssociateAnycastIp(node *corev1.Node, anycastIpId string) er
 		if assAddrResp == nil || assAddrResp.Response == nil {
 			return fmt.Errorf("AssociateAddress for anycast ip %s for node %s has no response", anycastIpId, cvmInsId)
 		}
-		return fmt.Errorf("call vpc api to assocaite anycast ip %s with node %s success, requestId %s and taskId %s but still need to wait for it status to be BIND",
-			anycastIpId, cvmInsId, *assAddrResp.Response.RequestId, *assAddrResp.Response.TaskId)
+		klog.V(2).Infof("call vpc api to associate anycast ip %s with node %s success, requestId %s and taskId %s",
+			anycastIpId, cvmInsId, stringValue(assAddrResp.Response.RequestId), stringValue(assAddrResp.Response.TaskId))
+		return m.startAsync(asyncAssociate, anycastIpId, stringValue(assAddrResp.Response.TaskId), anycastIpStatus)
 	default:
-		klog.V(2).Infof("anycast ip %s status is %s, not going to process it.", anycastIpId, anycastIpStatus)
-		return fmt.Errorf("waiting anycast ip %s to change it status, currently is %s", anycastIpId, anycastIpStatus)
+		return fmt.Errorf("anycast ip %s has unexpected status %s", anycastIpId, anycastIpStatus)
 	}
 }
 
-func (m *MangerImp) DisassociateAnycastIp(anycastIpId string) error {
-	descAddrReq := vpc.NewDescribeAddressesRequest()
-	descAddrReq.AddressIds = common.StringPtrs([]string{anycastIpId})
-	descAddrResp, err := m.vpcClient.DescribeAddresses(descAddrReq)
+func (m *MangerImp) DisassociateAnycastIp(anycastIpId string) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("DisassociateAnycastIp", start, err) }(time.Now())
+	addr, err := m.DescribeAnycastIp(anycastIpId)
 	if err != nil {
 		return err
 	}
-	if descAddrResp == nil || descAddrResp.Response == nil || descAddrResp.Response.AddressSet == nil {
-		return fmt.Errorf("DescribeAddresses of %s has no response", anycastIpId)
-	}
-	if len(descAddrResp.Response.AddressSet) == 0 {
-		klog.Warningf("DescribeAddresses of anycast ip %s return no resource, maybe has been release, no need to disassociate, requestId %s",
-			anycastIpId, *descAddrResp.Response.RequestId)
+	if addr == nil {
+		klog.Warningf("DescribeAddresses of anycast ip %s return no resource, maybe has been release, no need to disassociate",
+			anycastIpId)
 		return nil
 	}
-	if len(descAddrResp.Response.AddressSet) != 1 {
-		return fmt.Errorf("DescribeAddresses of %s has wrong addressSet len %d, expect to be 1", anycastIpId, len(descAddrResp.Response.AddressSet))
-	}
 
-	anycastIpStatus := *descAddrResp.Response.AddressSet[0].AddressStatus
+	anycastIpStatus := stringValue(addr.AddressStatus)
 	anycastAssociatedInsId := "NONE"
-	if descAddrResp.Response.AddressSet[0].InstanceId != nil {
-		anycastAssociatedInsId = *descAddrResp.Response.AddressSet[0].InstanceId
+	if addr.InstanceId != nil {
+		anycastAssociatedInsId = *addr.InstanceId
+	}
+	if err := m.waitAsync(anycastIpId, anycastIpStatus); err != nil {
+		return err
 	}
 	switch anycastIpStatus {
 	case constants.AnycastStatusUnBind:
@@ -322,6 +461,9 @@ func (m *MangerImp) DisassociateAnycastIp(anycastIpId string) error {
 		// todo: disassociate anycast ip
 		disAssReq := vpc.NewDisassociateAddressRequest()
 		disAssReq.AddressId = common.StringPtr(anycastIpId)
+		if m.dryRun.skip(nil, "DisassociateAddress", "disassociate anycast ip %s from %s", anycastIpId, anycastAssociatedInsId) {
+			return errDryRun
+		}
 		disAssResp, err := m.vpcClient.DisassociateAddress(disAssReq)
 		if err != nil {
 			klog.Errorf("DisassociateAddress anycast ip %s failed, err: %v", anycastIpId, err)
@@ -331,16 +473,25 @@ func (m *MangerImp) DisassociateAnycastIp(anycastIpId string) error {
 			return fmt.Errorf("DisassociateAddress anycast ip %s has no response", anycastIpId)
 		}
 		klog.V(2).Infof("call vpc api to disassociate anycast ip %s success, its origin associated resource %s, taskId %s, requestId %s",
-			anycastIpId, anycastAssociatedInsId, *disAssResp.Response.TaskId, *disAssResp.Response.RequestId)
-		return nil // outside loop will make sure the anycast status is UNBIND
+			anycastIpId, anycastAssociatedInsId, stringValue(disAssResp.Response.TaskId), stringValue(disAssResp.Response.RequestId))
+		// the address is released or reused once it is UNBIND
+		return m.startAsync(asyncDisassociate, anycastIpId, stringValue(disAssResp.Response.TaskId), anycastIpStatus)
 	default:
-		klog.V(2).Infof("anycast ip %s status is %s, not going to process it.", anycastIpId, anycastIpStatus)
-		return fmt.Errorf("waiting anycast ip %s to change it status, currently is %s", anycastIpId, anycastIpStatus)
+		return fmt.Errorf("anycast ip %s has unexpected status %s", anycastIpId, anycastIpStatus)
 	}
 }
 
-func (m *MangerImp) ReleaseAnycastIp(anycastIpId string) error {
+func (m *MangerImp) ReleaseAnycastIp(anycastIpId string) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("ReleaseAnycastIp", start, err) }(time.Now())
 	klog.Infof("trying to release anycast ip %s", anycastIpId)
+	if m.dryRun.skip(nil, "ReleaseAddresses", "release anycast ip %s", anycastIpId) {
+		return errDryRun
+	}
+	return m.releaser.submit("", anycastIpId).err
+}
+
+// releaseAddress releases the address by its own ReleaseAddresses call
+func (m *MangerImp) releaseAddress(anycastIpId string) error {
 	reqRelease := vpc.NewReleaseAddressesRequest()
 	reqRelease.AddressIds = common.StringPtrs([]string{anycastIpId})
 	_, err := m.vpcClient.ReleaseAddresses(reqRelease)
@@ -352,8 +503,76 @@ func (m *MangerImp) ReleaseAnycastIp(anycastIpId string) error {
 	return nil
 }
 
-func (m *MangerImp) IsCvmNeedToAllocateAnyCastIp(node *corev1.Node) (bool, error) {
+func (m *MangerImp) IsCvmNeedToAllocateAnyCastIp(node *corev1.Node, spec *AddressSpec) (bool, error) {
 	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	addrs, err := m.describeInstanceAddresses(cvmInsId)
+	if err != nil {
+		return false, err
+	}
+
+	for _, eipInfo := range addrs {
+		if eipInfo.AddressType == nil {
+			continue
+		}
+		klog.V(3).Infof("found node %s has eip type: %s, current processing type: %s", node.Name, *eipInfo.AddressType, spec.AddressType)
+		switch *eipInfo.AddressType {
+		case constants.EipTypeWanIp, constants.EipTypeCommon, constants.EipTypeAnyCast, constants.EipTypeHighQualityEIP:
+			if *eipInfo.AddressType == spec.AddressType {
+				return false, m.acceptNodeAddress(node, eipInfo, spec)
+			}
+			// the static address of the node is fixed whatever the address type is, it is never migrated
+			if spec.StaticAddressID != "" && stringValue(eipInfo.AddressId) == spec.StaticAddressID {
+				klog.Warningf("static anycast ip %s of node %s is %s rather than %s, keep it", spec.StaticAddressID,
+					node.Name, *eipInfo.AddressType, spec.AddressType)
+				m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.AlreadyHasAnycastIp,
+					"Static anycast ip %s is %s rather than %s, keep it", spec.StaticAddressID, *eipInfo.AddressType,
+					spec.AddressType)
+				return false, m.acceptNodeAddress(node, eipInfo, spec)
+			}
+			switch conflictAction(*eipInfo.AddressType, spec) {
+			case config.TypeConflictPolicyAccept:
+				klog.Infof("node %s already has %s %s,%s, accept it instead of %s", node.Name, *eipInfo.AddressType,
+					stringValue(eipInfo.AddressId), stringValue(eipInfo.AddressIp), spec.AddressType)
+				return false, m.acceptNodeAddress(node, eipInfo, spec)
+			case config.TypeConflictPolicyMigrate:
+				klog.Infof("node %s already has %s %s,%s, migrate it to %s", node.Name, *eipInfo.AddressType,
+					stringValue(eipInfo.AddressId), stringValue(eipInfo.AddressIp), spec.AddressType)
+				if err := m.taintAiaToNodeIfNecessary(node); err != nil {
+					return false, err
+				}
+				return false, &addressTypeConflictError{address: eipInfo, addressType: spec.AddressType}
+			case config.TypeConflictPolicyTaint:
+				klog.Infof("node %s already has %s %s,%s, cannot associate %s", node.Name, *eipInfo.AddressType,
+					stringValue(eipInfo.AddressId), stringValue(eipInfo.AddressIp), spec.AddressType)
+				m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "node %s has %s %s/%s, cannot associate %s",
+					node.Name, *eipInfo.AddressType, stringValue(eipInfo.AddressId), stringValue(eipInfo.AddressIp), spec.AddressType)
+				return false, m.taintAiaToNodeIfNecessary(node)
+			default:
+				// upload warning events
+				klog.Warningf("node %s already has EIP %s,%s, type is %s, cannot allocate %s", node.Name, stringValue(eipInfo.AddressId),
+					stringValue(eipInfo.AddressIp), *eipInfo.AddressType, spec.AddressType)
+				m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "node %s has EIP %s/%s, type %s, cannot allocate %s",
+					node.Name, stringValue(eipInfo.AddressId), stringValue(eipInfo.AddressIp), *eipInfo.AddressType, spec.AddressType)
+				return false, nil
+			}
+		default:
+			klog.Warningf("found an unknown eip type for cvm %s: %s", cvmInsId, *eipInfo.AddressType)
+		}
+	}
+
+	if err := m.taintAiaToNodeIfNecessary(node); err != nil {
+		return true, err
+	}
+	return true, nil
+}
+
+// describeInstanceAddresses returns the addresses bound to the cvm instance, from the address inventory unless
+// they have to be described
+func (m *MangerImp) describeInstanceAddresses(cvmInsId string) ([]*vpc.Address, error) {
+	if addrs, ok := m.inventory.getByInstance(cvmInsId); ok {
+		return addrs, nil
+	}
+	start := time.Now()
 	descCvmAddrReq := vpc.NewDescribeAddressesRequest()
 	descCvmAddrReq.Filters = []*vpc.Filter{
 		{
@@ -362,67 +581,72 @@ func (m *MangerImp) IsCvmNeedToAllocateAnyCastIp(node *corev1.Node) (bool, error
 		},
 		{
 			Name:   common.StringPtr("address-type"),
-			Values: common.StringPtrs([]string{constants.EipTypeCommon, constants.EipTypeWanIp, constants.EipTypeAnyCast, constants.EipTypeHighQualityEIP}),
+			Values: common.StringPtrs(inventoryAddressTypes),
 		},
 	}
 	descCvmAddrResp, err := m.vpcClient.DescribeAddresses(descCvmAddrReq)
 	if err != nil {
 		klog.Errorf("DescribeAddresses of cvm %s failed, err: %v", cvmInsId, err)
-		return false, err
+		return nil, err
 	}
 	if descCvmAddrResp == nil || descCvmAddrResp.Response == nil {
 		klog.Errorf("vpc/DescribeAddresses of cvm %s has no response", cvmInsId)
-		return false, fmt.Errorf("vpc DescribeAddresses has no response")
+		return nil, fmt.Errorf("vpc DescribeAddresses has no response")
 	}
 
 	descCvmAddrRespB, _ := json.Marshal(descCvmAddrResp)
 	klog.V(4).Infof("describe address of cvm %s got response: %s", cvmInsId, string(descCvmAddrRespB))
+	m.inventory.update(start, descCvmAddrResp.Response.AddressSet)
+	return descCvmAddrResp.Response.AddressSet, nil
+}
 
-	for _, eipInfo := range descCvmAddrResp.Response.AddressSet {
-		if eipInfo.AddressType == nil {
-			continue
-		}
-		vpcReqId := "nil"
-		if descCvmAddrResp.Response.RequestId != nil {
-			vpcReqId = *descCvmAddrResp.Response.RequestId
-		}
-		klog.V(3).Infof("found node %s has eip type: %s, vpc requestId: %s, current processing type: %s", node.Name, *eipInfo.AddressType, vpcReqId, m.ProcessingEipType())
-		switch *eipInfo.AddressType {
-		case constants.EipTypeWanIp:
-			klog.Infof("node %s already has wan ip %s,%s, cannot associate anycast ip", node.Name, *eipInfo.AddressId, *eipInfo.AddressIp)
-			m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "node %s has WanIp, cannot associate AnycastIp", node.Name)
-			return false, m.taintAiaToNodeIfNecessary(node)
-		case constants.EipTypeCommon:
-			klog.Infof("node %s already has EIP %s,%s, cannot associate anycast ip", node.Name, *eipInfo.AddressId, *eipInfo.AddressIp)
-			m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "node %s has EIP %s/%s, cannot associate AnycastIp", node.Name, *eipInfo.AddressId, *eipInfo.AddressIp)
-			return false, m.taintAiaToNodeIfNecessary(node)
-		case constants.EipTypeAnyCast, constants.EipTypeHighQualityEIP:
-			if *eipInfo.AddressType == m.ProcessingEipType() {
-				if eipInfo.AddressId == nil || eipInfo.AddressIp == nil {
-					return false, fmt.Errorf("anycast ip of cvm %s has no id or ip info", cvmInsId)
-				}
-				if err := m.removeNoAnycastTaintAndAddAnnotation(node, *eipInfo.AddressId, *eipInfo.AddressIp); err != nil {
-					m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedUntaintNode, "failed to untaint node %s, will retry", node.Name)
-					return false, err
-				}
-				klog.Infof("node %s already has anycast ip %s-%s, and remove taint success, just skip it", node.Name, *eipInfo.AddressId, *eipInfo.AddressIp)
-				return false, nil
-			} else {
-				// upload warning events
-				klog.Warningf("node %s already has EIP %s,%s, type is %s, cannot allocate %s", node.Name, *eipInfo.AddressId, *eipInfo.AddressIp, *eipInfo.AddressType, m.ProcessingEipType())
-				m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp, "node %s has EIP %s/%s, type %s, cannot allocate %s",
-					node.Name, *eipInfo.AddressId, *eipInfo.AddressIp, *eipInfo.AddressType, m.ProcessingEipType())
-				return false, nil
-			}
-		default:
-			klog.Warningf("found an unknown eip type for cvm %s: %s", cvmInsId, *eipInfo.AddressType)
-		}
+// addressTypeConflictError is returned by IsCvmNeedToAllocateAnyCastIp if the address of the node should be
+// migrated to another type, the node has been tainted
+type addressTypeConflictError struct {
+	address     *vpc.Address
+	addressType string
+}
+
+func (e *addressTypeConflictError) Error() string {
+	return fmt.Sprintf("address %s is %s rather than %s", stringValue(e.address.AddressId),
+		stringValue(e.address.AddressType), e.addressType)
+}
+
+// acceptNodeAddress takes the address found on the node as its address, the node is annotated and untainted,
+// and the address is adopted if it was not allocated by aia-ip-controller and the adoption policy says so
+func (m *MangerImp) acceptNodeAddress(node *corev1.Node, eipInfo *vpc.Address, spec *AddressSpec) error {
+	if eipInfo.AddressId == nil || eipInfo.AddressIp == nil {
+		return fmt.Errorf("address of node %s has no id or ip info", node.Name)
+	}
+	// the node is untainted once the address settles
+	if err := m.waitAsync(*eipInfo.AddressId, stringValue(eipInfo.AddressStatus)); err != nil {
+		return err
+	}
+	if err := m.removeNoAnycastTaintAndAddAnnotation(node, *eipInfo.AddressId, *eipInfo.AddressIp); err != nil {
+		m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedUntaintNode, "failed to untaint node %s, will retry", node.Name)
+		return err
 	}
+	klog.Infof("node %s already has %s %s-%s, and remove taint success, just skip it", node.Name, *eipInfo.AddressType,
+		*eipInfo.AddressId, *eipInfo.AddressIp)
+	return m.adoptNodeAddress(node, eipInfo, spec)
+}
 
-	if err := m.taintAiaToNodeIfNecessary(node); err != nil {
-		return true, err
+// conflictAction decides what to do with a node which has an address of existingType rather than spec.AddressType,
+// it returns one of the type conflict policies
+func conflictAction(existingType string, spec *AddressSpec) string {
+	// the public ip of a cvm can only be removed by the user
+	if existingType == constants.EipTypeWanIp {
+		return config.TypeConflictPolicyTaint
 	}
-	return true, nil
+	switch spec.TypeConflictPolicy {
+	case config.TypeConflictPolicyTaint, config.TypeConflictPolicyAccept, config.TypeConflictPolicyMigrate:
+		return spec.TypeConflictPolicy
+	}
+	// nodes with a plain EIP were always kept tainted, those with another anycast type were not
+	if existingType == constants.EipTypeCommon {
+		return config.TypeConflictPolicyTaint
+	}
+	return config.TypeConflictPolicyWarn
 }
 
 func (m *MangerImp) GetOrCreateClusterUuidInCm() (string, error) {
@@ -491,6 +715,9 @@ func (m *MangerImp) taintAiaToNodeIfNecessary(node *corev1.Node) error {
 		return err
 	}
 	klog.V(2).Infof("patch data for taint node %s: %s", node.Name, string(patchData))
+	if m.dryRun.skip(node, "PatchNode", "taint node with %s", constants.NoAnycastIpTaintKey) {
+		return nil
+	}
 
 	// taint the node if necessary
 	if err := m.k8sClient.Patch(context.Background(), node, client.RawPatch(types.MergePatchType, patchData)); err != nil {
@@ -525,6 +752,9 @@ func (m *MangerImp) removeNoAnycastTaintAndAddAnnotation(node *corev1.Node, anyc
 	}
 
 	newAnno := node.Annotations
+	if newAnno == nil {
+		newAnno = map[string]string{}
+	}
 	if !hasAnno {
 		newAnno[constants.AnycastIpIdAnnotationKey] = anycastId
 		newAnno[constants.AnycastIpIpAnnotationKey] = anycastIp
@@ -544,12 +774,19 @@ func (m *MangerImp) removeNoAnycastTaintAndAddAnnotation(node *corev1.Node, anyc
 		return err
 	}
 	klog.V(2).Infof("patch data for removing taint node %s: %s", node.Name, string(patchData))
+	if m.dryRun.skip(node, "PatchNode", "remove taint %s and annotate anycast ip %s/%s", constants.NoAnycastIpTaintKey,
+		anycastId, anycastIp) {
+		return nil
+	}
 
 	if err := m.k8sClient.Patch(context.Background(), node, client.RawPatch(types.MergePatchType, patchData)); err != nil {
 		klog.Errorf("patch to remove taint of node %s failed, err: %v", node.Name, err)
 		return err
 	}
 
+	if hasTaint {
+		metrics.NodeUntaintDuration.Observe(time.Since(node.CreationTimestamp.Time).Seconds())
+	}
 	klog.V(2).Infof("remove anycast taint for node %s success", node.Name)
 	return nil
 }
@@ -0,0 +1,160 @@
+package aia
+
+import (
+	"context"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// isMigrating returns whether the binding has a migration that has not reached the allocating step
+func isMigrating(binding *aiav1alpha1.NodeAddressBinding) bool {
+	m := binding.Status.Migration
+	return m != nil && (m.Step == aiav1alpha1.MigrationStepDisassociating || m.Step == aiav1alpha1.MigrationStepReleasing)
+}
+
+// startMigration records the migration of the address of the node to another type in the binding, then runs it.
+// The node has been tainted by IsCvmNeedToAllocateAnyCastIp.
+func (r *reconciler) startMigration(ctx context.Context, node *corev1.Node, binding *aiav1alpha1.NodeAddressBinding,
+	conflict *addressTypeConflictError) error {
+	addr := conflict.address
+	// an address allocated by aia-ip-controller would be found by its tags and associated again if it was kept,
+	// a static address to detach is detached instead and never released
+	release := (r.Config().Aia.ReleaseMigratedAddress || r.isOwnedAddress(addr)) && !isStaticDetachAddress(addr)
+	now := metav1.Now()
+	if err := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+		status.Phase = aiav1alpha1.BindingPhaseMigrating
+		status.AddressID = ""
+		status.AddressIP = ""
+		status.AddressType = ""
+		status.Bandwidth = 0
+		status.Pool = ""
+		status.LastError = ""
+		status.BoundTime = nil
+		status.Migration = &aiav1alpha1.AddressMigration{
+			Step:               aiav1alpha1.MigrationStepDisassociating,
+			FromAddressID:      stringValue(addr.AddressId),
+			FromAddressIP:      stringValue(addr.AddressIp),
+			FromAddressType:    stringValue(addr.AddressType),
+			ToAddressType:      conflict.addressType,
+			ReleaseFromAddress: release,
+			StartTime:          &now,
+		}
+	}); err != nil {
+		return err
+	}
+	klog.Infof("start migrating %s %s of node %s to %s, release it: %v", stringValue(addr.AddressType),
+		stringValue(addr.AddressId), node.Name, conflict.addressType, release)
+	r.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpMigrating, "Migrating %s %s/%s to %s",
+		stringValue(addr.AddressType), stringValue(addr.AddressId), stringValue(addr.AddressIp), conflict.addressType)
+	return r.continueMigration(ctx, node, binding)
+}
+
+// continueMigration runs the steps of the migration recorded in the binding until the old address is gone,
+// the new address is then allocated and associated as usual
+func (r *reconciler) continueMigration(ctx context.Context, node *corev1.Node, binding *aiav1alpha1.NodeAddressBinding) error {
+	for isMigrating(binding) {
+		m := binding.Status.Migration
+		addr, err := r.AiaManger.DescribeAnycastIp(m.FromAddressID)
+		if err != nil {
+			return err
+		}
+
+		next := aiav1alpha1.MigrationStepAllocating
+		switch m.Step {
+		case aiav1alpha1.MigrationStepDisassociating:
+			if err := r.removeAddressAnnotations(ctx, node); err != nil {
+				return err
+			}
+			if addr != nil && addr.InstanceId != nil && *addr.InstanceId != "" && *addr.InstanceId != binding.Spec.InstanceID {
+				klog.Warningf("migrated address %s of node %s is bound to another instance %s, leave it", m.FromAddressID,
+					node.Name, *addr.InstanceId)
+				addr = nil
+			}
+			if err := r.disassociateMigratedAddress(addr); err != nil {
+				return err
+			}
+			if addr != nil && isStaticDetachAddress(addr) {
+				if err := r.AiaManger.DetachAnycastIp(m.FromAddressID); err != nil {
+					return err
+				}
+				klog.Infof("migrated static anycast ip %s of node %s is detached instead of released", m.FromAddressID, node.Name)
+				break
+			}
+			if m.ReleaseFromAddress && addr != nil {
+				next = aiav1alpha1.MigrationStepReleasing
+			}
+		case aiav1alpha1.MigrationStepReleasing:
+			if addr != nil && !isStaticDetachAddress(addr) {
+				if err := r.AiaManger.ReleaseAnycastIp(m.FromAddressID); err != nil {
+					return err
+				}
+			}
+		}
+
+		if err := r.updateBindingStatus(ctx, binding, func(status *aiav1alpha1.NodeAddressBindingStatus) {
+			status.Migration.Step = next
+		}); err != nil {
+			return err
+		}
+		klog.Infof("migration of node %s moved to step %s", node.Name, next)
+	}
+	return nil
+}
+
+// disassociateMigratedAddress returns nil once the old address is no longer associated with the node
+func (r *reconciler) disassociateMigratedAddress(addr *vpc.Address) error {
+	if addr == nil {
+		return nil
+	}
+	id := stringValue(addr.AddressId)
+	if stringValue(addr.AddressStatus) == constants.AnycastStatusUnBind {
+		return nil
+	}
+	// DisassociateAnycastIp returns an operationInProgressError until the address is UNBIND
+	return r.AiaManger.DisassociateAnycastIp(id)
+}
+
+// isStaticDetachAddress returns whether the address is a static address whose release policy is Detach
+func isStaticDetachAddress(addr *vpc.Address) bool {
+	return vpcTagMap(addr.TagSet)[constants.AiaStaticPolicyAnnoKey] == config.StaticReleasePolicyDetach
+}
+
+// isOwnedAddress returns whether the address was allocated by aia-ip-controller of this cluster
+func (r *reconciler) isOwnedAddress(addr *vpc.Address) bool {
+	for _, t := range addr.TagSet {
+		if t != nil && stringValue(t.Key) == constants.AiaIpControllerClusterUuidAnnoKey && stringValue(t.Value) == r.clusterUuid {
+			return true
+		}
+	}
+	return false
+}
+
+// removeAddressAnnotations removes the anycast ip annotations of the node, so that the new address is annotated
+func (r *reconciler) removeAddressAnnotations(ctx context.Context, node *corev1.Node) error {
+	patch := client.MergeFromWithOptions(node.DeepCopy(), client.MergeFromWithOptimisticLock{})
+	changed := false
+	for _, key := range []string{constants.AnycastIpIdAnnotationKey, constants.AnycastIpIpAnnotationKey} {
+		if _, ok := node.Annotations[key]; ok {
+			delete(node.Annotations, key)
+			changed = true
+		}
+	}
+	if !changed {
+		return nil
+	}
+	if r.dryRun.skip(node, "PatchNode", "remove anycast ip annotations") {
+		return nil
+	}
+	if err := r.k8sClient.Patch(ctx, node, patch); err != nil {
+		klog.Errorf("remove anycast ip annotations of node %s failed, err: %v", node.Name, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,154 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// createNodeWithEip creates an aia node which already has a plain EIP, and returns the id of the EIP
+func (tc *testContext) createNodeWithEip() (*corev1.Node, string) {
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	eipType := constants.EipTypeCommon
+	return node, tc.cloud.AddAddress(vpc.Address{AddressType: &eipType, InstanceId: &insId}, nil)
+}
+
+func TestReconcileMigratesAddressType(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.TypeConflictPolicy = config.TypeConflictPolicyMigrate
+	node, eipId := tc.createNodeWithEip()
+
+	tc.reconcileUntilDone(node.Name)
+
+	binding := tc.getBinding(node.Name)
+	if binding.Status.Phase != aiav1alpha1.BindingPhaseBound || binding.Status.AddressType != constants.EipTypeAnyCast {
+		t.Fatalf("expect node bound to an %s, got %+v", constants.EipTypeAnyCast, binding.Status)
+	}
+	if m := binding.Status.Migration; m == nil || m.Step != aiav1alpha1.MigrationStepCompleted || m.FromAddressID != eipId {
+		t.Errorf("expect completed migration from %s, got %+v", eipId, m)
+	}
+	updated := tc.getNode(node.Name)
+	if got := updated.Annotations[constants.AnycastIpIdAnnotationKey]; got != binding.Status.AddressID {
+		t.Errorf("expect node annotated with new address %s, got %s", binding.Status.AddressID, got)
+	}
+	if hasNoAiaTaint(updated) {
+		t.Errorf("expect node untainted after migration")
+	}
+	// the EIP was not allocated by aia-ip-controller, and releaseMigratedAddress is not set
+	if eip, ok := tc.cloud.GetAddress(eipId); !ok || *eip.AddressStatus != cloudfake.AddressStatusUnbind {
+		t.Errorf("expect old address kept unbound, got %+v", eip)
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeNormal, constants.AnycastIpMigrated) {
+		t.Errorf("expect a %s event", constants.AnycastIpMigrated)
+	}
+}
+
+func TestReconcileResumesInterruptedMigration(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.TypeConflictPolicy = config.TypeConflictPolicyMigrate
+	tc.r.Config().Aia.ReleaseMigratedAddress = true
+	node, eipId := tc.createNodeWithEip()
+	tc.cloud.InjectError(cloudfake.ActionReleaseAddresses, cloudfake.NewError(cloudfake.ErrCodeInvalidParameterValue, "boom"))
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	for i := 0; i < reconcileLimit && tc.cloud.Calls(cloudfake.ActionReleaseAddresses) == 0; i++ {
+		_, _ = tc.r.Reconcile(context.TODO(), req)
+	}
+	if m := tc.getBinding(node.Name).Status.Migration; m == nil || m.Step != aiav1alpha1.MigrationStepReleasing {
+		t.Fatalf("expect migration stopped at step %s, got %+v", aiav1alpha1.MigrationStepReleasing, m)
+	}
+
+	// even if the type rule changed meanwhile, the recorded migration goes on
+	tc.r.Config().Aia.TypeConflictPolicy = config.TypeConflictPolicyWarn
+	tc.reconcileUntilDone(node.Name)
+
+	if _, ok := tc.cloud.GetAddress(eipId); ok {
+		t.Errorf("expect old address %s released", eipId)
+	}
+	if binding := tc.getBinding(node.Name); binding.Status.Phase != aiav1alpha1.BindingPhaseBound {
+		t.Errorf("expect node bound after migration resumed, got %+v", binding.Status)
+	}
+}
+
+// createNodeWithStaticAddress creates an aia node bound to a static anycast ip with release policy Detach, and
+// returns the id of the address
+func (tc *testContext) createNodeWithStaticAddress() (*corev1.Node, string) {
+	eipType := constants.EipTypeAnyCast
+	id := tc.cloud.AddAddress(vpc.Address{AddressType: &eipType}, map[string]string{"contract": "fixed"})
+	node := tc.createAnnotatedNode(map[string]string{constants.AnycastIpStaticIdAnnotationKey: id})
+	tc.reconcileUntilDone(node.Name)
+	if got := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got != id {
+		tc.t.Fatalf("expect node %s bound to static anycast ip %s, got %s", node.Name, id, got)
+	}
+	return node, id
+}
+
+func TestReconcileKeepsStaticAddressOfAnotherType(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.TypeConflictPolicy = config.TypeConflictPolicyMigrate
+	tc.r.Config().Aia.ReleaseMigratedAddress = true
+	node, id := tc.createNodeWithStaticAddress()
+	tc.annotateNode(node.Name, constants.AnycastIpTypeAnnotationKey, constants.EipTypeHighQualityEIP)
+
+	tc.reconcileUntilDone(node.Name)
+
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != 0 {
+		t.Errorf("expect no address allocated for node with a static anycast ip, got %d AllocateAddresses calls", n)
+	}
+	if addr, ok := tc.cloud.GetAddress(id); !ok || stringValue(addr.AddressStatus) != constants.AnycastStatusBIND {
+		t.Errorf("expect static anycast ip %s kept bound, got %+v", id, addr)
+	}
+	if m := tc.getBinding(node.Name).Status.Migration; m != nil {
+		t.Errorf("expect no migration of a static anycast ip, got %+v", m)
+	}
+}
+
+func TestReconcileMigratesStaticAddressByDetaching(t *testing.T) {
+	tc := newTestContext(t)
+	tc.r.Config().Aia.TypeConflictPolicy = config.TypeConflictPolicyMigrate
+	tc.r.Config().Aia.ReleaseMigratedAddress = true
+	node, id := tc.createNodeWithStaticAddress()
+	// the static address is no longer assigned, the node migrates to another type
+	updated := tc.getNode(node.Name)
+	delete(updated.Annotations, constants.AnycastIpStaticIdAnnotationKey)
+	if err := tc.k8sClient.Update(context.TODO(), updated); err != nil {
+		t.Fatalf("remove static anycast ip annotation of node %s failed: %v", node.Name, err)
+	}
+	tc.annotateNode(node.Name, constants.AnycastIpTypeAnnotationKey, constants.EipTypeHighQualityEIP)
+
+	tc.reconcileUntilDone(node.Name)
+
+	binding := tc.getBinding(node.Name)
+	if binding.Status.Phase != aiav1alpha1.BindingPhaseBound || binding.Status.AddressType != constants.EipTypeHighQualityEIP {
+		t.Fatalf("expect node bound to an %s, got %+v", constants.EipTypeHighQualityEIP, binding.Status)
+	}
+	if m := binding.Status.Migration; m == nil || m.FromAddressID != id || m.ReleaseFromAddress {
+		t.Errorf("expect migration from static anycast ip %s without release, got %+v", id, m)
+	}
+	tc.cloud.Settle()
+	addr, ok := tc.cloud.GetAddress(id)
+	if !ok {
+		t.Fatalf("expect static anycast ip %s detached rather than released", id)
+	}
+	if stringValue(addr.AddressStatus) != constants.AnycastStatusUnBind {
+		t.Errorf("expect static anycast ip %s unbound, got %s", id, stringValue(addr.AddressStatus))
+	}
+	tags := tc.cloud.ResourceTags(id)
+	for _, k := range reservedTagKeys {
+		if _, ok := tags[k]; ok {
+			t.Errorf("expect tag %s removed from detached anycast ip %s, got %v", k, id, tags)
+		}
+	}
+	if tags["contract"] != "fixed" {
+		t.Errorf("expect tags of the user kept on anycast ip %s, got %v", id, tags)
+	}
+}
@@ -0,0 +1,133 @@
+package aia
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// maxAddressNameLength is the longest address name accepted by vpc api
+const maxAddressNameLength = 128
+
+// reservedTagKeys are set by aia-ip-controller to track the owner of an address, they cannot be overridden
+var reservedTagKeys = []string{
+	constants.AiaIpControllerClusterUuidAnnoKey,
+	constants.AiaIpControllerClusterIdAnnoKey,
+	constants.AiaNodeNameAnnoKey,
+	constants.AiaNodeInsIdAnnoKey,
+	constants.AiaPoolNameAnnoKey,
+	constants.AiaWarmPoolAnnoKey,
+	constants.AiaWarmSpecAnnoKey,
+	constants.AiaNodeIdentityAnnoKey,
+	constants.AiaRetainedSinceAnnoKey,
+	constants.AiaStaticPolicyAnnoKey,
+	constants.AiaPodNameAnnoKey,
+}
+
+// nodeOverride applies the value of a node annotation to spec, or returns why the value is invalid
+type nodeOverride struct {
+	annotation string
+	apply      func(value string, spec *AddressSpec) error
+}
+
+var nodeOverrides = []nodeOverride{
+	{annotation: constants.AnycastIpBandwidthAnnotationKey, apply: overrideBandwidth},
+	{annotation: constants.AnycastIpTypeAnnotationKey, apply: overrideAddressType},
+	{annotation: constants.AnycastIpZoneAnnotationKey, apply: overrideAnycastZone},
+	{annotation: constants.AnycastIpTagsAnnotationKey, apply: overrideTags},
+	{annotation: constants.AnycastIpNameAnnotationKey, apply: overrideAddressName},
+	{annotation: constants.AnycastIpStaticIdAnnotationKey, apply: overrideStaticAddressId},
+	{annotation: constants.AnycastIpStaticReleasePolicyAnnotationKey, apply: overrideStaticReleasePolicy},
+}
+
+// applyNodeOverrides overrides spec with the annotations of the node, an invalid annotation is reported as
+// a warning event and ignored, so that the node still gets an address of the pool or config
+func (r *reconciler) applyNodeOverrides(node *corev1.Node, spec *AddressSpec) {
+	for _, o := range nodeOverrides {
+		value, ok := node.Annotations[o.annotation]
+		if !ok {
+			continue
+		}
+		if err := o.apply(value, spec); err != nil {
+			klog.Warningf("node %s has invalid annotation %s: %q, err: %v", node.Name, o.annotation, value, err)
+			r.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.InvalidNodeAnnotation,
+				"Invalid annotation %s: %q, %v", o.annotation, value, err)
+		}
+	}
+}
+
+func overrideBandwidth(value string, spec *AddressSpec) error {
+	bandwidth, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || bandwidth < 1 {
+		return fmt.Errorf("it should be a positive integer")
+	}
+	spec.Bandwidth = bandwidth
+	return nil
+}
+
+func overrideAddressType(value string, spec *AddressSpec) error {
+	if value != constants.EipTypeAnyCast && value != constants.EipTypeHighQualityEIP && value != constants.EipTypeCommon {
+		return fmt.Errorf("it should be %s, %s or %s", constants.EipTypeAnyCast, constants.EipTypeHighQualityEIP,
+			constants.EipTypeCommon)
+	}
+	spec.AddressType = value
+	return nil
+}
+
+func overrideAnycastZone(value string, spec *AddressSpec) error {
+	if value != constants.AnycastZoneOverseas && value != constants.AnycastZoneGlobal {
+		return fmt.Errorf("it should be %s or %s", constants.AnycastZoneOverseas, constants.AnycastZoneGlobal)
+	}
+	spec.AnycastZone = value
+	return nil
+}
+
+// overrideTags parses comma separated key=value pairs, no tag is added if any of them is invalid
+func overrideTags(value string, spec *AddressSpec) error {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(value, ",") {
+		kv := strings.SplitN(strings.TrimSpace(pair), "=", 2)
+		if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+			return fmt.Errorf("%q should be key=value", pair)
+		}
+		for _, reserved := range reservedTagKeys {
+			if kv[0] == reserved {
+				return fmt.Errorf("tag key %s is reserved", reserved)
+			}
+		}
+		tags[kv[0]] = kv[1]
+	}
+	for k, v := range tags {
+		spec.Tags[k] = v
+	}
+	return nil
+}
+
+func overrideAddressName(value string, spec *AddressSpec) error {
+	if value == "" || len(value) > maxAddressNameLength {
+		return fmt.Errorf("it should have 1 to %d characters", maxAddressNameLength)
+	}
+	spec.AddressName = value
+	return nil
+}
+
+func overrideStaticAddressId(value string, spec *AddressSpec) error {
+	if !strings.HasPrefix(value, constants.AnycastIdPrefix) {
+		return fmt.Errorf("it should be an address id starting with %s", constants.AnycastIdPrefix)
+	}
+	spec.StaticAddressID = value
+	return nil
+}
+
+func overrideStaticReleasePolicy(value string, spec *AddressSpec) error {
+	if !config.IsStaticReleasePolicy(value) {
+		return fmt.Errorf("it should be %s or %s", config.StaticReleasePolicyDetach, config.StaticReleasePolicyRelease)
+	}
+	spec.StaticReleasePolicy = value
+	return nil
+}
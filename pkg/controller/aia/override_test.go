@@ -0,0 +1,67 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// createAnnotatedNode creates an aia node with annotations
+func (tc *testContext) createAnnotatedNode(annotations map[string]string) *corev1.Node {
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	node.Annotations = annotations
+	if err := tc.k8sClient.Update(context.TODO(), node); err != nil {
+		tc.t.Fatalf("annotate node %s failed: %v", node.Name, err)
+	}
+	return node
+}
+
+func TestReconcileAppliesNodeOverrides(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createAnnotatedNode(map[string]string{
+		constants.AnycastIpTypeAnnotationKey: constants.EipTypeHighQualityEIP,
+		constants.AnycastIpTagsAnnotationKey: "flavor=large, rack=a1",
+		constants.AnycastIpNameAnnotationKey: "edge-large",
+	})
+	tc.reconcileUntilDone(node.Name)
+
+	addrs := tc.cloud.ListAddresses()
+	if len(addrs) != 1 {
+		t.Fatalf("expect 1 address, got %d", len(addrs))
+	}
+	if got := *addrs[0].AddressType; got != constants.EipTypeHighQualityEIP {
+		t.Errorf("expect address type %s, got %s", constants.EipTypeHighQualityEIP, got)
+	}
+	if got := *addrs[0].AddressName; got != "edge-large" {
+		t.Errorf("expect address name edge-large, got %s", got)
+	}
+	tags := tc.cloud.ResourceTags(*addrs[0].AddressId)
+	if tags["flavor"] != "large" || tags["rack"] != "a1" || tags["team"] != "edge" {
+		t.Errorf("expect annotation tags added to config tags, got %v", tags)
+	}
+}
+
+func TestReconcileIgnoresInvalidNodeOverrides(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createAnnotatedNode(map[string]string{
+		constants.AnycastIpTypeAnnotationKey: "WanIP",
+		constants.AnycastIpTagsAnnotationKey: constants.AiaNodeNameAnnoKey + "=other",
+	})
+	tc.reconcileUntilDone(node.Name)
+
+	addrs := tc.cloud.ListAddresses()
+	if len(addrs) != 1 {
+		t.Fatalf("expect 1 address despite invalid annotations, got %d", len(addrs))
+	}
+	if got := *addrs[0].AddressType; got != constants.EipTypeAnyCast {
+		t.Errorf("expect address type %s from config, got %s", constants.EipTypeAnyCast, got)
+	}
+	if got := tc.cloud.ResourceTags(*addrs[0].AddressId)[constants.AiaNodeNameAnnoKey]; got != node.Name {
+		t.Errorf("expect reserved tag kept as %s, got %s", node.Name, got)
+	}
+	if !hasEvent(tc.events(), corev1.EventTypeWarning, constants.InvalidNodeAnnotation) {
+		t.Errorf("expect a %s warning event", constants.InvalidNodeAnnotation)
+	}
+}
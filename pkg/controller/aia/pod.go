@@ -0,0 +1,363 @@
+package aia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/client-go/tools/cache"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+// podAddressSyncPeriod is how often the addresses of pods that no longer exist are looked for
+const podAddressSyncPeriod = 10 * time.Minute
+
+// podKey is the value of the pod name tag of the address of the pod
+func podKey(pod *corev1.Pod) string {
+	return pod.Namespace + "/" + pod.Name
+}
+
+// wantsAnycastIp returns whether the pod asks for an anycast ip
+func wantsAnycastIp(pod *corev1.Pod) bool {
+	return pod.Annotations[constants.PodAnycastIpAnnotationKey] == "true"
+}
+
+// isPodTerminated returns whether the containers of the pod will not run again
+func isPodTerminated(pod *corev1.Pod) bool {
+	return pod.Status.Phase == corev1.PodSucceeded || pod.Status.Phase == corev1.PodFailed
+}
+
+// GetPodAnycastIpByTags returns the address of the cluster tagged with the pod key namespace/name
+func (m *MangerImp) GetPodAnycastIpByTags(podKey string) (bool, string, error) {
+	return m.getAnycastIpByTag(constants.AiaPodNameAnnoKey, podKey)
+}
+
+// ListPodAnycastIps returns the addresses of the cluster that have the aia-pod-name tag
+func (m *MangerImp) ListPodAnycastIps() ([]*vpc.Address, error) {
+	return m.listTaggedAddresses(constants.AiaPodNameAnnoKey)
+}
+
+// AllocatePodAnycastIp returns the address of the pod, a new one is allocated if it has none
+func (m *MangerImp) AllocatePodAnycastIp(pod *corev1.Pod, spec *AddressSpec) (_ string, err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("AllocatePodAnycastIp", start, err) }(time.Now())
+	found, anycastId, err := m.GetPodAnycastIpByTags(podKey(pod))
+	if err != nil || found {
+		return anycastId, err
+	}
+
+	tags := m.specTags(spec)
+	tags[constants.AiaPodNameAnnoKey] = podKey(pod)
+	if m.dryRun.skip(pod, "AllocateAddresses", "allocate %s with bandwidth %d and tags %v", spec.AddressType,
+		spec.Bandwidth, tags) {
+		return "", errDryRun
+	}
+	resp, err := m.vpcClient.AllocateAddresses(newAllocateRequest(spec, tags))
+	if err != nil {
+		klog.Warningf("allocate address for pod %s failed, err: %v", podKey(pod), err)
+		// vpc api does not create the tags, create them and let the next round allocate again
+		if tagErr := m.createTags(pod, tags); tagErr != nil {
+			m.eventRecorder.Eventf(pod, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp,
+				"Failed to allocate anycast ip (will retry): %s", clouderrors.Describe(err))
+			return "", fmt.Errorf("AllocateAddresses failed: %v. CreateTag failed: %v", err, tagErr)
+		}
+		return "", err
+	}
+	if resp == nil || resp.Response == nil || len(resp.Response.AddressSet) != 1 {
+		return "", fmt.Errorf("allocate anycast ip for pod %s has no or more than one address", podKey(pod))
+	}
+	m.async.start(asyncAllocate, *resp.Response.AddressSet[0], stringValue(resp.Response.TaskId))
+	return *resp.Response.AddressSet[0], nil
+}
+
+// AssociatePodAnycastIp associates the address with the eni ip of the pod, and annotates the pod once it is bound
+func (m *MangerImp) AssociatePodAnycastIp(pod *corev1.Pod, anycastIpId string) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("AssociatePodAnycastIp", start, err) }(time.Now())
+	podIp := pod.Status.PodIP
+	eniId, err := m.getNetworkInterfaceOfIp(podIp)
+	if err != nil {
+		return err
+	}
+	if eniId == "" {
+		m.eventRecorder.Eventf(pod, corev1.EventTypeWarning, constants.FailedAssociateAnycastIP,
+			"No eni has the pod ip %s, is the pod on vpc-cni networking?", podIp)
+		return fmt.Errorf("no eni has the ip %s of pod %s", podIp, podKey(pod))
+	}
+	addr, err := m.DescribeAnycastIp(anycastIpId)
+	if err != nil {
+		return err
+	}
+	if addr == nil {
+		return fmt.Errorf("anycast ip %s of pod %s not found", anycastIpId, podKey(pod))
+	}
+
+	status := stringValue(addr.AddressStatus)
+	if err := m.waitAsync(anycastIpId, status); err != nil {
+		return err
+	}
+	switch status {
+	case constants.AnycastStatusBIND:
+		if stringValue(addr.NetworkInterfaceId) == eniId && stringValue(addr.PrivateAddressIp) == podIp {
+			return m.annotatePodAnycastIp(pod, anycastIpId, stringValue(addr.AddressIp))
+		}
+		m.eventRecorder.Eventf(pod, corev1.EventTypeWarning, constants.FailedAssociateAnycastIP,
+			"anycast ip %s has associate to another resource(%s%s)", anycastIpId, stringValue(addr.InstanceId),
+			stringValue(addr.PrivateAddressIp))
+		return fmt.Errorf("anycast ip %s of pod %s is associated with another resource", anycastIpId, podKey(pod))
+	case constants.AnycastStatusUnBind:
+		req := vpc.NewAssociateAddressRequest()
+		req.AddressId = common.StringPtr(anycastIpId)
+		req.NetworkInterfaceId = common.StringPtr(eniId)
+		req.PrivateIpAddress = common.StringPtr(podIp)
+		if m.dryRun.skip(pod, "AssociateAddress", "associate anycast ip %s with %s of eni %s", anycastIpId, podIp, eniId) {
+			return errDryRun
+		}
+		resp, err := m.vpcClient.AssociateAddress(req)
+		if err != nil {
+			return err
+		}
+		if resp == nil || resp.Response == nil {
+			return fmt.Errorf("AssociateAddress for anycast ip %s of pod %s has no response", anycastIpId, podKey(pod))
+		}
+		klog.V(2).Infof("call vpc api to associate anycast ip %s with %s of eni %s success, taskId %s",
+			anycastIpId, podIp, eniId, stringValue(resp.Response.TaskId))
+		return m.startAsync(asyncAssociate, anycastIpId, stringValue(resp.Response.TaskId), status)
+	default:
+		return fmt.Errorf("anycast ip %s has unexpected status %s", anycastIpId, status)
+	}
+}
+
+// getNetworkInterfaceOfIp returns the eni that has the private ip, empty if none does
+func (m *MangerImp) getNetworkInterfaceOfIp(privateIp string) (string, error) {
+	req := vpc.NewDescribeNetworkInterfacesRequest()
+	req.Filters = []*vpc.Filter{
+		{Name: common.StringPtr("address-ip"), Values: common.StringPtrs([]string{privateIp})},
+		{Name: common.StringPtr("ip-exact-match"), Values: common.StringPtrs([]string{"true"})},
+	}
+	resp, err := m.vpcClient.DescribeNetworkInterfaces(req)
+	if err != nil {
+		klog.Errorf("DescribeNetworkInterfaces of ip %s failed, err: %v", privateIp, err)
+		return "", err
+	}
+	if resp == nil || resp.Response == nil {
+		return "", fmt.Errorf("DescribeNetworkInterfaces of ip %s has no response", privateIp)
+	}
+	for _, eni := range resp.Response.NetworkInterfaceSet {
+		for _, ip := range eni.PrivateIpAddressSet {
+			if ip != nil && stringValue(ip.PrivateIpAddress) == privateIp {
+				return stringValue(eni.NetworkInterfaceId), nil
+			}
+		}
+	}
+	return "", nil
+}
+
+// annotatePodAnycastIp records the address in the anycast ip annotations of the pod
+func (m *MangerImp) annotatePodAnycastIp(pod *corev1.Pod, anycastId, anycastIp string) error {
+	if pod.Annotations[constants.AnycastIpIdAnnotationKey] == anycastId &&
+		pod.Annotations[constants.AnycastIpIpAnnotationKey] == anycastIp {
+		return nil
+	}
+	if m.dryRun.skip(pod, "PatchPod", "annotate anycast ip %s/%s", anycastId, anycastIp) {
+		return nil
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	if pod.Annotations == nil {
+		pod.Annotations = map[string]string{}
+	}
+	pod.Annotations[constants.AnycastIpIdAnnotationKey] = anycastId
+	pod.Annotations[constants.AnycastIpIpAnnotationKey] = anycastIp
+	if err := m.k8sClient.Patch(context.Background(), pod, patch); err != nil {
+		klog.Errorf("annotate anycast ip of pod %s failed, err: %v", podKey(pod), err)
+		return err
+	}
+	klog.Infof("anycast ip %s/%s bound to pod %s", anycastId, anycastIp, podKey(pod))
+	return nil
+}
+
+// podReconciler binds anycast ips to the eni ips of pods on vpc-cni networking that ask for one,
+// and releases them when the pods terminate
+type podReconciler struct {
+	*reconciler
+}
+
+// PodReconciler returns the reconciler of pods, it shares the manager and config of the node reconciler
+func (r *reconciler) PodReconciler() reconcile.Reconciler {
+	return &podReconciler{reconciler: r}
+}
+
+func (r *podReconciler) Reconcile(ctx context.Context, req ctrl.Request) (res ctrl.Result, err error) {
+	defer func() {
+		if errors.Is(err, errDryRun) {
+			err = nil
+		}
+		if isInProgress(err) {
+			klog.V(2).Infof("waiting for cloud operation of pod %s: %v", req.NamespacedName, err)
+			res, err = ctrl.Result{RequeueAfter: r.asyncPollInterval}, nil
+		}
+		if interval := retryInterval(err); interval > 0 {
+			klog.Errorf("reconcile pod %s failed, will retry after %s, err: %v", req.NamespacedName, interval, err)
+			res, err = ctrl.Result{RequeueAfter: interval}, nil
+		}
+	}()
+
+	pod := &corev1.Pod{}
+	err = r.k8sClient.Get(ctx, req.NamespacedName, pod)
+	if apierrors.IsNotFound(err) {
+		return ctrl.Result{}, r.releasePodAddress(ctx, req.NamespacedName, nil)
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("could not fetch pod %s: %+v", req.NamespacedName, err)
+	}
+	if !wantsAnycastIp(pod) || pod.DeletionTimestamp != nil || isPodTerminated(pod) {
+		return ctrl.Result{}, r.releasePodAddress(ctx, req.NamespacedName, pod)
+	}
+	if pod.Spec.HostNetwork {
+		r.eventRecorder.Eventf(pod, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp,
+			"Pod on host network cannot have its own anycast ip, annotate the node instead")
+		return ctrl.Result{}, nil
+	}
+	if pod.Status.PodIP == "" {
+		klog.V(2).Infof("pod %s has no ip yet, wait for it", req.NamespacedName)
+		return ctrl.Result{}, nil
+	}
+
+	anycastId, err := r.AiaManger.AllocatePodAnycastIp(pod, r.defaultAddressSpec(nil))
+	if err != nil {
+		klog.Errorf("AllocatePodAnycastIp for pod %s failed, err: %v", req.NamespacedName, err)
+		return ctrl.Result{}, err
+	}
+	if err := r.AiaManger.AssociatePodAnycastIp(pod, anycastId); err != nil {
+		if !isInProgress(err) {
+			klog.Errorf("AssociatePodAnycastIp %s for pod %s failed, err: %v", anycastId, req.NamespacedName, err)
+		}
+		return ctrl.Result{}, err
+	}
+	return ctrl.Result{}, nil
+}
+
+// releasePodAddress disassociates and releases the address of a pod that is gone, terminated or no longer asks
+// for one, pod is nil if it is gone. The address is always looked up by its tags, since the pod may have lost
+// its annotations before it was annotated with the address. The anycast ip annotations of the pod are removed
+// afterwards.
+func (r *podReconciler) releasePodAddress(ctx context.Context, key types.NamespacedName, pod *corev1.Pod) error {
+	found, anycastId, err := r.AiaManger.GetPodAnycastIpByTags(key.String())
+	if err != nil {
+		klog.Errorf("GetPodAnycastIpByTags of pod %s failed, err: %v", key, err)
+		return err
+	}
+	if found {
+		if err := r.AiaManger.DisassociateAnycastIp(anycastId); err != nil {
+			if !isInProgress(err) {
+				klog.Errorf("DisassociateAnycastIp %s failed, err: %v", anycastId, err)
+			}
+			return err
+		}
+		if err := r.AiaManger.ReleaseAnycastIp(anycastId); err != nil {
+			klog.Errorf("ReleaseAnycastIp %s failed, err: %v", anycastId, err)
+			return err
+		}
+		klog.Infof("anycast ip %s of pod %s released", anycastId, key)
+		if pod != nil {
+			r.eventRecorder.Eventf(pod, corev1.EventTypeNormal, constants.AnycastIpReleased, "Anycast ip %s released", anycastId)
+		}
+	}
+	if pod == nil || pod.Annotations[constants.AnycastIpIdAnnotationKey] == "" {
+		return nil
+	}
+
+	if r.dryRun.skip(pod, "PatchPod", "remove anycast ip annotations") {
+		return nil
+	}
+	patch := client.MergeFrom(pod.DeepCopy())
+	delete(pod.Annotations, constants.AnycastIpIdAnnotationKey)
+	delete(pod.Annotations, constants.AnycastIpIpAnnotationKey)
+	if err := r.k8sClient.Patch(ctx, pod, patch); err != nil && !apierrors.IsNotFound(err) {
+		klog.Errorf("remove anycast ip annotations of pod %s failed, err: %v", key, err)
+		return err
+	}
+	return nil
+}
+
+// PodPredicate passes the events of pods that ask for an anycast ip or still have one, and the deletion of pods
+// that ever did
+func (r *reconciler) PodPredicate() predicate.Funcs {
+	relevant := func(obj client.Object) bool {
+		pod, ok := obj.(*corev1.Pod)
+		if !ok || (!wantsAnycastIp(pod) && pod.Annotations[constants.AnycastIpIdAnnotationKey] == "") {
+			return false
+		}
+		r.anycastPods.Store(podKey(pod), struct{}{})
+		return true
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return relevant(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool { return relevant(e.ObjectOld) || relevant(e.ObjectNew) },
+		DeleteFunc: func(e event.DeleteEvent) bool {
+			pod, ok := e.Object.(*corev1.Pod)
+			if !ok {
+				return false
+			}
+			_, seen := r.anycastPods.LoadAndDelete(podKey(pod))
+			return seen || relevant(pod)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// ReleaseOrphanedPodAddresses releases the addresses of pods that are gone or no longer ask for one, e.g. those
+// whose deletion was missed while aia-ip-controller was down
+func (r *reconciler) ReleaseOrphanedPodAddresses(ctx context.Context) {
+	addrs, err := r.AiaManger.ListPodAnycastIps()
+	if err != nil {
+		klog.Errorf("list anycast ips of pods failed, err: %v", err)
+		return
+	}
+	pr := &podReconciler{reconciler: r}
+	for _, addr := range addrs {
+		id := stringValue(addr.AddressId)
+		namespace, name, err := cache.SplitMetaNamespaceKey(vpcTagMap(addr.TagSet)[constants.AiaPodNameAnnoKey])
+		if err != nil || name == "" {
+			klog.Warningf("anycast ip %s has an invalid pod tag, leave it, err: %v", id, err)
+			continue
+		}
+		key := types.NamespacedName{Namespace: namespace, Name: name}
+		pod := &corev1.Pod{}
+		err = r.k8sClient.Get(ctx, key, pod)
+		switch {
+		case apierrors.IsNotFound(err):
+			pod = nil
+		case err != nil:
+			klog.Errorf("get pod %s of anycast ip %s failed, err: %v", key, id, err)
+			continue
+		case wantsAnycastIp(pod) && pod.DeletionTimestamp == nil && !isPodTerminated(pod):
+			continue
+		}
+		klog.Infof("anycast ip %s of pod %s is no longer used, release it", id, key)
+		if err := pr.releasePodAddress(ctx, key, pod); err != nil && !errors.Is(err, errDryRun) && !isInProgress(err) {
+			klog.Errorf("release anycast ip %s of pod %s failed, err: %v", id, key, err)
+		}
+	}
+}
+
+// RunPodAddressSweep releases the addresses of pods that no longer use them periodically until ctx is done, it
+// runs on the leader only
+func (r *reconciler) RunPodAddressSweep(ctx context.Context) {
+	wait.UntilWithContext(ctx, r.ReleaseOrphanedPodAddresses, podAddressSyncPeriod)
+}
@@ -0,0 +1,183 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// createPod creates a pod annotated for an anycast ip with the pod ip in its status
+func (tc *testContext) createPod(podIp string) *corev1.Pod {
+	n := atomic.AddInt32(&nodeCounter, 1)
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        fmt.Sprintf("pod-%d", n),
+			Namespace:   "default",
+			Annotations: map[string]string{constants.PodAnycastIpAnnotationKey: "true"},
+		},
+		Spec: corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "nginx"}}},
+	}
+	if err := tc.k8sClient.Create(context.TODO(), pod); err != nil {
+		tc.t.Fatalf("create pod failed: %v", err)
+	}
+	pod.Status.PodIP = podIp
+	if err := tc.k8sClient.Status().Update(context.TODO(), pod); err != nil {
+		tc.t.Fatalf("update pod status failed: %v", err)
+	}
+	tc.t.Cleanup(func() {
+		_ = tc.k8sClient.Delete(context.TODO(), pod)
+	})
+	return pod
+}
+
+// reconcilePodUntilDone calls Reconcile of the pod reconciler until it returns no error and no requeue
+func (tc *testContext) reconcilePodUntilDone(key types.NamespacedName) {
+	r := tc.r.PodReconciler()
+	for i := 1; i <= reconcileLimit; i++ {
+		res, err := r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: key})
+		if err == nil && !res.Requeue && res.RequeueAfter == 0 {
+			return
+		}
+		tc.t.Logf("reconcile pod %s round %d: result %+v, err %v", key, i, res, err)
+		time.Sleep(time.Millisecond)
+	}
+	tc.t.Fatalf("reconcile pod %s not done after %d rounds", key, reconcileLimit)
+}
+
+func TestReconcilePodBindsAddressToEniIp(t *testing.T) {
+	tc := newTestContext(t)
+	const podIp, eniId = "10.0.0.12", "eni-pod0001"
+	tc.cloud.AddNetworkInterface(eniId, "10.0.0.2", podIp)
+	pod := tc.createPod(podIp)
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+
+	tc.reconcilePodUntilDone(key)
+
+	got := &corev1.Pod{}
+	if err := tc.k8sClient.Get(context.TODO(), key, got); err != nil {
+		t.Fatalf("get pod failed: %v", err)
+	}
+	id := got.Annotations[constants.AnycastIpIdAnnotationKey]
+	addr, ok := tc.cloud.GetAddress(id)
+	if !ok {
+		t.Fatalf("expect pod %s annotated with its anycast ip, got %v", key, got.Annotations)
+	}
+	if stringValue(addr.NetworkInterfaceId) != eniId || stringValue(addr.PrivateAddressIp) != podIp {
+		t.Errorf("expect anycast ip %s bound to %s of %s, got %s of %s", id, podIp, eniId,
+			stringValue(addr.PrivateAddressIp), stringValue(addr.NetworkInterfaceId))
+	}
+	if got.Annotations[constants.AnycastIpIpAnnotationKey] != stringValue(addr.AddressIp) {
+		t.Errorf("expect pod %s annotated with ip %s, got %v", key, stringValue(addr.AddressIp), got.Annotations)
+	}
+	if tags := tc.cloud.ResourceTags(id); tags[constants.AiaPodNameAnnoKey] != key.String() {
+		t.Errorf("expect anycast ip %s tagged for pod %s, got %v", id, key, tags)
+	}
+
+	if err := tc.k8sClient.Delete(context.TODO(), got); err != nil && !errors.IsNotFound(err) {
+		t.Fatalf("delete pod failed: %v", err)
+	}
+	tc.reconcilePodUntilDone(key)
+	if _, ok := tc.cloud.GetAddress(id); ok {
+		t.Errorf("expect anycast ip %s released after pod %s deleted", id, key)
+	}
+}
+
+func TestReconcilePodWithoutEniIp(t *testing.T) {
+	tc := newTestContext(t)
+	pod := tc.createPod("10.0.0.13")
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+
+	// the first round creates the tags of the address
+	for i := 0; i < 3; i++ {
+		if _, err := tc.r.PodReconciler().Reconcile(context.TODO(), ctrl.Request{NamespacedName: key}); err == nil {
+			t.Errorf("expect reconcile round %d of pod %s without eni ip to fail", i, key)
+		}
+	}
+	if !hasEvent(tc.events(), "Warning", constants.FailedAssociateAnycastIP) {
+		t.Errorf("expect event %s", constants.FailedAssociateAnycastIP)
+	}
+}
+
+// allocatePodAddress allocates the address of the pod without associating it, as if the pod changed before it was
+// annotated with the address
+func (tc *testContext) allocatePodAddress(pod *corev1.Pod) string {
+	m := tc.r.AiaManger.(*MangerImp)
+	// the first call creates the tags of the address
+	for i := 0; i < 2; i++ {
+		if id, err := m.AllocatePodAnycastIp(pod, tc.r.defaultAddressSpec(nil)); err == nil {
+			return id
+		}
+	}
+	tc.t.Fatalf("allocate anycast ip for pod %s failed", podKey(pod))
+	return ""
+}
+
+func TestReconcilePodReleasesAddressOfRemovedAnnotation(t *testing.T) {
+	tc := newTestContext(t)
+	pod := tc.createPod("10.0.0.14")
+	key := types.NamespacedName{Namespace: pod.Namespace, Name: pod.Name}
+	id := tc.allocatePodAddress(pod)
+
+	got := &corev1.Pod{}
+	if err := tc.k8sClient.Get(context.TODO(), key, got); err != nil {
+		t.Fatalf("get pod failed: %v", err)
+	}
+	delete(got.Annotations, constants.PodAnycastIpAnnotationKey)
+	if err := tc.k8sClient.Update(context.TODO(), got); err != nil {
+		t.Fatalf("remove annotation of pod %s failed: %v", key, err)
+	}
+	tc.reconcilePodUntilDone(key)
+
+	if _, ok := tc.cloud.GetAddress(id); ok {
+		t.Errorf("expect anycast ip %s released after pod %s no longer asks for it", id, key)
+	}
+}
+
+func TestPodPredicatePassesDeletionOfPodThatAskedForAddress(t *testing.T) {
+	tc := newTestContext(t)
+	p := tc.r.PodPredicate()
+	pod := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default",
+		Annotations: map[string]string{constants.PodAnycastIpAnnotationKey: "true"}}}
+	if !p.Create(event.CreateEvent{Object: pod}) {
+		t.Fatalf("expect creation of pod asking for an anycast ip passed")
+	}
+
+	unannotated := pod.DeepCopy()
+	unannotated.Annotations = nil
+	if !p.Delete(event.DeleteEvent{Object: unannotated}) {
+		t.Errorf("expect deletion of pod that asked for an anycast ip passed")
+	}
+	other := &corev1.Pod{ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"}}
+	if p.Delete(event.DeleteEvent{Object: other}) {
+		t.Errorf("expect deletion of pod that never asked for an anycast ip dropped")
+	}
+}
+
+func TestReleaseOrphanedPodAddresses(t *testing.T) {
+	tc := newTestContext(t)
+	pod := tc.createPod("10.0.0.15")
+	kept := tc.allocatePodAddress(pod)
+	gone := pod.DeepCopy()
+	gone.Name = "gone"
+	orphaned := tc.allocatePodAddress(gone)
+	tc.cloud.Settle()
+
+	tc.r.ReleaseOrphanedPodAddresses(context.TODO())
+
+	if _, ok := tc.cloud.GetAddress(orphaned); ok {
+		t.Errorf("expect anycast ip %s of a pod that no longer exists released", orphaned)
+	}
+	if _, ok := tc.cloud.GetAddress(kept); !ok {
+		t.Errorf("expect anycast ip %s of pod %s kept", kept, podKey(pod))
+	}
+}
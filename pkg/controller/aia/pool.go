@@ -0,0 +1,192 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+)
+
+// AddressSpec describes the address allocated for a node
+type AddressSpec struct {
+	// Pool is the name of the AnycastIPPool the spec comes from, empty if it comes from the controller config
+	Pool        string
+	AddressName string
+	AddressType string
+	// TypeConflictPolicy decides what to do if the node already has an address of another type
+	TypeConflictPolicy string
+	Bandwidth          int64
+	AnycastZone        string
+	InternetChargeType string
+	Tags               map[string]string
+	// WarmPoolSize is how many standby addresses are kept for the pool, the node takes one of them if any
+	WarmPoolSize int
+	// Identity is the value of the sticky identity label of the node, the address is reused by nodes with it
+	Identity string
+	// StaticAddressID is an existing address assigned to the node by the user, it is adopted instead of allocating one
+	StaticAddressID string
+	// StaticReleasePolicy decides whether the static address is detached or released when the node is deleted
+	StaticReleasePolicy string
+	// AdoptionPolicy decides whether an address of the node not allocated by aia-ip-controller is tagged as owned
+	AdoptionPolicy string
+}
+
+// defaultAddressSpec builds the address spec of the node from the aia section of the controller config,
+// node is nil for the spec of the standby addresses
+func (r *reconciler) defaultAddressSpec(node *corev1.Node) *AddressSpec {
+	conf := r.Config()
+	tags := make(map[string]string, len(conf.Aia.Tags))
+	for k, v := range conf.Aia.Tags {
+		tags[k] = v
+	}
+	return &AddressSpec{
+		AddressName:         fmt.Sprintf("%s-aia", r.clusterId),
+		AddressType:         r.addressTypeOfNode(conf, node),
+		TypeConflictPolicy:  conf.Aia.TypeConflictPolicy,
+		Bandwidth:           conf.Aia.Bandwidth,
+		AnycastZone:         conf.Aia.AnycastZone,
+		Tags:                tags,
+		WarmPoolSize:        conf.Aia.WarmPoolSize,
+		Identity:            stickyIdentityOfNode(conf, node),
+		StaticReleasePolicy: staticReleasePolicy(conf),
+		AdoptionPolicy:      conf.Aia.AdoptionPolicy,
+	}
+}
+
+// addressTypeOfNode returns the type of the first type rule selecting the node, or the processing type if none does
+func (r *reconciler) addressTypeOfNode(conf *config.YamlValueConfig, node *corev1.Node) string {
+	if node == nil {
+		return r.AiaManger.ProcessingEipType()
+	}
+	for _, rule := range conf.Aia.TypeRules {
+		selector, err := metav1.LabelSelectorAsSelector(rule.NodeSelector)
+		if err != nil {
+			// validated when the config is loaded
+			continue
+		}
+		if selector.Matches(labels.Set(node.Labels)) {
+			return rule.AddressType
+		}
+	}
+	return r.AiaManger.ProcessingEipType()
+}
+
+// addressSpecOfNode returns the address spec of the pool that selects the node, or the default spec if no pool does,
+// overridden by the annotations of the node
+func (r *reconciler) addressSpecOfNode(ctx context.Context, node *corev1.Node) (*AddressSpec, error) {
+	spec, err := r.poolAddressSpecOfNode(ctx, node)
+	if err != nil {
+		return nil, err
+	}
+	r.applyNodeOverrides(node, spec)
+	return spec, nil
+}
+
+func (r *reconciler) poolAddressSpecOfNode(ctx context.Context, node *corev1.Node) (*AddressSpec, error) {
+	spec := r.defaultAddressSpec(node)
+	if !r.EnableAnycastIPPool {
+		return spec, nil
+	}
+
+	pools := &aiav1alpha1.AnycastIPPoolList{}
+	if err := r.k8sClient.List(ctx, pools); err != nil {
+		klog.Errorf("list anycast ip pools failed, err: %v", err)
+		return nil, err
+	}
+	pool := selectPool(pools.Items, node)
+	if pool == nil {
+		return spec, nil
+	}
+	klog.V(2).Infof("node %s is selected by anycast ip pool %s", node.Name, pool.Name)
+	applyPool(spec, pool)
+	return spec, nil
+}
+
+// applyPool overrides spec with the fields set in the pool
+func applyPool(spec *AddressSpec, pool *aiav1alpha1.AnycastIPPool) {
+	spec.Pool = pool.Name
+	if pool.Spec.AddressType != "" {
+		spec.AddressType = pool.Spec.AddressType
+	}
+	if pool.Spec.Bandwidth > 0 {
+		spec.Bandwidth = pool.Spec.Bandwidth
+	}
+	if pool.Spec.AnycastZone != "" {
+		spec.AnycastZone = pool.Spec.AnycastZone
+	}
+	if pool.Spec.InternetChargeType != "" {
+		spec.InternetChargeType = pool.Spec.InternetChargeType
+	}
+	for k, v := range pool.Spec.Tags {
+		spec.Tags[k] = v
+	}
+	spec.WarmPoolSize = int(pool.Spec.WarmPoolSize)
+}
+
+// selectPool returns the pool with the highest priority that selects the node, ties are broken by name
+func selectPool(pools []aiav1alpha1.AnycastIPPool, node *corev1.Node) *aiav1alpha1.AnycastIPPool {
+	matched := make([]*aiav1alpha1.AnycastIPPool, 0)
+	for i := range pools {
+		if poolSelectsNode(&pools[i], node) {
+			matched = append(matched, &pools[i])
+		}
+	}
+	if len(matched) == 0 {
+		return nil
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if matched[i].Spec.Priority != matched[j].Spec.Priority {
+			return matched[i].Spec.Priority > matched[j].Spec.Priority
+		}
+		return matched[i].Name < matched[j].Name
+	})
+	return matched[0]
+}
+
+func poolSelectsNode(pool *aiav1alpha1.AnycastIPPool, node *corev1.Node) bool {
+	if pool.DeletionTimestamp != nil {
+		return false
+	}
+	if pool.Spec.NodeSelector == nil {
+		return true
+	}
+	selector, err := metav1.LabelSelectorAsSelector(pool.Spec.NodeSelector)
+	if err != nil {
+		klog.Warningf("anycast ip pool %s has an invalid node selector, err: %v", pool.Name, err)
+		return false
+	}
+	return selector.Matches(labels.Set(node.Labels))
+}
+
+// MapPoolToNodes enqueues the aia nodes selected by a changed pool, so that nodes waiting for an address
+// pick up the new pool
+func (r *reconciler) MapPoolToNodes(obj client.Object) []reconcile.Request {
+	pool, ok := obj.(*aiav1alpha1.AnycastIPPool)
+	if !ok {
+		return nil
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.k8sClient.List(context.TODO(), nodes); err != nil {
+		klog.Errorf("list nodes for anycast ip pool %s failed, err: %v", pool.Name, err)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		if !r.AiaManger.IsAiaNode(r.Config().Node.Labels, node) || !poolSelectsNode(pool, node) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Name: node.Name}})
+	}
+	klog.V(2).Infof("anycast ip pool %s changed, enqueue %d nodes", pool.Name, len(requests))
+	return requests
+}
@@ -0,0 +1,86 @@
+package aia
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileAllocatesFromSelectedPool(t *testing.T) {
+	tc := newTestContext(t)
+	tc.createPool("hq", aiav1alpha1.AnycastIPPoolSpec{
+		NodeSelector:       &metav1.LabelSelector{MatchLabels: map[string]string{"pool": "hq"}},
+		AddressType:        constants.EipTypeHighQualityEIP,
+		Bandwidth:          20,
+		InternetChargeType: "BANDWIDTH_POSTPAID_BY_HOUR",
+		Tags:               map[string]string{"team": "hq", "cost-center": "cc1"},
+	})
+	pooled := tc.createNode(map[string]string{testAiaLabel: "true", "pool": "hq"})
+	plain := tc.createNode(map[string]string{testAiaLabel: "true"})
+
+	tc.reconcileUntilDone(pooled.Name)
+	tc.reconcileUntilDone(plain.Name)
+
+	if n := len(tc.cloud.ListAddresses()); n != 2 {
+		t.Fatalf("expect 2 addresses, got %d", n)
+	}
+	for _, addr := range tc.cloud.ListAddresses() {
+		tags := tc.cloud.ResourceTags(*addr.AddressId)
+		switch tags[constants.AiaNodeNameAnnoKey] {
+		case pooled.Name:
+			if *addr.AddressType != constants.EipTypeHighQualityEIP || *addr.Bandwidth != 20 ||
+				*addr.InternetChargeType != "BANDWIDTH_POSTPAID_BY_HOUR" {
+				t.Errorf("expect address of pool hq, got type %s bandwidth %d charge type %s",
+					*addr.AddressType, *addr.Bandwidth, *addr.InternetChargeType)
+			}
+			if tags["team"] != "hq" || tags["cost-center"] != "cc1" || tags[constants.AiaPoolNameAnnoKey] != "hq" {
+				t.Errorf("expect pool tags to override config tags, got %v", tags)
+			}
+		case plain.Name:
+			if *addr.AddressType != constants.EipTypeAnyCast || *addr.Bandwidth != testBandwidth {
+				t.Errorf("expect address of aia config, got type %s bandwidth %d", *addr.AddressType, *addr.Bandwidth)
+			}
+			if tags["team"] != "edge" || tags[constants.AiaPoolNameAnnoKey] != "" {
+				t.Errorf("expect config tags only, got %v", tags)
+			}
+		default:
+			t.Errorf("unexpected address %s with tags %v", *addr.AddressId, tags)
+		}
+	}
+}
+
+func TestSelectPool(t *testing.T) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: "node-1", Labels: map[string]string{"zone": "a"}}}
+	pool := func(name string, priority int32, matchLabels map[string]string) aiav1alpha1.AnycastIPPool {
+		p := aiav1alpha1.AnycastIPPool{ObjectMeta: metav1.ObjectMeta{Name: name}}
+		p.Spec.Priority = priority
+		if matchLabels != nil {
+			p.Spec.NodeSelector = &metav1.LabelSelector{MatchLabels: matchLabels}
+		}
+		return p
+	}
+
+	cases := []struct {
+		name  string
+		pools []aiav1alpha1.AnycastIPPool
+		want  string
+	}{
+		{"no pool", nil, ""},
+		{"not selected", []aiav1alpha1.AnycastIPPool{pool("b", 0, map[string]string{"zone": "b"})}, ""},
+		{"empty selector selects all", []aiav1alpha1.AnycastIPPool{pool("all", 0, nil)}, "all"},
+		{"higher priority wins", []aiav1alpha1.AnycastIPPool{pool("all", 0, nil), pool("za", 10, map[string]string{"zone": "a"})}, "za"},
+		{"same priority ordered by name", []aiav1alpha1.AnycastIPPool{pool("y", 1, nil), pool("x", 1, nil)}, "x"},
+	}
+	for _, c := range cases {
+		got := ""
+		if p := selectPool(c.pools, node); p != nil {
+			got = p.Name
+		}
+		if got != c.want {
+			t.Errorf("%s: expect pool %q, got %q", c.name, c.want, got)
+		}
+	}
+}
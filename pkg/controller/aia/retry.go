@@ -0,0 +1,30 @@
+package aia
+
+import (
+	"time"
+
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+)
+
+const (
+	// throttledRetryInterval is how long to wait before retrying a call rejected by the request rate limit
+	throttledRetryInterval = 5 * time.Second
+	// permanentRetryInterval is how long to wait before retrying a call that would fail the same way until the
+	// quota, the credential or the configuration is fixed
+	permanentRetryInterval = 5 * time.Minute
+)
+
+// retryInterval returns how long to wait before reconciling again after err by its cloud error class, zero for
+// errors retried by the backoff of the workqueue, including those of unknown codes
+func retryInterval(err error) time.Duration {
+	if err == nil {
+		return 0
+	}
+	switch clouderrors.ClassOf(err) {
+	case clouderrors.ClassThrottled:
+		return throttledRetryInterval
+	case clouderrors.ClassQuotaExceeded, clouderrors.ClassAuth, clouderrors.ClassPermanent:
+		return permanentRetryInterval
+	}
+	return 0
+}
@@ -0,0 +1,253 @@
+package aia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/klog/v2"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/predicate"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// wantsAnycastIps returns whether the service is annotated for the anycast ips of aia nodes in its load balancer status
+func wantsAnycastIps(svc *corev1.Service) bool {
+	return svc.Spec.Type == corev1.ServiceTypeLoadBalancer && svc.Annotations[constants.ServiceAnycastIpAnnotationKey] == "true"
+}
+
+// isAnycastService returns whether the load balancer status of the service is published by aia-ip-controller,
+// the service must also have its load balancer class so that no other service controller writes the status
+func isAnycastService(svc *corev1.Service) bool {
+	return wantsAnycastIps(svc) && svc.Spec.LoadBalancerClass != nil &&
+		*svc.Spec.LoadBalancerClass == constants.ServiceLoadBalancerClass
+}
+
+// isNodeReady returns whether the Ready condition of the node is true
+func isNodeReady(node *corev1.Node) bool {
+	for _, c := range node.Status.Conditions {
+		if c.Type == corev1.NodeReady {
+			return c.Status == corev1.ConditionTrue
+		}
+	}
+	return false
+}
+
+// serviceReconciler publishes the anycast ips of the ready aia nodes backing annotated LoadBalancer services
+// in their load balancer status
+type serviceReconciler struct {
+	*reconciler
+}
+
+// ServiceReconciler returns the reconciler of services, it shares the manager and config of the node reconciler
+func (r *reconciler) ServiceReconciler() reconcile.Reconciler {
+	return &serviceReconciler{reconciler: r}
+}
+
+func (r *serviceReconciler) Reconcile(ctx context.Context, req ctrl.Request) (_ ctrl.Result, err error) {
+	defer func() {
+		if errors.Is(err, errDryRun) {
+			err = nil
+		}
+	}()
+
+	svc := &corev1.Service{}
+	err = r.k8sClient.Get(ctx, req.NamespacedName, svc)
+	if apierrors.IsNotFound(err) {
+		return ctrl.Result{}, nil
+	}
+	if err != nil {
+		return ctrl.Result{}, fmt.Errorf("could not fetch service %s: %+v", req.NamespacedName, err)
+	}
+	if svc.DeletionTimestamp != nil {
+		return ctrl.Result{}, nil
+	}
+	if !isAnycastService(svc) {
+		if wantsAnycastIps(svc) {
+			r.eventRecorder.Eventf(svc, corev1.EventTypeWarning, constants.MissingLoadBalancerClass,
+				"Anycast ips are only published for services with loadBalancerClass %s", constants.ServiceLoadBalancerClass)
+		}
+		// the service is no longer an anycast service, remove the anycast ips published before
+		return ctrl.Result{}, r.clearAnycastIngress(ctx, svc)
+	}
+
+	ips, err := r.serviceAnycastIps(ctx, svc)
+	if err != nil {
+		return ctrl.Result{}, err
+	}
+	ingress := make([]corev1.LoadBalancerIngress, 0, len(ips))
+	for _, ip := range ips {
+		ingress = append(ingress, corev1.LoadBalancerIngress{IP: ip})
+	}
+	return ctrl.Result{}, r.updateServiceIngress(ctx, svc, ingress)
+}
+
+// updateServiceIngress writes ingress to the load balancer status of the service if it changed
+func (r *serviceReconciler) updateServiceIngress(ctx context.Context, svc *corev1.Service, ingress []corev1.LoadBalancerIngress) error {
+	if equality.Semantic.DeepEqual(svc.Status.LoadBalancer.Ingress, ingress) ||
+		(len(svc.Status.LoadBalancer.Ingress) == 0 && len(ingress) == 0) {
+		return nil
+	}
+	key := types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+	if r.dryRun.skip(nil, "UpdateServiceStatus", "set load balancer ingress of service %s to %v", key, ingress) {
+		return nil
+	}
+	svc.Status.LoadBalancer.Ingress = ingress
+	if err := r.k8sClient.Status().Update(ctx, svc); err != nil {
+		klog.Errorf("update load balancer status of service %s failed, err: %v", key, err)
+		return err
+	}
+	klog.Infof("load balancer ingress of service %s updated to %v", key, ingress)
+	return nil
+}
+
+// clearAnycastIngress removes the anycast ips of aia nodes from the load balancer status of the service,
+// the ingress written by others is kept
+func (r *serviceReconciler) clearAnycastIngress(ctx context.Context, svc *corev1.Service) error {
+	if len(svc.Status.LoadBalancer.Ingress) == 0 {
+		return nil
+	}
+	nodes := &corev1.NodeList{}
+	if err := r.k8sClient.List(ctx, nodes); err != nil {
+		klog.Errorf("list nodes for service %s/%s failed, err: %v", svc.Namespace, svc.Name, err)
+		return err
+	}
+	anycastIps := make(map[string]bool, len(nodes.Items))
+	for i := range nodes.Items {
+		if ip := nodes.Items[i].Annotations[constants.AnycastIpIpAnnotationKey]; ip != "" {
+			anycastIps[ip] = true
+		}
+	}
+	ingress := make([]corev1.LoadBalancerIngress, 0, len(svc.Status.LoadBalancer.Ingress))
+	for _, in := range svc.Status.LoadBalancer.Ingress {
+		if !anycastIps[in.IP] {
+			ingress = append(ingress, in)
+		}
+	}
+	return r.updateServiceIngress(ctx, svc, ingress)
+}
+
+// serviceAnycastIps returns the sorted anycast ips of the ready aia nodes backing the service. With the Local
+// external traffic policy only nodes running endpoints of the service back it, otherwise all of them do.
+func (r *serviceReconciler) serviceAnycastIps(ctx context.Context, svc *corev1.Service) ([]string, error) {
+	var endpointNodes map[string]bool
+	if svc.Spec.ExternalTrafficPolicy == corev1.ServiceExternalTrafficPolicyTypeLocal {
+		endpoints := &corev1.Endpoints{}
+		err := r.k8sClient.Get(ctx, types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, endpoints)
+		if err != nil && !apierrors.IsNotFound(err) {
+			klog.Errorf("get endpoints of service %s/%s failed, err: %v", svc.Namespace, svc.Name, err)
+			return nil, err
+		}
+		endpointNodes = make(map[string]bool)
+		for _, subset := range endpoints.Subsets {
+			for _, addr := range subset.Addresses {
+				if addr.NodeName != nil {
+					endpointNodes[*addr.NodeName] = true
+				}
+			}
+		}
+	}
+
+	nodes := &corev1.NodeList{}
+	if err := r.k8sClient.List(ctx, nodes); err != nil {
+		klog.Errorf("list nodes for service %s/%s failed, err: %v", svc.Namespace, svc.Name, err)
+		return nil, err
+	}
+	ips := make([]string, 0)
+	for i := range nodes.Items {
+		node := &nodes.Items[i]
+		ip := node.Annotations[constants.AnycastIpIpAnnotationKey]
+		if ip == "" || node.DeletionTimestamp != nil || !isNodeReady(node) ||
+			!r.AiaManger.IsAiaNode(r.Config().Node.Labels, node) {
+			continue
+		}
+		if endpointNodes != nil && !endpointNodes[node.Name] {
+			continue
+		}
+		ips = append(ips, ip)
+	}
+	sort.Strings(ips)
+	return ips, nil
+}
+
+// ServicePredicate passes the events of annotated LoadBalancer services, and the updates of services that were
+// annotated before so that their anycast ips are removed
+func (r *reconciler) ServicePredicate() predicate.Funcs {
+	relevant := func(obj client.Object) bool {
+		svc, ok := obj.(*corev1.Service)
+		return ok && wantsAnycastIps(svc)
+	}
+	return predicate.Funcs{
+		CreateFunc: func(e event.CreateEvent) bool { return relevant(e.Object) },
+		UpdateFunc: func(e event.UpdateEvent) bool { return relevant(e.ObjectNew) || relevant(e.ObjectOld) },
+		DeleteFunc: func(e event.DeleteEvent) bool { return false },
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// ServiceNodePredicate passes the node events that may change the anycast ips backing services: a node added or
+// removed, becoming ready or not, or its anycast ip or labels changed
+func (r *reconciler) ServiceNodePredicate() predicate.Funcs {
+	return predicate.Funcs{
+		UpdateFunc: func(e event.UpdateEvent) bool {
+			oldNode, ok := e.ObjectOld.(*corev1.Node)
+			newNode, ok2 := e.ObjectNew.(*corev1.Node)
+			if !ok || !ok2 {
+				return false
+			}
+			return isNodeReady(oldNode) != isNodeReady(newNode) ||
+				oldNode.Annotations[constants.AnycastIpIpAnnotationKey] != newNode.Annotations[constants.AnycastIpIpAnnotationKey] ||
+				(oldNode.DeletionTimestamp == nil) != (newNode.DeletionTimestamp == nil) ||
+				!equality.Semantic.DeepEqual(oldNode.Labels, newNode.Labels)
+		},
+		GenericFunc: func(e event.GenericEvent) bool {
+			return false
+		},
+	}
+}
+
+// MapNodeToServices enqueues all annotated LoadBalancer services when a node changes
+func (r *reconciler) MapNodeToServices(obj client.Object) []reconcile.Request {
+	services := &corev1.ServiceList{}
+	if err := r.k8sClient.List(context.TODO(), services); err != nil {
+		klog.Errorf("list services for node %s failed, err: %v", obj.GetName(), err)
+		return nil
+	}
+	requests := make([]reconcile.Request, 0)
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if !isAnycastService(svc) {
+			continue
+		}
+		requests = append(requests, reconcile.Request{NamespacedName: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}})
+	}
+	return requests
+}
+
+// MapEndpointsToService enqueues the service of the endpoints if it is annotated or has the load balancer class
+// of aia-ip-controller, the endpoints of other services change too often to requeue them
+func (r *reconciler) MapEndpointsToService(obj client.Object) []reconcile.Request {
+	key := types.NamespacedName{Namespace: obj.GetNamespace(), Name: obj.GetName()}
+	svc := &corev1.Service{}
+	if err := r.k8sClient.Get(context.TODO(), key, svc); err != nil {
+		if !apierrors.IsNotFound(err) {
+			klog.Errorf("get service of endpoints %s failed, err: %v", key, err)
+		}
+		return nil
+	}
+	hasClass := svc.Spec.LoadBalancerClass != nil && *svc.Spec.LoadBalancerClass == constants.ServiceLoadBalancerClass
+	if !wantsAnycastIps(svc) && !hasClass {
+		return nil
+	}
+	return []reconcile.Request{{NamespacedName: key}}
+}
@@ -0,0 +1,172 @@
+package aia
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/controller/util"
+)
+
+// setNodeReady sets the Ready condition of the node
+func (tc *testContext) setNodeReady(name string, ready bool) {
+	node := tc.getNode(name)
+	status := corev1.ConditionFalse
+	if ready {
+		status = corev1.ConditionTrue
+	}
+	node.Status.Conditions = []corev1.NodeCondition{{Type: corev1.NodeReady, Status: status}}
+	if err := tc.k8sClient.Status().Update(context.TODO(), node); err != nil {
+		tc.t.Fatalf("update status of node %s failed: %v", name, err)
+	}
+}
+
+// createService creates the service, it is deleted when the test ends
+func (tc *testContext) createService(svc *corev1.Service) types.NamespacedName {
+	if err := tc.k8sClient.Create(context.TODO(), svc); err != nil {
+		tc.t.Fatalf("create service failed: %v", err)
+	}
+	tc.t.Cleanup(func() {
+		_ = tc.k8sClient.Delete(context.TODO(), svc)
+	})
+	return types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}
+}
+
+// serviceIngressIps reconciles the service and returns the ips in its load balancer status
+func (tc *testContext) serviceIngressIps(key types.NamespacedName) []string {
+	if _, err := tc.r.ServiceReconciler().Reconcile(context.TODO(), ctrl.Request{NamespacedName: key}); err != nil {
+		tc.t.Fatalf("reconcile service %s failed: %v", key, err)
+	}
+	svc := &corev1.Service{}
+	if err := tc.k8sClient.Get(context.TODO(), key, svc); err != nil {
+		tc.t.Fatalf("get service %s failed: %v", key, err)
+	}
+	ips := make([]string, 0)
+	for _, ingress := range svc.Status.LoadBalancer.Ingress {
+		ips = append(ips, ingress.IP)
+	}
+	return ips
+}
+
+func TestReconcileServicePublishesReadyNodeAddresses(t *testing.T) {
+	tc := newTestContext(t)
+	ready := tc.createNode(map[string]string{testAiaLabel: "true"})
+	notReady := tc.createNode(map[string]string{testAiaLabel: "true"})
+	for _, name := range []string{ready.Name, notReady.Name} {
+		tc.reconcileUntilDone(name)
+	}
+	tc.setNodeReady(ready.Name, true)
+	tc.setNodeReady(notReady.Name, false)
+	readyIp := tc.getNode(ready.Name).Annotations[constants.AnycastIpIpAnnotationKey]
+	notReadyIp := tc.getNode(notReady.Name).Annotations[constants.AnycastIpIpAnnotationKey]
+
+	svc := &corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "edge",
+			Namespace:   "default",
+			Annotations: map[string]string{constants.ServiceAnycastIpAnnotationKey: "true"},
+		},
+		Spec: corev1.ServiceSpec{
+			Type:              corev1.ServiceTypeLoadBalancer,
+			LoadBalancerClass: common.StringPtr(constants.ServiceLoadBalancerClass),
+			Ports:             []corev1.ServicePort{{Port: 80}},
+		},
+	}
+	key := tc.createService(svc)
+
+	if got := tc.serviceIngressIps(key); !reflect.DeepEqual(got, []string{readyIp}) {
+		t.Errorf("expect service %s to publish %s of ready node, got %v", key, readyIp, got)
+	}
+
+	tc.setNodeReady(notReady.Name, true)
+	if got := tc.serviceIngressIps(key); len(got) != 2 || !util.ContainString(got, notReadyIp) {
+		t.Errorf("expect service %s to publish %s once node %s is ready, got %v", key, notReadyIp, notReady.Name, got)
+	}
+
+	tc.deleteNode(ready.Name)
+	if got := tc.serviceIngressIps(key); !reflect.DeepEqual(got, []string{notReadyIp}) {
+		t.Errorf("expect service %s to drop %s of deleted node, got %v", key, readyIp, got)
+	}
+}
+
+func TestReconcileServiceClearsAnycastIpsOnceNoLongerAnnotated(t *testing.T) {
+	tc := newTestContext(t)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+	tc.setNodeReady(node.Name, true)
+	ip := tc.getNode(node.Name).Annotations[constants.AnycastIpIpAnnotationKey]
+
+	newService := func(name string, class *string) *corev1.Service {
+		return &corev1.Service{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:        name,
+				Namespace:   "default",
+				Annotations: map[string]string{constants.ServiceAnycastIpAnnotationKey: "true"},
+			},
+			Spec: corev1.ServiceSpec{
+				Type:              corev1.ServiceTypeLoadBalancer,
+				LoadBalancerClass: class,
+				Ports:             []corev1.ServicePort{{Port: 80}},
+			},
+		}
+	}
+
+	// the service controller of the cloud provider owns the status of services without the class
+	unclassed := tc.createService(newService("unclassed", nil))
+	if got := tc.serviceIngressIps(unclassed); len(got) != 0 {
+		t.Errorf("expect no anycast ip published for service %s without load balancer class, got %v", unclassed, got)
+	}
+	if !hasEvent(tc.events(), "Warning", constants.MissingLoadBalancerClass) {
+		t.Errorf("expect event %s", constants.MissingLoadBalancerClass)
+	}
+
+	key := tc.createService(newService("edge", common.StringPtr(constants.ServiceLoadBalancerClass)))
+	if got := tc.serviceIngressIps(key); !reflect.DeepEqual(got, []string{ip}) {
+		t.Fatalf("expect service %s to publish %s, got %v", key, ip, got)
+	}
+	svc := &corev1.Service{}
+	if err := tc.k8sClient.Get(context.TODO(), key, svc); err != nil {
+		t.Fatalf("get service %s failed: %v", key, err)
+	}
+	delete(svc.Annotations, constants.ServiceAnycastIpAnnotationKey)
+	if err := tc.k8sClient.Update(context.TODO(), svc); err != nil {
+		t.Fatalf("update service %s failed: %v", key, err)
+	}
+	if got := tc.serviceIngressIps(key); len(got) != 0 {
+		t.Errorf("expect anycast ips removed from service %s once no longer annotated, got %v", key, got)
+	}
+}
+
+func TestMapEndpointsToAnycastServiceOnly(t *testing.T) {
+	tc := newTestContext(t)
+	anycast := tc.createService(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "edge", Namespace: "default",
+			Annotations: map[string]string{constants.ServiceAnycastIpAnnotationKey: "true"}},
+		Spec: corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, LoadBalancerClass: common.StringPtr(constants.ServiceLoadBalancerClass),
+			Ports: []corev1.ServicePort{{Port: 80}}},
+	})
+	other := tc.createService(&corev1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "web", Namespace: "default"},
+		Spec:       corev1.ServiceSpec{Type: corev1.ServiceTypeLoadBalancer, Ports: []corev1.ServicePort{{Port: 80}}},
+	})
+
+	for _, c := range []struct {
+		key  types.NamespacedName
+		want int
+	}{
+		{anycast, 1},
+		{other, 0},
+		{types.NamespacedName{Namespace: "default", Name: "gone"}, 0},
+	} {
+		endpoints := &corev1.Endpoints{ObjectMeta: metav1.ObjectMeta{Namespace: c.key.Namespace, Name: c.key.Name}}
+		if got := tc.r.MapEndpointsToService(endpoints); len(got) != c.want {
+			t.Errorf("expect %d requests for endpoints %s, got %v", c.want, c.key, got)
+		}
+	}
+}
@@ -0,0 +1,148 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// staticReleasePolicy returns the release policy of static addresses in the config, Detach if it is not set
+func staticReleasePolicy(conf *config.YamlValueConfig) string {
+	if conf.Aia.StaticReleasePolicy != "" {
+		return conf.Aia.StaticReleasePolicy
+	}
+	return config.StaticReleasePolicyDetach
+}
+
+// applyBindingStatic assigns the static address in the spec of the binding to the node, unless the annotations
+// of the node assign one
+func applyBindingStatic(node *corev1.Node, binding *aiav1alpha1.NodeAddressBinding, spec *AddressSpec) {
+	if binding.Spec.StaticAddressID == "" || spec.StaticAddressID != "" {
+		return
+	}
+	spec.StaticAddressID = binding.Spec.StaticAddressID
+	if _, ok := node.Annotations[constants.AnycastIpStaticReleasePolicyAnnotationKey]; !ok &&
+		binding.Spec.StaticReleasePolicy != "" {
+		spec.StaticReleasePolicy = binding.Spec.StaticReleasePolicy
+	}
+}
+
+// staticTags returns the tags the static address of the node should have, those of an allocated address
+// and the release policy
+func (m *MangerImp) staticTags(node *corev1.Node, spec *AddressSpec) map[string]string {
+	tags := m.addressTags(node, spec)
+	tags[constants.AiaStaticPolicyAnnoKey] = spec.StaticReleasePolicy
+	return tags
+}
+
+// adoptStaticAnycastIp takes the static address of spec as the address of the node instead of allocating one.
+// The address must be of the address type of spec, UNBIND or bound to the node, and not owned by anyone else.
+func (m *MangerImp) adoptStaticAnycastIp(node *corev1.Node, spec *AddressSpec) (string, error) {
+	id := spec.StaticAddressID
+	addr, err := m.DescribeAnycastIp(id)
+	if err != nil {
+		return "", err
+	}
+	if addr == nil {
+		return "", m.failAdopt(node, id, "it is not found")
+	}
+	tags := vpcTagMap(addr.TagSet)
+	if tags[constants.AiaIpControllerClusterUuidAnnoKey] == m.clusterUuid && tags[constants.AiaNodeNameAnnoKey] == node.Name {
+		// adopted in a previous round
+		return id, nil
+	}
+	if uuid := tags[constants.AiaIpControllerClusterUuidAnnoKey]; uuid != "" && uuid != m.clusterUuid {
+		return "", m.failAdopt(node, id, fmt.Sprintf("it is owned by cluster %s", tags[constants.AiaIpControllerClusterIdAnnoKey]))
+	}
+	if owner := tags[constants.AiaNodeNameAnnoKey]; owner != "" {
+		return "", m.failAdopt(node, id, fmt.Sprintf("it is owned by node %s", owner))
+	}
+	if addrType := stringValue(addr.AddressType); addrType != spec.AddressType {
+		return "", m.failAdopt(node, id, fmt.Sprintf("it is %s rather than %s", addrType, spec.AddressType))
+	}
+	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	status := stringValue(addr.AddressStatus)
+	if status != constants.AnycastStatusUnBind &&
+		!(status == constants.AnycastStatusBIND && stringValue(addr.InstanceId) == cvmInsId) {
+		return "", m.failAdopt(node, id, fmt.Sprintf("its status is %s bound to %q, it should be %s", status,
+			stringValue(addr.InstanceId), constants.AnycastStatusUnBind))
+	}
+
+	klog.Infof("adopt static anycast ip %s for node %s, release policy %s", id, node.Name, spec.StaticReleasePolicy)
+	if err := m.attachTags(node, id, m.staticTags(node, spec)); err != nil {
+		return "", err
+	}
+	m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpAdopted,
+		"Static anycast ip %s/%s adopted, release policy %s", id, stringValue(addr.AddressIp), spec.StaticReleasePolicy)
+	return id, nil
+}
+
+// failAdopt records why the static address cannot be adopted for the node, and returns it as an error
+func (m *MangerImp) failAdopt(node *corev1.Node, anycastIpId, reason string) error {
+	m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAdoptAnycastIp,
+		"Failed to adopt static anycast ip %s (will retry): %s", anycastIpId, reason)
+	return fmt.Errorf("cannot adopt static anycast ip %s for node %s: %s", anycastIpId, node.Name, reason)
+}
+
+// DetachAnycastIp removes the tags aia-ip-controller set on the address, so that it is no longer managed.
+// The release policy tag is removed last, so that an interrupted detach is not taken for a release.
+func (m *MangerImp) DetachAnycastIp(anycastIpId string) error {
+	addr, err := m.DescribeAnycastIp(anycastIpId)
+	if err != nil || addr == nil {
+		return err
+	}
+	tags := vpcTagMap(addr.TagSet)
+	keys := make([]string, 0)
+	for _, k := range reservedTagKeys {
+		if _, ok := tags[k]; ok {
+			keys = append(keys, k)
+		}
+	}
+	if err := m.detachTags(nil, anycastIpId, keys); err != nil {
+		return err
+	}
+	klog.Infof("anycast ip %s detached, tags %v removed", anycastIpId, keys)
+	return nil
+}
+
+// detachStaticAddress disassociates the static address of a deleted node and detaches it if its release policy
+// is Detach, false is returned if the address should be released as usual
+func (r *reconciler) detachStaticAddress(nodeName, anycastId string, tags map[string]string) (bool, error) {
+	if tags[constants.AiaStaticPolicyAnnoKey] != config.StaticReleasePolicyDetach {
+		return false, nil
+	}
+	if err := r.AiaManger.DisassociateAnycastIp(anycastId); err != nil {
+		if !isInProgress(err) {
+			klog.Errorf("DisassociateAnycastIp %s failed, err: %v", anycastId, err)
+		}
+		return true, err
+	}
+	if err := r.AiaManger.DetachAnycastIp(anycastId); err != nil {
+		return true, err
+	}
+	klog.Infof("static anycast ip %s of deleted node %s is detached instead of released", anycastId, nodeName)
+	return true, nil
+}
+
+// fillBindingInstance records the instance of the node in a binding created without it, e.g. by the user to
+// assign a static address before the node joins
+func (r *reconciler) fillBindingInstance(ctx context.Context, node *corev1.Node, binding *aiav1alpha1.NodeAddressBinding) error {
+	insId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	if binding.Spec.InstanceID != "" || insId == "" {
+		return nil
+	}
+	binding.Spec.InstanceID = insId
+	if r.dryRun.skip(binding, "UpdateNodeAddressBinding", "set instance of binding to %s", insId) {
+		return nil
+	}
+	if err := r.k8sClient.Update(ctx, binding); err != nil {
+		klog.Errorf("update instance of NodeAddressBinding %s failed, err: %v", binding.Name, err)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,111 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	ctrl "sigs.k8s.io/controller-runtime"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func TestReconcileAdoptsAndDetachesStaticAddress(t *testing.T) {
+	tc := newTestContext(t)
+	id := tc.cloud.AddAddress(vpc.Address{AddressType: common.StringPtr(constants.EipTypeAnyCast)},
+		map[string]string{"contract": "fixed"})
+	node := tc.createAnnotatedNode(map[string]string{constants.AnycastIpStaticIdAnnotationKey: id})
+
+	tc.reconcileUntilDone(node.Name)
+
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != 0 {
+		t.Errorf("expect no address allocated, got %d AllocateAddresses calls", n)
+	}
+	if got := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got != id {
+		t.Errorf("expect node %s bound to static anycast ip %s, got %s", node.Name, id, got)
+	}
+	tags := tc.cloud.ResourceTags(id)
+	if tags[constants.AiaNodeNameAnnoKey] != node.Name || tags[constants.AiaStaticPolicyAnnoKey] != config.StaticReleasePolicyDetach {
+		t.Errorf("expect static anycast ip %s tagged for node %s with policy %s, got %v", id, node.Name,
+			config.StaticReleasePolicyDetach, tags)
+	}
+	if !hasEvent(tc.events(), "Normal", constants.AnycastIpAdopted) {
+		t.Errorf("expect event %s", constants.AnycastIpAdopted)
+	}
+
+	tc.deleteNode(node.Name)
+	tc.reconcileUntilDone(node.Name)
+	tc.cloud.Settle()
+	addr, ok := tc.cloud.GetAddress(id)
+	if !ok {
+		t.Fatalf("expect static anycast ip %s detached rather than released", id)
+	}
+	if stringValue(addr.AddressStatus) != constants.AnycastStatusUnBind {
+		t.Errorf("expect static anycast ip %s unbound, got %s", id, stringValue(addr.AddressStatus))
+	}
+	tags = tc.cloud.ResourceTags(id)
+	for _, k := range reservedTagKeys {
+		if _, ok := tags[k]; ok {
+			t.Errorf("expect tag %s removed from detached anycast ip %s, got %v", k, id, tags)
+		}
+	}
+	if tags["contract"] != "fixed" {
+		t.Errorf("expect tags of the user kept on anycast ip %s, got %v", id, tags)
+	}
+}
+
+func TestReconcileRejectsStaticAddressOfAnotherType(t *testing.T) {
+	tc := newTestContext(t)
+	id := tc.cloud.AddAddress(vpc.Address{AddressType: common.StringPtr(constants.EipTypeCommon)}, nil)
+	node := tc.createAnnotatedNode(map[string]string{constants.AnycastIpStaticIdAnnotationKey: id})
+
+	req := ctrl.Request{NamespacedName: types.NamespacedName{Name: node.Name}}
+	for i := 0; i < 3; i++ {
+		if _, err := tc.r.Reconcile(context.TODO(), req); err == nil {
+			t.Errorf("expect reconcile round %d to fail", i)
+		}
+	}
+
+	if _, ok := tc.cloud.ResourceTags(id)[constants.AiaNodeNameAnnoKey]; ok {
+		t.Errorf("expect static anycast ip %s of another type not adopted", id)
+	}
+	if n := tc.cloud.Calls(cloudfake.ActionAllocateAddresses); n != 0 {
+		t.Errorf("expect no address allocated instead, got %d AllocateAddresses calls", n)
+	}
+	if !hasEvent(tc.events(), "Warning", constants.FailedAdoptAnycastIp) {
+		t.Errorf("expect event %s", constants.FailedAdoptAnycastIp)
+	}
+}
+
+func TestReconcileReleasesStaticAddressOfBinding(t *testing.T) {
+	tc := newTestContext(t)
+	id := tc.cloud.AddAddress(vpc.Address{AddressType: common.StringPtr(constants.EipTypeAnyCast)}, nil)
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	binding := &aiav1alpha1.NodeAddressBinding{
+		ObjectMeta: metav1.ObjectMeta{Name: node.Name},
+		Spec: aiav1alpha1.NodeAddressBindingSpec{
+			NodeName:            node.Name,
+			StaticAddressID:     id,
+			StaticReleasePolicy: config.StaticReleasePolicyRelease,
+		},
+	}
+	if err := tc.k8sClient.Create(context.TODO(), binding); err != nil {
+		t.Fatalf("create binding failed: %v", err)
+	}
+
+	tc.reconcileUntilDone(node.Name)
+	if got := tc.getBinding(node.Name); got.Status.AddressID != id || got.Spec.InstanceID != node.Labels[constants.TkeNodeInsIdAnnoKey] {
+		t.Errorf("expect binding of static anycast ip %s with instance, got %+v", id, got)
+	}
+
+	tc.deleteNode(node.Name)
+	tc.reconcileUntilDone(node.Name)
+	if _, ok := tc.cloud.GetAddress(id); ok {
+		t.Errorf("expect static anycast ip %s released by policy %s", id, config.StaticReleasePolicyRelease)
+	}
+}
@@ -0,0 +1,196 @@
+package aia
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/klog/v2"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+// stickyIdentityOfNode returns the value of the sticky identity label of the node, empty if sticky mode is off
+func stickyIdentityOfNode(conf *config.YamlValueConfig, node *corev1.Node) string {
+	if conf.Aia.StickyIdentityLabel == "" || node == nil {
+		return ""
+	}
+	return node.Labels[conf.Aia.StickyIdentityLabel]
+}
+
+// takeStickyAnycastIp moves the address of the identity of spec to the node, empty id is returned if the identity
+// has no address. The address of a node that still exists is not taken.
+func (m *MangerImp) takeStickyAnycastIp(node *corev1.Node, spec *AddressSpec) (string, error) {
+	found, id, err := m.getAnycastIpByTag(constants.AiaNodeIdentityAnnoKey, spec.Identity)
+	if err != nil || !found {
+		return "", err
+	}
+	addr, err := m.DescribeAnycastIp(id)
+	if err != nil || addr == nil {
+		return "", err
+	}
+	tags := vpcTagMap(addr.TagSet)
+	if tags[constants.AiaNodeNameAnnoKey] == node.Name {
+		return id, nil
+	}
+	cvmInsId := node.Labels[constants.TkeNodeInsIdAnnoKey]
+	if insId := stringValue(addr.InstanceId); insId != "" && insId != cvmInsId {
+		m.eventRecorder.Eventf(node, corev1.EventTypeWarning, constants.FailedAllocateAnycastIp,
+			"Anycast ip %s of identity %s is still bound to %s of node %s", id, spec.Identity, insId,
+			tags[constants.AiaNodeNameAnnoKey])
+		return "", fmt.Errorf("anycast ip %s of identity %s is bound to another instance %s", id, spec.Identity, insId)
+	}
+
+	klog.Infof("reuse anycast ip %s of identity %s from node %s for node %s", id, spec.Identity,
+		tags[constants.AiaNodeNameAnnoKey], node.Name)
+	if err := m.attachTags(node, id, nodeTags(node, spec)); err != nil {
+		return "", err
+	}
+	if _, ok := tags[constants.AiaRetainedSinceAnnoKey]; ok {
+		if err := m.detachTags(node, id, []string{constants.AiaRetainedSinceAnnoKey}); err != nil {
+			return "", err
+		}
+	}
+	m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.AnycastIpReused,
+		"Anycast ip %s/%s of identity %s reused", id, stringValue(addr.AddressIp), spec.Identity)
+	return id, nil
+}
+
+// stickyRetentionSyncPeriod is how often retained addresses are checked for expiry
+const stickyRetentionSyncPeriod = 10 * time.Minute
+
+// RetainAnycastIp marks the address of a deleted node as retained since now. The time is rounded up to the hour
+// to keep the number of tag values low, so an address is retained up to an hour longer, never shorter.
+func (m *MangerImp) RetainAnycastIp(anycastIpId string) error {
+	now := time.Now()
+	since := now.Truncate(time.Hour)
+	if since.Before(now) {
+		since = since.Add(time.Hour)
+	}
+	return m.attachTags(nil, anycastIpId, map[string]string{
+		constants.AiaRetainedSinceAnnoKey: strconv.FormatInt(since.Unix(), 10)})
+}
+
+// ListRetainedAnycastIps returns the addresses of the cluster that have the aia-retained-since tag
+func (m *MangerImp) ListRetainedAnycastIps() ([]*vpc.Address, error) {
+	return m.listTaggedAddresses(constants.AiaRetainedSinceAnnoKey)
+}
+
+// stickyRetention returns how long the address of a deleted node is retained, 0 if sticky mode is off
+func (r *reconciler) stickyRetention() time.Duration {
+	conf := r.Config()
+	if conf.Aia.StickyIdentityLabel == "" {
+		return 0
+	}
+	if conf.Aia.StickyRetention.Duration > 0 {
+		return conf.Aia.StickyRetention.Duration
+	}
+	return config.DefaultStickyRetention
+}
+
+// retainStickyAddress disassociates and retains the address of a deleted node if it has an identity in sticky
+// mode, false is returned if the address should be released as usual
+func (r *reconciler) retainStickyAddress(nodeName string, addr *vpc.Address) (bool, error) {
+	if r.stickyRetention() == 0 {
+		return false, nil
+	}
+	tags := vpcTagMap(addr.TagSet)
+	identity := tags[constants.AiaNodeIdentityAnnoKey]
+	if identity == "" {
+		return false, nil
+	}
+	id := stringValue(addr.AddressId)
+	if err := r.AiaManger.DisassociateAnycastIp(id); err != nil {
+		if !isInProgress(err) {
+			klog.Errorf("DisassociateAnycastIp %s failed, err: %v", id, err)
+		}
+		return true, err
+	}
+	if _, ok := tags[constants.AiaRetainedSinceAnnoKey]; !ok {
+		if err := r.AiaManger.RetainAnycastIp(id); err != nil {
+			return true, err
+		}
+	}
+	klog.Infof("anycast ip %s of deleted node %s is retained for identity %s", id, nodeName, identity)
+	return true, nil
+}
+
+// isRetentionExpired returns whether the address retained since the unix time since can be released,
+// an address without a valid time is not
+func (r *reconciler) isRetentionExpired(since string) bool {
+	sec, err := strconv.ParseInt(since, 10, 64)
+	if err != nil {
+		return false
+	}
+	return time.Since(time.Unix(sec, 0)) > r.stickyRetention()
+}
+
+// isRetainedStickyAddress returns whether reverse reconcile should keep the address of a node that no longer
+// exists. An address with an identity whose deletion was missed starts its retention now.
+func (r *reconciler) isRetainedStickyAddress(anycastId string, tags map[string]string) bool {
+	if r.stickyRetention() == 0 || tags[constants.AiaNodeIdentityAnnoKey] == "" {
+		return false
+	}
+	since, ok := tags[constants.AiaRetainedSinceAnnoKey]
+	if !ok {
+		if err := r.AiaManger.RetainAnycastIp(anycastId); err != nil && !errors.Is(err, errDryRun) {
+			klog.Warningf("retain anycast ip %s of identity %s failed, err: %v", anycastId, tags[constants.AiaNodeIdentityAnnoKey], err)
+		}
+		return true
+	}
+	if !r.isRetentionExpired(since) {
+		return true
+	}
+	klog.Infof("retention of anycast ip %s of identity %s expired, release it", anycastId, tags[constants.AiaNodeIdentityAnnoKey])
+	return false
+}
+
+// ReleaseExpiredStickyAddresses releases the retained addresses whose retention expired, unless they were taken
+// back by a node in the meantime
+func (r *reconciler) ReleaseExpiredStickyAddresses(ctx context.Context) {
+	addrs, err := r.AiaManger.ListRetainedAnycastIps()
+	if err != nil {
+		klog.Errorf("list retained anycast ips failed, err: %v", err)
+		return
+	}
+	for _, addr := range addrs {
+		id := stringValue(addr.AddressId)
+		tags := vpcTagMap(addr.TagSet)
+		if !r.isRetentionExpired(tags[constants.AiaRetainedSinceAnnoKey]) {
+			continue
+		}
+		if stringValue(addr.InstanceId) != "" || stringValue(addr.NetworkInterfaceId) != "" ||
+			stringValue(addr.AddressStatus) != constants.AnycastStatusUnBind {
+			klog.Warningf("retained anycast ip %s is %s, leave it", id, stringValue(addr.AddressStatus))
+			continue
+		}
+		if nodeName := tags[constants.AiaNodeNameAnnoKey]; nodeName != "" {
+			err := r.k8sClient.Get(ctx, types.NamespacedName{Name: nodeName}, &corev1.Node{})
+			if err == nil {
+				klog.Infof("retained anycast ip %s is taken by node %s, leave it", id, nodeName)
+				continue
+			}
+			if !apierrors.IsNotFound(err) {
+				klog.Errorf("get node %s of retained anycast ip %s failed, err: %v", nodeName, id, err)
+				continue
+			}
+		}
+		klog.Infof("retention of anycast ip %s of identity %s expired, release it", id, tags[constants.AiaNodeIdentityAnnoKey])
+		if err := r.AiaManger.ReleaseAnycastIp(id); err != nil && !errors.Is(err, errDryRun) {
+			klog.Errorf("release expired retained anycast ip %s failed, err: %v", id, err)
+		}
+	}
+}
+
+// RunStickyRetention releases expired retained addresses periodically until ctx is done, it runs on the leader
+// only. It does not depend on reverse reconcile, which is off by default.
+func (r *reconciler) RunStickyRetention(ctx context.Context) {
+	wait.UntilWithContext(ctx, r.ReleaseExpiredStickyAddresses, stickyRetentionSyncPeriod)
+}
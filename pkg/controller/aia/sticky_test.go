@@ -0,0 +1,127 @@
+package aia
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+const testStickyLabel = "slot"
+
+func (tc *testContext) enableSticky() {
+	conf := *tc.r.Config()
+	conf.Aia.StickyIdentityLabel = testStickyLabel
+	if err := tc.r.ReloadConfig(&conf); err != nil {
+		tc.t.Fatalf("ReloadConfig failed: %v", err)
+	}
+}
+
+func TestReconcileReusesAddressOfReplacedNode(t *testing.T) {
+	tc := newTestContext(t)
+	tc.enableSticky()
+	old := tc.createNode(map[string]string{testAiaLabel: "true", testStickyLabel: "1"})
+	tc.reconcileUntilDone(old.Name)
+	id := tc.getNode(old.Name).Annotations[constants.AnycastIpIdAnnotationKey]
+	if id == "" {
+		t.Fatalf("expect node %s to have an anycast ip", old.Name)
+	}
+
+	tc.deleteNode(old.Name)
+	tc.reconcileUntilDone(old.Name)
+	tc.cloud.Settle()
+	addr, ok := tc.cloud.GetAddress(id)
+	if !ok {
+		t.Fatalf("expect anycast ip %s retained after node %s deleted", id, old.Name)
+	}
+	if addr.InstanceId != nil && *addr.InstanceId != "" {
+		t.Errorf("expect retained anycast ip %s unbound, got %s", id, *addr.InstanceId)
+	}
+	if _, ok := tc.cloud.ResourceTags(id)[constants.AiaRetainedSinceAnnoKey]; !ok {
+		t.Errorf("expect retained anycast ip %s tagged with %s", id, constants.AiaRetainedSinceAnnoKey)
+	}
+
+	replacement := tc.createNode(map[string]string{testAiaLabel: "true", testStickyLabel: "1"})
+	tc.reconcileUntilDone(replacement.Name)
+	if got := tc.getNode(replacement.Name).Annotations[constants.AnycastIpIdAnnotationKey]; got != id {
+		t.Errorf("expect node %s to reuse anycast ip %s, got %s", replacement.Name, id, got)
+	}
+	tags := tc.cloud.ResourceTags(id)
+	if tags[constants.AiaNodeNameAnnoKey] != replacement.Name {
+		t.Errorf("expect anycast ip %s tagged for node %s, got %v", id, replacement.Name, tags)
+	}
+	if _, ok := tags[constants.AiaRetainedSinceAnnoKey]; ok {
+		t.Errorf("expect tag %s removed from reused anycast ip %s", constants.AiaRetainedSinceAnnoKey, id)
+	}
+	if !hasEvent(tc.events(), "Normal", constants.AnycastIpReused) {
+		t.Errorf("expect event %s", constants.AnycastIpReused)
+	}
+}
+
+func TestReverseReconcileReleasesExpiredStickyAddress(t *testing.T) {
+	tc := newTestContext(t)
+	tc.enableSticky()
+	tc.r.isLeader = true
+	retainedSince := func(d time.Duration) string {
+		return strconv.FormatInt(time.Now().Add(-d).Unix(), 10)
+	}
+	addressTags := func(identity, since string) map[string]string {
+		return map[string]string{
+			constants.AiaIpControllerClusterUuidAnnoKey: tc.r.clusterUuid,
+			constants.AiaNodeNameAnnoKey:                "gone-" + identity,
+			constants.AiaNodeIdentityAnnoKey:            identity,
+			constants.AiaRetainedSinceAnnoKey:           since,
+		}
+	}
+	expired := tc.cloud.AddAddress(vpc.Address{}, addressTags("1", retainedSince(48*time.Hour)))
+	retained := tc.cloud.AddAddress(vpc.Address{}, addressTags("2", retainedSince(time.Hour)))
+
+	tc.r.ReverseReconcile()
+
+	if _, ok := tc.cloud.GetAddress(expired); ok {
+		t.Errorf("expect anycast ip %s released after its retention expired", expired)
+	}
+	if _, ok := tc.cloud.GetAddress(retained); !ok {
+		t.Errorf("expect anycast ip %s kept during its retention", retained)
+	}
+}
+
+func TestReleaseExpiredStickyAddressesWithoutReverseReconcile(t *testing.T) {
+	tc := newTestContext(t)
+	tc.enableSticky()
+	node := tc.createNode(map[string]string{testAiaLabel: "true", testStickyLabel: "3"})
+	expiredSince := strconv.FormatInt(time.Now().Add(-48*time.Hour).Unix(), 10)
+	addressTags := func(nodeName, identity string) map[string]string {
+		return map[string]string{
+			constants.AiaIpControllerClusterUuidAnnoKey: tc.r.clusterUuid,
+			constants.AiaNodeNameAnnoKey:                nodeName,
+			constants.AiaNodeIdentityAnnoKey:            identity,
+			constants.AiaRetainedSinceAnnoKey:           expiredSince,
+		}
+	}
+	expired := tc.cloud.AddAddress(vpc.Address{}, addressTags("gone-1", "1"))
+	taken := tc.cloud.AddAddress(vpc.Address{}, addressTags(node.Name, "3"))
+	fresh := tc.cloud.AddAddress(vpc.Address{}, nil)
+	if err := tc.r.AiaManger.RetainAnycastIp(fresh); err != nil {
+		t.Fatalf("retain anycast ip %s failed: %v", fresh, err)
+	}
+	since, _ := strconv.ParseInt(tc.cloud.ResourceTags(fresh)[constants.AiaRetainedSinceAnnoKey], 10, 64)
+	if time.Unix(since, 0).Before(time.Now().Add(-time.Second)) {
+		t.Errorf("expect retention of anycast ip %s not to start in the past, got %v", fresh, time.Unix(since, 0))
+	}
+
+	tc.r.ReleaseExpiredStickyAddresses(context.TODO())
+
+	if _, ok := tc.cloud.GetAddress(expired); ok {
+		t.Errorf("expect anycast ip %s released after its retention expired", expired)
+	}
+	if _, ok := tc.cloud.GetAddress(taken); !ok {
+		t.Errorf("expect anycast ip %s taken by node %s kept", taken, node.Name)
+	}
+	if _, ok := tc.cloud.GetAddress(fresh); !ok {
+		t.Errorf("expect anycast ip %s kept during its retention", fresh)
+	}
+}
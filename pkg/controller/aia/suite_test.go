@@ -0,0 +1,245 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientset "k8s.io/client-go/kubernetes"
+	kubefake "k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/tools/record"
+	"k8s.io/klog/v2/klogr"
+	ctrl "sigs.k8s.io/controller-runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	ctrlfake "sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/envtest"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+const (
+	testClusterId  = "cls-test"
+	testRegion     = "ap-guangzhou"
+	testAiaLabel   = "tke.cloud.tencent.com/need-aia-ip"
+	testBandwidth  = 100
+	reconcileLimit = 20
+)
+
+// the suite runs against a real apiserver started by envtest if KUBEBUILDER_ASSETS is set,
+// otherwise it falls back to the fake clients of controller-runtime and client-go.
+var (
+	testEnv     *envtest.Environment
+	testScheme  = runtime.NewScheme()
+	envK8s      client.Client
+	envKube     clientset.Interface
+	nodeCounter int32
+)
+
+func TestMain(m *testing.M) {
+	if err := corev1.AddToScheme(testScheme); err != nil {
+		panic(err)
+	}
+	if err := aiav1alpha1.AddToScheme(testScheme); err != nil {
+		panic(err)
+	}
+	ctrl.SetLogger(klogr.New())
+
+	if os.Getenv("KUBEBUILDER_ASSETS") != "" {
+		testEnv = &envtest.Environment{CRDDirectoryPaths: []string{"../../../hack/deploy/crd"}}
+		cfg, err := testEnv.Start()
+		if err != nil {
+			panic(fmt.Sprintf("start envtest failed: %v", err))
+		}
+		envK8s, err = client.New(cfg, client.Options{Scheme: testScheme})
+		if err != nil {
+			panic(err)
+		}
+		envKube, err = clientset.NewForConfig(cfg)
+		if err != nil {
+			panic(err)
+		}
+	}
+
+	code := m.Run()
+
+	if testEnv != nil {
+		if err := testEnv.Stop(); err != nil {
+			fmt.Fprintf(os.Stderr, "stop envtest failed: %v\n", err)
+		}
+	}
+	os.Exit(code)
+}
+
+// testContext bundles a reconciler with the fake cloud and the event recorder it uses
+type testContext struct {
+	t          *testing.T
+	r          *reconciler
+	cloud      *cloudfake.Cloud
+	recorder   *record.FakeRecorder
+	k8sClient  client.Client
+	kubeClient clientset.Interface
+}
+
+func newTestContext(t *testing.T) *testContext {
+	k8sClient, kubeClient := envK8s, envKube
+	if testEnv == nil {
+		k8sClient = ctrlfake.NewClientBuilder().WithScheme(testScheme).Build()
+		kubeClient = kubefake.NewSimpleClientset()
+	}
+
+	fakeCloud := cloudfake.NewCloud()
+	fakeCloud.Region = testRegion
+	recorder := record.NewFakeRecorder(100)
+	controllerConfig := &config.ControllerConfig{
+		MaxAiaIpControllerConcurrentReconciles: 1,
+		EnableAnycastIPPool:                    true,
+		EnableNodeAddressBinding:               true,
+		ConfigFileConf: &config.YamlValueConfig{
+			Region:     config.RegionConfig{LongName: testRegion},
+			Credential: config.CredentialConfig{ClusterID: testClusterId},
+			Aia: config.AiaConfig{
+				Tags:        map[string]string{"team": "edge"},
+				Bandwidth:   testBandwidth,
+				AnycastZone: "ANYCAST_ZONE_OVERSEAS",
+			},
+			Node: config.NodeConfig{Labels: map[string]string{testAiaLabel: "true"}},
+		},
+	}
+
+	r, err := NewReconcile(k8sClient, kubeClient, recorder, controllerConfig, fakeCloud.Clients(), klogr.New())
+	if err != nil {
+		t.Fatalf("NewReconcile failed: %v", err)
+	}
+	return &testContext{
+		t:          t,
+		r:          r,
+		cloud:      fakeCloud,
+		recorder:   recorder,
+		k8sClient:  k8sClient,
+		kubeClient: kubeClient,
+	}
+}
+
+// createNode creates a node with a unique name and a registered cvm instance
+func (tc *testContext) createNode(labels map[string]string) *corev1.Node {
+	n := atomic.AddInt32(&nodeCounter, 1)
+	insId := fmt.Sprintf("ins-%08d", n)
+	tc.cloud.AddInstance(insId, fmt.Sprintf("10.0.0.%d", n%250+1))
+
+	nodeLabels := map[string]string{constants.TkeNodeInsIdAnnoKey: insId}
+	for k, v := range labels {
+		nodeLabels[k] = v
+	}
+	node := &corev1.Node{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   fmt.Sprintf("10.0.0.%d", n),
+			Labels: nodeLabels,
+		},
+	}
+	if err := tc.k8sClient.Create(context.TODO(), node); err != nil {
+		tc.t.Fatalf("create node failed: %v", err)
+	}
+	tc.t.Cleanup(func() {
+		_ = tc.k8sClient.Delete(context.TODO(), node)
+	})
+	return node
+}
+
+// createPool creates an anycast ip pool which is deleted when the test finishes
+func (tc *testContext) createPool(name string, spec aiav1alpha1.AnycastIPPoolSpec) *aiav1alpha1.AnycastIPPool {
+	pool := &aiav1alpha1.AnycastIPPool{
+		ObjectMeta: metav1.ObjectMeta{Name: name},
+		Spec:       spec,
+	}
+	if err := tc.k8sClient.Create(context.TODO(), pool); err != nil {
+		tc.t.Fatalf("create pool failed: %v", err)
+	}
+	tc.t.Cleanup(func() {
+		_ = tc.k8sClient.Delete(context.TODO(), pool)
+	})
+	return pool
+}
+
+func (tc *testContext) getNode(name string) *corev1.Node {
+	node := &corev1.Node{}
+	if err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: name}, node); err != nil {
+		tc.t.Fatalf("get node %s failed: %v", name, err)
+	}
+	return node
+}
+
+// getBinding returns the NodeAddressBinding of the node, or nil if it does not exist
+func (tc *testContext) getBinding(name string) *aiav1alpha1.NodeAddressBinding {
+	binding := &aiav1alpha1.NodeAddressBinding{}
+	err := tc.k8sClient.Get(context.TODO(), types.NamespacedName{Name: name}, binding)
+	if errors.IsNotFound(err) {
+		return nil
+	}
+	if err != nil {
+		tc.t.Fatalf("get binding %s failed: %v", name, err)
+	}
+	return binding
+}
+
+func (tc *testContext) deleteNode(name string) {
+	node := &corev1.Node{ObjectMeta: metav1.ObjectMeta{Name: name}}
+	if err := tc.k8sClient.Delete(context.TODO(), node); err != nil && !errors.IsNotFound(err) {
+		tc.t.Fatalf("delete node %s failed: %v", name, err)
+	}
+}
+
+// reconcileUntilDone calls Reconcile until it returns no error and no requeue, and returns how many rounds it took
+func (tc *testContext) reconcileUntilDone(name string) int {
+	for i := 1; i <= reconcileLimit; i++ {
+		res, err := tc.r.Reconcile(context.TODO(), ctrl.Request{NamespacedName: types.NamespacedName{Name: name}})
+		if err == nil && !res.Requeue && res.RequeueAfter == 0 {
+			return i
+		}
+		tc.t.Logf("reconcile %s round %d: result %+v, err %v", name, i, res, err)
+		time.Sleep(time.Millisecond)
+	}
+	tc.t.Fatalf("reconcile %s not done after %d rounds", name, reconcileLimit)
+	return reconcileLimit
+}
+
+// events drains the recorded events
+func (tc *testContext) events() []string {
+	res := make([]string, 0)
+	for {
+		select {
+		case e := <-tc.recorder.Events:
+			res = append(res, e)
+		default:
+			return res
+		}
+	}
+}
+
+func hasEvent(events []string, eventType, reason string) bool {
+	for _, e := range events {
+		if strings.HasPrefix(e, eventType+" "+reason+" ") {
+			return true
+		}
+	}
+	return false
+}
+
+func hasNoAiaTaint(node *corev1.Node) bool {
+	for _, taint := range node.Spec.Taints {
+		if taint.Key == constants.NoAnycastIpTaintKey {
+			return true
+		}
+	}
+	return false
+}
@@ -5,7 +5,9 @@ import (
 
 	corev1 "k8s.io/api/core/v1"
 	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 	"sigs.k8s.io/controller-runtime/pkg/event"
+	"tkestack.io/aia-ip-controller/cmd/aia-ip-controller/app/config"
 	"tkestack.io/aia-ip-controller/pkg/constants"
 )
 
@@ -18,7 +20,7 @@ func (r *reconciler) ProcessNodeUpdate(updateEvent event.UpdateEvent) bool {
 			klog.V(4).Infof("update event old node and new node type assertion are not ok, return false, not going to enqueue")
 			return false
 		}
-		return r.AiaManger.IsAiaNode(r.Conf.Node.Labels, newNode)
+		return r.AiaManger.IsAiaNode(r.Config().Node.Labels, newNode)
 	}
 
 	newNode, ok2 := updateEvent.ObjectNew.(*corev1.Node)
@@ -30,12 +32,27 @@ func (r *reconciler) ProcessNodeUpdate(updateEvent event.UpdateEvent) bool {
 	cvmInsId := newNode.Labels[constants.TkeNodeInsIdAnnoKey]
 	klog.V(2).Infof("watched node %s(%s) update event", newNode.Name, cvmInsId)
 
+	// a deleting node with finalizer needs to release its anycast ip, the force remove annotation may be
+	// added while deleting
+	if newNode.DeletionTimestamp != nil && controllerutil.ContainsFinalizer(newNode, constants.NodeFinalizer) {
+		klog.V(2).Infof("node %s with finalizer is deleting, going to enqueue", newNode.Name)
+		return true
+	}
+
 	// new and old node are the same, not process
 	if reflect.DeepEqual(oldNode.ObjectMeta.Labels, newNode.ObjectMeta.Labels) {
 		klog.V(4).Infof("node %s meta.labels not changed, not going to enqueue", newNode.Name)
 		return false
 	}
 
+	// a node losing the labels needs its anycast ip released or disassociated, unless the policy is to ignore it
+	conf := r.Config()
+	if r.AiaManger.IsAiaNode(conf.Node.Labels, oldNode) && !r.AiaManger.IsAiaNode(conf.Node.Labels, newNode) {
+		policy := conf.Node.LabelRemovalPolicy
+		klog.V(2).Infof("node %s lost aia labels, label removal policy is %q", newNode.Name, policy)
+		return policy != "" && policy != config.LabelRemovalPolicyIgnore
+	}
+
 	// check if the node has all specified labels
-	return r.AiaManger.IsAiaNode(r.Conf.Node.Labels, newNode)
+	return r.AiaManger.IsAiaNode(conf.Node.Labels, newNode)
 }
@@ -0,0 +1,324 @@
+package aia
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/klog/v2"
+	aiav1alpha1 "tkestack.io/aia-ip-controller/api/v1alpha1"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+	"tkestack.io/aia-ip-controller/pkg/metrics"
+)
+
+const (
+	// warmPoolSyncPeriod is how often the warm pools are refilled, besides after a standby address is taken
+	warmPoolSyncPeriod = time.Minute
+	// warmPoolAllocateBatch is the max number of standby addresses allocated for a pool in one sync
+	warmPoolAllocateBatch = 20
+	// releaseAddressesBatch is the max number of addresses released by one ReleaseAddresses call
+	releaseAddressesBatch  = 20
+	describeAddressesLimit = 100
+)
+
+// warmTagKeys are the tags marking an address as standby, they are detached when it is taken by a node
+var warmTagKeys = []string{constants.AiaWarmPoolAnnoKey, constants.AiaWarmSpecAnnoKey}
+
+// warmPoolName returns the value of the aia-warm-pool tag of the standby addresses of spec
+func warmPoolName(spec *AddressSpec) string {
+	if spec.Pool != "" {
+		return spec.Pool
+	}
+	return constants.WarmPoolDefault
+}
+
+// warmSpecHash returns the value of the aia-warm-spec tag of the standby addresses of spec. Only the fields
+// that cannot be modified after allocation are hashed, the others are converged once a node takes the address.
+func warmSpecHash(spec *AddressSpec) string {
+	zone := ""
+	if spec.AddressType == constants.EipTypeAnyCast {
+		zone = spec.AnycastZone
+	}
+	h := fnv.New32a()
+	_, _ = fmt.Fprintf(h, "%s/%s/%s", spec.AddressType, zone, spec.InternetChargeType)
+	return fmt.Sprintf("%08x", h.Sum32())
+}
+
+// WarmPoolTaken returns a channel signaled when a standby address is taken or a warm pool is found empty
+func (m *MangerImp) WarmPoolTaken() <-chan struct{} {
+	return m.warmPoolTaken
+}
+
+func (m *MangerImp) notifyWarmPoolTaken() {
+	select {
+	case m.warmPoolTaken <- struct{}{}:
+	default:
+	}
+}
+
+// takeWarmAnycastIp moves a standby address of the warm pool of spec to the node, the node tags are attached
+// before the warm tags are detached, so that the address is found by GetAnycastIpByTags if it fails in between.
+// Empty id is returned if the pool has no standby address ready.
+func (m *MangerImp) takeWarmAnycastIp(node *corev1.Node, spec *AddressSpec) (string, error) {
+	pool := warmPoolName(spec)
+	m.warmPoolLock.Lock()
+	defer m.warmPoolLock.Unlock()
+
+	addrs, err := m.listTaggedAddresses(constants.AiaWarmPoolAnnoKey)
+	if err != nil {
+		return "", err
+	}
+	hash := warmSpecHash(spec)
+	for _, addr := range addrs {
+		tags := vpcTagMap(addr.TagSet)
+		if tags[constants.AiaWarmPoolAnnoKey] != pool || tags[constants.AiaWarmSpecAnnoKey] != hash ||
+			tags[constants.AiaNodeNameAnnoKey] != "" || stringValue(addr.AddressStatus) != constants.AnycastStatusUnBind {
+			continue
+		}
+		id := stringValue(addr.AddressId)
+		klog.Infof("take standby anycast ip %s of warm pool %s for node %s", id, pool, node.Name)
+		if err := m.attachTags(node, id, nodeTags(node, spec)); err != nil {
+			return "", err
+		}
+		if err := m.detachTags(node, id, warmTagKeys); err != nil {
+			return "", err
+		}
+		metrics.WarmPoolHandoutTotal.WithLabelValues(pool, "hit").Inc()
+		m.eventRecorder.Eventf(node, corev1.EventTypeNormal, constants.WarmAnycastIpAssigned,
+			"Standby anycast ip %s/%s of warm pool %s assigned", id, stringValue(addr.AddressIp), pool)
+		m.notifyWarmPoolTaken()
+		return id, nil
+	}
+	klog.Infof("warm pool %s has no standby anycast ip ready for node %s", pool, node.Name)
+	metrics.WarmPoolHandoutTotal.WithLabelValues(pool, "miss").Inc()
+	m.notifyWarmPoolTaken()
+	return "", nil
+}
+
+// SyncWarmPools keeps spec.WarmPoolSize standby addresses for each spec. Standby addresses of pools not in specs,
+// of an outdated spec or beyond the size are released, and the warm tags of those taken by a node are detached.
+func (m *MangerImp) SyncWarmPools(specs []*AddressSpec) error {
+	m.warmPoolLock.Lock()
+	defer m.warmPoolLock.Unlock()
+
+	wanted := make(map[string]*AddressSpec, len(specs))
+	for _, spec := range specs {
+		if spec.WarmPoolSize > 0 {
+			wanted[warmPoolName(spec)] = spec
+		}
+	}
+
+	addrs, err := m.listTaggedAddresses(constants.AiaWarmPoolAnnoKey)
+	if err != nil {
+		return err
+	}
+	standby := make(map[string][]*vpc.Address, len(wanted))
+	release := make([]string, 0)
+	for _, addr := range addrs {
+		id := stringValue(addr.AddressId)
+		tags := vpcTagMap(addr.TagSet)
+		pool := tags[constants.AiaWarmPoolAnnoKey]
+		if tags[constants.AiaNodeNameAnnoKey] != "" {
+			klog.Infof("standby anycast ip %s of warm pool %s was taken by node %s, detach its warm tags", id, pool,
+				tags[constants.AiaNodeNameAnnoKey])
+			if err := m.detachTags(nil, id, warmTagKeys); err != nil {
+				return err
+			}
+			continue
+		}
+		status := stringValue(addr.AddressStatus)
+		if stringValue(addr.InstanceId) != "" || stringValue(addr.NetworkInterfaceId) != "" ||
+			(status != constants.AnycastStatusUnBind && status != "CREATING") {
+			klog.Warningf("standby anycast ip %s of warm pool %s is %s, leave it", id, pool, status)
+			continue
+		}
+		spec, ok := wanted[pool]
+		switch {
+		case !ok || tags[constants.AiaWarmSpecAnnoKey] != warmSpecHash(spec):
+			klog.Infof("standby anycast ip %s of warm pool %s is no longer wanted", id, pool)
+		case len(standby[pool]) >= spec.WarmPoolSize:
+			klog.Infof("standby anycast ip %s of warm pool %s exceeds its size %d", id, pool, spec.WarmPoolSize)
+		default:
+			standby[pool] = append(standby[pool], addr)
+			continue
+		}
+		if status == constants.AnycastStatusUnBind {
+			release = append(release, id)
+		}
+	}
+	if err := m.releaseStandbyAddresses(release); err != nil {
+		return err
+	}
+
+	metrics.WarmPoolAddresses.Reset()
+	for pool, spec := range wanted {
+		metrics.WarmPoolAddresses.WithLabelValues(pool).Set(float64(len(standby[pool])))
+		count := spec.WarmPoolSize - len(standby[pool])
+		if count <= 0 {
+			continue
+		}
+		if count > warmPoolAllocateBatch {
+			count = warmPoolAllocateBatch
+		}
+		if err := m.allocateStandbyAddresses(spec, count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// allocateStandbyAddresses allocates count addresses of spec tagged with the warm tags instead of the node tags
+func (m *MangerImp) allocateStandbyAddresses(spec *AddressSpec, count int) (err error) {
+	defer func(start time.Time) { metrics.ObserveOperation("AllocateStandbyAnycastIp", start, err) }(time.Now())
+	pool := warmPoolName(spec)
+	tags := m.specTags(spec)
+	tags[constants.AiaWarmPoolAnnoKey] = pool
+	tags[constants.AiaWarmSpecAnnoKey] = warmSpecHash(spec)
+	req := newAllocateRequest(spec, tags)
+	req.AddressCount = common.Int64Ptr(int64(count))
+
+	if m.dryRun.skip(nil, "AllocateAddresses", "allocate %d standby %s of warm pool %s with tags %v", count,
+		spec.AddressType, pool, tags) {
+		return nil
+	}
+	resp, err := m.vpcClient.AllocateAddresses(req)
+	if err != nil {
+		klog.Warningf("allocate %d standby anycast ip of warm pool %s failed, err: %v", count, pool, err)
+		// eip api does not create tags, create them and try again instead of waiting for the next sync
+		if tagCreateErr := m.createTags(nil, tags); tagCreateErr != nil {
+			return fmt.Errorf("AllocateAddresses failed: %s.  CreateTag failed: %s", err.Error(), tagCreateErr.Error())
+		}
+		if resp, err = m.vpcClient.AllocateAddresses(req); err != nil {
+			return err
+		}
+	}
+	if resp == nil || resp.Response == nil {
+		return fmt.Errorf("allocate standby anycast ip of warm pool %s has no response", pool)
+	}
+	klog.Infof("allocate standby anycast ip %v of warm pool %s success", common.StringValues(resp.Response.AddressSet), pool)
+	return nil
+}
+
+func (m *MangerImp) releaseStandbyAddresses(ids []string) error {
+	for start := 0; start < len(ids); start += releaseAddressesBatch {
+		end := start + releaseAddressesBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+		batch := ids[start:end]
+		if m.dryRun.skip(nil, "ReleaseAddresses", "release standby anycast ip %v", batch) {
+			return nil
+		}
+		req := vpc.NewReleaseAddressesRequest()
+		req.AddressIds = common.StringPtrs(batch)
+		if _, err := m.vpcClient.ReleaseAddresses(req); err != nil {
+			klog.Errorf("release standby anycast ip %v failed, err: %v", batch, err)
+			return err
+		}
+		klog.Infof("release standby anycast ip %v success", batch)
+	}
+	return nil
+}
+
+// listTaggedAddresses returns the addresses of the cluster that have the tag key, e.g. aia-warm-pool
+func (m *MangerImp) listTaggedAddresses(tagKey string) ([]*vpc.Address, error) {
+	res := make([]*vpc.Address, 0)
+	for offset := int64(0); ; offset += describeAddressesLimit {
+		req := vpc.NewDescribeAddressesRequest()
+		req.Filters = []*vpc.Filter{
+			{
+				Name:   common.StringPtr("tag:" + constants.AiaIpControllerClusterUuidAnnoKey),
+				Values: common.StringPtrs([]string{m.clusterUuid}),
+			},
+			{
+				Name:   common.StringPtr("tag-key"),
+				Values: common.StringPtrs([]string{tagKey}),
+			},
+			{
+				Name: common.StringPtr("address-type"),
+				Values: common.StringPtrs([]string{constants.EipTypeAnyCast, constants.EipTypeHighQualityEIP,
+					constants.EipTypeCommon}),
+			},
+		}
+		req.Offset = common.Int64Ptr(offset)
+		req.Limit = common.Int64Ptr(describeAddressesLimit)
+		resp, err := m.vpcClient.DescribeAddresses(req)
+		if err != nil {
+			return nil, err
+		}
+		if resp == nil || resp.Response == nil || resp.Response.TotalCount == nil {
+			return nil, fmt.Errorf("DescribeAddresses of tag %s has no response", tagKey)
+		}
+		res = append(res, resp.Response.AddressSet...)
+		if len(resp.Response.AddressSet) == 0 || offset+describeAddressesLimit >= *resp.Response.TotalCount {
+			return res, nil
+		}
+	}
+}
+
+func vpcTagMap(tags []*vpc.Tag) map[string]string {
+	res := make(map[string]string, len(tags))
+	for _, t := range tags {
+		if t != nil && t.Key != nil && t.Value != nil {
+			res[*t.Key] = *t.Value
+		}
+	}
+	return res
+}
+
+// warmPoolSpecs returns the address specs of the aia config and the pools that have a warm pool
+func (r *reconciler) warmPoolSpecs(ctx context.Context) ([]*AddressSpec, error) {
+	specs := make([]*AddressSpec, 0)
+	if spec := r.defaultAddressSpec(nil); spec.WarmPoolSize > 0 {
+		specs = append(specs, spec)
+	}
+	if !r.EnableAnycastIPPool {
+		return specs, nil
+	}
+	pools := &aiav1alpha1.AnycastIPPoolList{}
+	if err := r.k8sClient.List(ctx, pools); err != nil {
+		return nil, err
+	}
+	for i := range pools.Items {
+		pool := &pools.Items[i]
+		if pool.DeletionTimestamp != nil || pool.Spec.WarmPoolSize <= 0 {
+			continue
+		}
+		spec := r.defaultAddressSpec(nil)
+		applyPool(spec, pool)
+		specs = append(specs, spec)
+	}
+	return specs, nil
+}
+
+// SyncWarmPools refills the warm pools of the aia config and the pools
+func (r *reconciler) SyncWarmPools(ctx context.Context) {
+	specs, err := r.warmPoolSpecs(ctx)
+	if err != nil {
+		klog.Errorf("list warm pools failed, err: %v", err)
+		return
+	}
+	if err := r.AiaManger.SyncWarmPools(specs); err != nil {
+		klog.Errorf("sync warm pools failed, err: %v", err)
+	}
+}
+
+// RunWarmPools syncs the warm pools periodically and after a standby address is taken until ctx is done,
+// it runs on the leader only
+func (r *reconciler) RunWarmPools(ctx context.Context) {
+	ticker := time.NewTicker(warmPoolSyncPeriod)
+	defer ticker.Stop()
+	for {
+		r.SyncWarmPools(ctx)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		case <-r.AiaManger.WarmPoolTaken():
+		}
+	}
+}
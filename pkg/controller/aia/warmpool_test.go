@@ -0,0 +1,89 @@
+package aia
+
+import (
+	"context"
+	"testing"
+
+	"tkestack.io/aia-ip-controller/pkg/cloud/fake"
+	"tkestack.io/aia-ip-controller/pkg/constants"
+)
+
+func (tc *testContext) setWarmPoolSize(size int) {
+	conf := *tc.r.Config()
+	conf.Aia.WarmPoolSize = size
+	if err := tc.r.ReloadConfig(&conf); err != nil {
+		tc.t.Fatalf("ReloadConfig failed: %v", err)
+	}
+}
+
+// standbyAddresses returns the ids of addresses with the warm pool tag
+func (tc *testContext) standbyAddresses() []string {
+	res := make([]string, 0)
+	for _, addr := range tc.cloud.ListAddresses() {
+		if _, ok := tc.cloud.ResourceTags(*addr.AddressId)[constants.AiaWarmPoolAnnoKey]; ok {
+			res = append(res, *addr.AddressId)
+		}
+	}
+	return res
+}
+
+func TestWarmPoolHandsOutStandbyAddress(t *testing.T) {
+	tc := newTestContext(t)
+	tc.setWarmPoolSize(2)
+	tc.r.SyncWarmPools(context.TODO())
+	tc.cloud.Settle()
+	if n := len(tc.standbyAddresses()); n != 2 {
+		t.Fatalf("expect 2 standby addresses, got %d", n)
+	}
+	allocated := tc.cloud.Calls(fake.ActionAllocateAddresses)
+
+	node := tc.createNode(map[string]string{testAiaLabel: "true"})
+	tc.reconcileUntilDone(node.Name)
+
+	if n := tc.cloud.Calls(fake.ActionAllocateAddresses); n != allocated {
+		t.Errorf("expect the node to take a standby address without allocating, got %d more calls", n-allocated)
+	}
+	id := tc.getNode(node.Name).Annotations[constants.AnycastIpIdAnnotationKey]
+	tags := tc.cloud.ResourceTags(id)
+	if tags[constants.AiaNodeNameAnnoKey] != node.Name || tags[constants.AiaWarmPoolAnnoKey] != "" {
+		t.Errorf("expect standby address retagged for node %s, got %v", node.Name, tags)
+	}
+	if !hasEvent(tc.events(), "Normal", constants.WarmAnycastIpAssigned) {
+		t.Errorf("expect event %s", constants.WarmAnycastIpAssigned)
+	}
+
+	tc.r.SyncWarmPools(context.TODO())
+	if n := len(tc.standbyAddresses()); n != 2 {
+		t.Errorf("expect warm pool refilled to 2 standby addresses, got %d", n)
+	}
+}
+
+func TestWarmPoolReleasesOutdatedStandbyAddresses(t *testing.T) {
+	tc := newTestContext(t)
+	tc.setWarmPoolSize(2)
+	tc.r.SyncWarmPools(context.TODO())
+	tc.cloud.Settle()
+
+	// standby addresses of another type are no use for new nodes
+	conf := *tc.r.Config()
+	conf.Aia.AddressType = constants.EipTypeHighQualityEIP
+	conf.Aia.WarmPoolSize = 1
+	if err := tc.r.ReloadConfig(&conf); err != nil {
+		t.Fatalf("ReloadConfig failed: %v", err)
+	}
+	tc.r.SyncWarmPools(context.TODO())
+	tc.cloud.Settle()
+	standby := tc.standbyAddresses()
+	if len(standby) != 1 {
+		t.Fatalf("expect 1 standby address, got %v", standby)
+	}
+	if addr, _ := tc.cloud.GetAddress(standby[0]); *addr.AddressType != constants.EipTypeHighQualityEIP {
+		t.Errorf("expect standby HighQualityEIP, got %s", *addr.AddressType)
+	}
+
+	tc.setWarmPoolSize(0)
+	tc.r.SyncWarmPools(context.TODO())
+	if n := len(tc.cloud.ListAddresses()); n != 0 {
+		t.Errorf("expect standby addresses released after warm pool disabled, got %d", n)
+	}
+}
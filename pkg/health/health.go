@@ -0,0 +1,143 @@
+package health
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/common"
+	vpc "github.com/tencentcloud/tencentcloud-sdk-go/tencentcloud/vpc/v20170312"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/wait"
+	clientset "k8s.io/client-go/kubernetes"
+	"k8s.io/klog/v2"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/healthz"
+	"tkestack.io/aia-ip-controller/pkg/cloud"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+)
+
+const (
+	cacheSyncTimeout = time.Second
+
+	// cloudCheckFailureThreshold is how many consecutive failed cloud checks make the pod unready,
+	// auth failures make the pod unready immediately.
+	cloudCheckFailureThreshold = 3
+
+	inClusterNamespacePath = "/var/run/secrets/kubernetes.io/serviceaccount/namespace"
+)
+
+// NewCacheSyncCheck returns a checker which fails until the informer cache has synced
+func NewCacheSyncCheck(c cache.Cache) healthz.Checker {
+	return func(req *http.Request) error {
+		ctx, cancel := context.WithTimeout(req.Context(), cacheSyncTimeout)
+		defer cancel()
+		if !c.WaitForCacheSync(ctx) {
+			return fmt.Errorf("informer cache not synced")
+		}
+		return nil
+	}
+}
+
+// NewLeaderElectionCheck returns a checker which fails if this instance has been elected but the lease
+// is no longer held or renewed by it. Instances that are not elected are standby and considered ready.
+func NewLeaderElectionCheck(kubeClient clientset.Interface, elected <-chan struct{}, enabled bool,
+	lockName string, leaseDuration time.Duration) healthz.Checker {
+	hostname, _ := os.Hostname()
+	namespace := ""
+	if b, err := ioutil.ReadFile(inClusterNamespacePath); err == nil {
+		namespace = strings.TrimSpace(string(b))
+	}
+	return func(req *http.Request) error {
+		if !enabled || namespace == "" || hostname == "" {
+			return nil
+		}
+		select {
+		case <-elected:
+		default:
+			return nil
+		}
+		lease, err := kubeClient.CoordinationV1().Leases(namespace).Get(req.Context(), lockName, metav1.GetOptions{})
+		if err != nil {
+			return fmt.Errorf("get lease %s/%s failed: %v", namespace, lockName, err)
+		}
+		if lease.Spec.HolderIdentity == nil || !strings.HasPrefix(*lease.Spec.HolderIdentity, hostname+"_") {
+			return fmt.Errorf("elected but lease %s/%s is held by %v", namespace, lockName, lease.Spec.HolderIdentity)
+		}
+		if lease.Spec.RenewTime == nil || time.Since(lease.Spec.RenewTime.Time) > leaseDuration {
+			return fmt.Errorf("elected but lease %s/%s has not been renewed for more than %s", namespace, lockName, leaseDuration)
+		}
+		return nil
+	}
+}
+
+// CloudCredentialCheck periodically calls a lightweight vpc api to make sure the credential works
+type CloudCredentialCheck struct {
+	vpcClient cloud.VpcAPI
+	period    time.Duration
+
+	mu       sync.RWMutex
+	checked  bool
+	failures int
+	lastErr  error
+}
+
+// NewCloudCredentialCheck creates a CloudCredentialCheck, it must be added to the manager to start
+func NewCloudCredentialCheck(vpcClient cloud.VpcAPI, period time.Duration) *CloudCredentialCheck {
+	return &CloudCredentialCheck{
+		vpcClient: vpcClient,
+		period:    period,
+	}
+}
+
+// Start runs the check periodically until ctx is done
+func (c *CloudCredentialCheck) Start(ctx context.Context) error {
+	wait.UntilWithContext(ctx, c.probe, c.period)
+	return nil
+}
+
+// NeedLeaderElection returns false, standby instances should also report their credential health
+func (c *CloudCredentialCheck) NeedLeaderElection() bool {
+	return false
+}
+
+func (c *CloudCredentialCheck) probe(_ context.Context) {
+	req := vpc.NewDescribeAddressesRequest()
+	req.Limit = common.Int64Ptr(1)
+	_, err := c.vpcClient.DescribeAddresses(req)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checked = true
+	c.lastErr = err
+	if err == nil {
+		c.failures = 0
+		return
+	}
+	c.failures++
+	klog.Warningf("cloud credential check failed %d time(s), err: %v", c.failures, err)
+}
+
+// Check implements healthz.Checker
+func (c *CloudCredentialCheck) Check(_ *http.Request) error {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if !c.checked {
+		return fmt.Errorf("cloud credential not checked yet")
+	}
+	if c.lastErr == nil {
+		return nil
+	}
+	if clouderrors.ClassOf(c.lastErr) == clouderrors.ClassAuth {
+		return fmt.Errorf("cloud credential rejected: %v", c.lastErr)
+	}
+	if c.failures >= cloudCheckFailureThreshold {
+		return fmt.Errorf("cloud api failed %d times in a row: %v", c.failures, c.lastErr)
+	}
+	return nil
+}
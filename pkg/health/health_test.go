@@ -0,0 +1,47 @@
+package health
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	cloudfake "tkestack.io/aia-ip-controller/pkg/cloud/fake"
+)
+
+func TestCloudCredentialCheck(t *testing.T) {
+	fakeCloud := cloudfake.NewCloud()
+	check := NewCloudCredentialCheck(fakeCloud, time.Minute)
+
+	if err := check.Check(nil); err == nil {
+		t.Errorf("expect not ready before the first probe")
+	}
+	check.probe(context.TODO())
+	if err := check.Check(nil); err != nil {
+		t.Errorf("expect ready after a successful probe, got %v", err)
+	}
+
+	// transient failures are tolerated until the threshold
+	for i := 1; i <= cloudCheckFailureThreshold; i++ {
+		fakeCloud.InjectError(cloudfake.ActionDescribeAddresses, cloudfake.NewError("InternalError", "internal error"))
+		check.probe(context.TODO())
+		err := check.Check(nil)
+		if i < cloudCheckFailureThreshold && err != nil {
+			t.Errorf("expect ready after %d transient failures, got %v", i, err)
+		}
+		if i == cloudCheckFailureThreshold && err == nil {
+			t.Errorf("expect not ready after %d transient failures", i)
+		}
+	}
+
+	check.probe(context.TODO())
+	if err := check.Check(nil); err != nil {
+		t.Errorf("expect ready after recovery, got %v", err)
+	}
+
+	// revoked credential fails at once
+	fakeCloud.InjectError(cloudfake.ActionDescribeAddresses, cloudfake.NewError("AuthFailure.SecretIdNotFound", "secret id not found"))
+	check.probe(context.TODO())
+	if err := check.Check(nil); err == nil {
+		t.Errorf("expect not ready after auth failure")
+	}
+}
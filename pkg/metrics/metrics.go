@@ -0,0 +1,214 @@
+package metrics
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+	clouderrors "tkestack.io/aia-ip-controller/pkg/cloud/errors"
+)
+
+const (
+	namespace = "aia_ip_controller"
+
+	// ResultSuccess and ResultError are the values of result label
+	ResultSuccess = "success"
+	ResultError   = "error"
+
+	// CodeSuccess is the code label of a successful cloud api call, CodeUnknown is used for
+	// errors that do not come from tencent cloud, such as network errors.
+	CodeSuccess = "Success"
+	CodeUnknown = "Unknown"
+)
+
+var (
+	// OperationTotal counts anycast ip operations of the manager, such as AllocateAnycastIp
+	OperationTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "operation_total",
+		Help:      "Number of anycast ip operations, partitioned by operation and result.",
+	}, []string{"operation", "result"})
+
+	// OperationDuration observes how long anycast ip operations of the manager take
+	OperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "operation_duration_seconds",
+		Help:      "Duration of anycast ip operations, partitioned by operation.",
+		Buckets:   prometheus.ExponentialBuckets(0.05, 2, 10),
+	}, []string{"operation"})
+
+	// AsyncOperationDuration observes the time from starting an asynchronous cloud operation, such as
+	// AssociateAddress, to its address settling in the target status or the operation failing
+	AsyncOperationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "async_operation_duration_seconds",
+		Help:      "Time asynchronous cloud operations take until their address settles, partitioned by operation and result.",
+		Buckets:   prometheus.ExponentialBuckets(0.5, 2, 10),
+	}, []string{"operation", "result"})
+
+	// CloudAPIRequestTotal counts tencent cloud api calls
+	CloudAPIRequestTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_requests_total",
+		Help:      "Number of tencent cloud api calls, partitioned by service, action and error code.",
+	}, []string{"service", "action", "code"})
+
+	// CloudAPIErrorsTotal counts failed tencent cloud api calls by error class
+	CloudAPIErrorsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_errors_total",
+		Help:      "Number of failed tencent cloud api calls, partitioned by service, action and error class.",
+	}, []string{"service", "action", "class"})
+
+	// CloudAPIRequestDuration observes how long tencent cloud api calls take
+	CloudAPIRequestDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_request_duration_seconds",
+		Help:      "Duration of tencent cloud api calls, partitioned by service and action.",
+		Buckets:   prometheus.ExponentialBuckets(0.01, 2, 10),
+	}, []string{"service", "action"})
+
+	// CloudAPIThrottledTotal counts tencent cloud api calls rejected by the request rate limit of tencent cloud
+	CloudAPIThrottledTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_throttled_total",
+		Help:      "Number of tencent cloud api calls rejected with RequestLimitExceeded, partitioned by service and action.",
+	}, []string{"service", "action"})
+
+	// CloudAPIRateLimitWait observes how long tencent cloud api calls wait for the client side rate limiter
+	CloudAPIRateLimitWait = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_rate_limit_wait_seconds",
+		Help:      "Time tencent cloud api calls wait for the client side rate limiter, partitioned by service and action.",
+		Buckets:   prometheus.ExponentialBuckets(0.001, 4, 8),
+	}, []string{"service", "action"})
+
+	// CloudAPIRetriesTotal counts tencent cloud api calls retried after an error, by the class of the error
+	CloudAPIRetriesTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cloud_api_retries_total",
+		Help:      "Number of tencent cloud api calls retried, partitioned by service, action and error class.",
+	}, []string{"service", "action", "class"})
+
+	// AddressBatchSize observes the number of addresses allocated or released by one batched cloud api call
+	AddressBatchSize = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "address_batch_size",
+		Help:      "Number of addresses per batched AllocateAddresses or ReleaseAddresses call.",
+		Buckets:   []float64{1, 2, 5, 10, 20},
+	}, []string{"operation"})
+
+	// AddressInventoryAddresses is the number of addresses in the address inventory
+	AddressInventoryAddresses = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "address_inventory_addresses",
+		Help:      "Number of addresses in the in-memory address inventory.",
+	})
+
+	// AddressInventoryLookupsTotal counts lookups of the address inventory, result is hit or miss
+	AddressInventoryLookupsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "address_inventory_lookups_total",
+		Help:      "Number of address inventory lookups, partitioned by index and result.",
+	}, []string{"index", "result"})
+
+	// TaintedNodes is the number of nodes with taint tke.cloud.tencent.com/no-aia-ip
+	TaintedNodes = prometheus.NewGauge(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "tainted_nodes",
+		Help:      "Number of nodes tainted with tke.cloud.tencent.com/no-aia-ip.",
+	})
+
+	// NodeUntaintDuration observes the time from node creation to the removal of its no-aia-ip taint
+	NodeUntaintDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "node_untaint_duration_seconds",
+		Help:      "Time from node creation to the removal of its no-aia-ip taint.",
+		Buckets:   prometheus.ExponentialBuckets(5, 2, 10),
+	})
+
+	// ReverseReconcileReleasedTotal counts addresses released by reverse reconcile
+	ReverseReconcileReleasedTotal = prometheus.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "reverse_reconcile_released_addresses_total",
+		Help:      "Number of legacy addresses released by reverse reconcile.",
+	})
+
+	// DryRunMutationsTotal counts mutations skipped in dry run mode
+	DryRunMutationsTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "dry_run_mutations_total",
+		Help:      "Number of cloud api and kubernetes mutations skipped in dry run mode, partitioned by action.",
+	}, []string{"action"})
+
+	// WarmPoolAddresses is the number of standby addresses in the warm pool
+	WarmPoolAddresses = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "warm_pool_addresses",
+		Help:      "Number of standby addresses in the warm pool, partitioned by pool.",
+	}, []string{"pool"})
+
+	// WarmPoolHandoutTotal counts allocations served by the warm pool, result is hit or miss
+	WarmPoolHandoutTotal = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "warm_pool_handout_total",
+		Help:      "Number of allocations that looked for a standby address, partitioned by pool and result.",
+	}, []string{"pool", "result"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		OperationTotal,
+		OperationDuration,
+		AsyncOperationDuration,
+		CloudAPIRequestTotal,
+		CloudAPIErrorsTotal,
+		CloudAPIRequestDuration,
+		CloudAPIThrottledTotal,
+		CloudAPIRateLimitWait,
+		CloudAPIRetriesTotal,
+		AddressBatchSize,
+		AddressInventoryAddresses,
+		AddressInventoryLookupsTotal,
+		TaintedNodes,
+		NodeUntaintDuration,
+		ReverseReconcileReleasedTotal,
+		DryRunMutationsTotal,
+		WarmPoolAddresses,
+		WarmPoolHandoutTotal,
+	)
+}
+
+// ObserveOperation records an anycast ip operation which started at start and ended with err
+func ObserveOperation(operation string, start time.Time, err error) {
+	result := ResultSuccess
+	if err != nil {
+		result = ResultError
+	}
+	OperationTotal.WithLabelValues(operation, result).Inc()
+	OperationDuration.WithLabelValues(operation).Observe(time.Since(start).Seconds())
+}
+
+// ObserveCloudAPI records a tencent cloud api call which started at start and ended with err
+func ObserveCloudAPI(service, action string, start time.Time, err error) {
+	CloudAPIRequestTotal.WithLabelValues(service, action, ErrorCode(err)).Inc()
+	if err != nil {
+		class := clouderrors.ClassOf(err)
+		CloudAPIErrorsTotal.WithLabelValues(service, action, string(class)).Inc()
+		if class == clouderrors.ClassThrottled {
+			CloudAPIThrottledTotal.WithLabelValues(service, action).Inc()
+		}
+	}
+	CloudAPIRequestDuration.WithLabelValues(service, action).Observe(time.Since(start).Seconds())
+}
+
+// ErrorCode returns the tencent cloud error code of err
+func ErrorCode(err error) string {
+	if err == nil {
+		return CodeSuccess
+	}
+	if code := clouderrors.Code(err); code != "" {
+		return code
+	}
+	return CodeUnknown
+}